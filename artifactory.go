@@ -1,34 +1,34 @@
 package artifactory
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/x509"
-	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"net"
-	"net/http"
-	"net/url"
-	"regexp"
 	"strings"
 
 	jwt "github.com/golang-jwt/jwt/v4"
 	"github.com/hashicorp/go-version"
 	"github.com/hashicorp/vault/sdk/helper/template"
 	"github.com/hashicorp/vault/sdk/logical"
-	"github.com/samber/lo"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
 )
 
 const (
 	defaultUserNameTemplate    string = `{{ printf "v-%s-%s" (.RoleName | truncate 24) (random 8) }}` // Docs indicate max length is 256
-	grantTypeClientCredentials string = "client_credentials"
-	grantTypeRefreshToken      string = "refresh_token"
+	grantTypeClientCredentials string = client.GrantTypeClientCredentials
+	grantTypeRefreshToken      string = client.GrantTypeRefreshToken
 )
 
 var ErrIncompatibleVersion = errors.New("incompatible version")
+var ErrRootCertMismatch = errors.New("root certificate does not match root_cert_sha256")
+var ErrInvalidTokenClaims = errors.New("invalid token claims")
+
+// unverifiedTokenWarning is added to responses when a token was parsed without verifying its signature
+const unverifiedTokenWarning = "Artifactory is older than 7.12.0 and does not provide its root certificate, the access token signature was not verified"
 
 type baseConfiguration struct {
 	AccessToken       string `json:"access_token"`
@@ -36,106 +36,82 @@ type baseConfiguration struct {
 	UseExpiringTokens bool   `json:"use_expiring_tokens,omitempty"`
 	ForceRevocable    *bool  `json:"force_revocable,omitempty"`
 	UseNewAccessAPI   bool   `json:"use_new_access_api,omitempty"`
+	RootCertSHA256    string `json:"root_cert_sha256,omitempty"`
+	// AccessURL and ArtifactoryServiceURL optionally override where the Access and Artifactory APIs are
+	// found, relative to ArtifactoryURL (which holds the configured "url")
+	AccessURL             string            `json:"access_url,omitempty"`
+	ArtifactoryServiceURL string            `json:"artifactory_service_url,omitempty"`
+	RequestHeaders        map[string]string `json:"request_headers,omitempty"`
+	// connection is the name of the connection this configuration was fetched for, see connectionStoragePath
+	connection string
 }
 
-type errorResponse struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
-	Detail  string `json:"detail"`
-}
-
-func (b *backend) RevokeToken(config baseConfiguration, tokenId string) error {
-	if config.AccessToken == "" {
-		return fmt.Errorf("empty access token not allowed")
-	}
-
-	logger := b.Logger().With("func", "RevokeToken")
-
-	u, err := url.Parse(config.ArtifactoryURL)
-	if err != nil {
-		logger.Error("could not parse artifactory url", "url", u, "err", err)
-		return err
-	}
-
-	var resp *http.Response
-	if config.UseNewAccessAPI {
-		resp, err = b.performArtifactoryDelete(config, "/access/api/v1/tokens/"+tokenId)
-		if err != nil {
-			logger.Error("error deleting access token", "tokenId", tokenId, "response", resp, "err", err)
-			return err
-		}
-	} else {
-		path, err := url.JoinPath(u.Path, "/artifactory/api/security/token/revoke")
-		if err != nil {
-			logger.Error("error joining url path", "err", err)
-			return err
-		}
+// getClient returns an Access API client for the active URL of the provided configuration
+func (b *backend) getClient(config baseConfiguration) (client.Client, error) {
+	state := b.connection(config.connection)
+	activeURL, breaker := state.endpoints.current(config.ArtifactoryURL)
 
-		values := url.Values{}
-		values.Set("token_id", tokenId)
+	return b.newEndpointClient(config, state, activeURL, breaker)
+}
 
-		resp, err = b.performArtifactoryPost(config, path, values)
-		if err != nil {
-			logger.Error("error deleting token", "tokenId", tokenId, "response", resp, "err", err)
-			return err
-		}
-	}
-	//noinspection GoUnhandledErrorResult
-	defer resp.Body.Close()
+// newEndpointClient returns an Access API client for one of the URLs of the provided configuration
+func (b *backend) newEndpointClient(config baseConfiguration, state *connectionState, url string, breaker *client.CircuitBreaker) (client.Client, error) {
+	return b.newClient(client.Config{
+		URL:             url,
+		AccessURL:       config.AccessURL,
+		ArtifactoryURL:  config.ArtifactoryServiceURL,
+		Headers:         config.RequestHeaders,
+		AccessToken:     config.AccessToken,
+		UseNewAccessAPI: config.UseNewAccessAPI,
+		HTTPClient:      state.httpClient,
+		UserAgent:       productId,
+		Logger:          b.Logger(),
+		RetryPolicy:     state.retryPolicy,
+		CircuitBreaker:  breaker,
+	})
+}
 
-	if resp.StatusCode >= http.StatusBadRequest {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			logger.Error("revokenToken could not read error response body", "err", err)
-			return fmt.Errorf("could not parse response body. Err: %v", err)
-		}
-		logger.Error("revokenToken got non-200 status code", "statusCode", resp.StatusCode, "body", string(body))
-		return fmt.Errorf("could not revoke tokenID: %v - HTTP response %v", tokenId, string(body))
+// RevokeToken revokes the token with the given ID. A token that Artifactory doesn't know is already revoked.
+func (b *backend) RevokeToken(ctx context.Context, config baseConfiguration, tokenId string) error {
+	err := b.withFailover(ctx, config, true, func(c client.Client) error {
+		return c.RevokeToken(ctx, tokenId)
+	})
+	if client.IsNotFound(err) {
+		b.Logger().With("func", "RevokeToken").Debug("token not found, already revoked", "tokenId", tokenId)
+		return nil
 	}
 
-	return nil
-}
-
-type CreateTokenRequest struct {
-	GrantType             string `json:"grant_type,omitempty"`
-	Username              string `json:"username,omitempty"`
-	Scope                 string `json:"scope,omitempty"`
-	ExpiresIn             int64  `json:"expires_in"`
-	Refreshable           bool   `json:"refreshable,omitempty"`
-	Description           string `json:"description,omitempty"`
-	Audience              string `json:"audience,omitempty"`
-	ForceRevocable        bool   `json:"force_revocable,omitempty"`
-	IncludeReferenceToken bool   `json:"include_reference_token,omitempty"`
-	RefreshToken          string `json:"refresh_token,omitempty"`
+	return err
 }
 
-type artifactoryErrorResponse struct {
-	Errors []errorResponse `json:"errors"`
-}
+// ListTokens returns the details of the tokens visible to the access token of config
+func (b *backend) ListTokens(ctx context.Context, config baseConfiguration) ([]client.TokenDetails, error) {
+	var tokens []client.TokenDetails
+	err := b.withFailover(ctx, config, true, func(c client.Client) (err error) {
+		tokens, err = c.ListTokens(ctx)
+		return
+	})
 
-func (r artifactoryErrorResponse) String() string {
-	return lo.Reduce(r.Errors, func(agg string, e errorResponse, _ int) string {
-		if agg == "" {
-			return e.Message
-		}
-		return fmt.Sprintf("%s, %s", agg, e.Message)
-	}, "")
+	return tokens, err
 }
 
-type TokenExpiredError struct{}
+// GetTokenByID returns the details of the token with the given ID, as seen by the access token of config
+func (b *backend) GetTokenByID(ctx context.Context, config baseConfiguration, tokenID string) (*client.TokenDetails, error) {
+	var details *client.TokenDetails
+	err := b.withFailover(ctx, config, true, func(c client.Client) (err error) {
+		details, err = c.GetTokenByID(ctx, tokenID)
+		return
+	})
 
-func (e *TokenExpiredError) Error() string {
-	return "token has expired"
+	return details, err
 }
 
-var invalidTokenRegex = regexp.MustCompile(`.*Invalid token, expired.*`)
-
-func (b *backend) CreateToken(config baseConfiguration, role artifactoryRole) (*createTokenResponse, error) {
+func (b *backend) CreateToken(ctx context.Context, config baseConfiguration, role artifactoryRole) (*client.CreateTokenResponse, error) {
 	if config.AccessToken == "" {
-		return nil, fmt.Errorf("empty access token not allowed")
+		return nil, client.ErrEmptyAccessToken
 	}
 
-	request := CreateTokenRequest{
+	request := client.CreateTokenRequest{
 		GrantType:             role.GrantType,
 		Username:              role.Username,
 		Scope:                 role.Scope,
@@ -158,7 +134,7 @@ func (b *backend) CreateToken(config baseConfiguration, role artifactoryRole) (*
 	// but the token is still usable even after it's deleted. See RTFACT-15293.
 	request.ExpiresIn = 0 // never expires
 
-	supportForceRevocable, err := b.supportForceRevocable(config)
+	supportForceRevocable, err := b.supportForceRevocable(ctx, config)
 	if err != nil {
 		logger.Error("failed to determine if force_revocable is supported", "err", err)
 		return nil, err
@@ -172,166 +148,38 @@ func (b *backend) CreateToken(config baseConfiguration, role artifactoryRole) (*
 			request.ForceRevocable = true
 		}
 	}
-	u, err := url.Parse(config.ArtifactoryURL)
-	if err != nil {
-		logger.Error("could not parse artifactory url", "url", config.ArtifactoryURL, "err", err)
-		return nil, err
-	}
-
-	path := ""
-
-	var resp *http.Response
-	var createErr error
-
-	if config.UseNewAccessAPI {
-		path = "/access/api/v1/tokens"
-
-		jsonReq, err := json.Marshal(request)
-		if err != nil {
-			return nil, err
-		}
-
-		resp, createErr = b.performArtifactoryPostWithJSON(config, path, jsonReq)
-	} else {
-		path, err = url.JoinPath(u.Path, "/artifactory/api/security/token")
-		if err != nil {
-			logger.Error("error joining url path", "err", err)
-			return nil, err
-		}
-
-		values := url.Values{
-			"grant_type":  []string{grantTypeClientCredentials},
-			"username":    []string{request.Username},
-			"scope":       []string{request.Scope},
-			"expires_in":  []string{fmt.Sprintf("%d", request.ExpiresIn)},
-			"refreshable": []string{fmt.Sprintf("%t", request.Refreshable)},
-			"audience":    []string{request.Audience},
-		}
-
-		resp, createErr = b.performArtifactoryPost(config, path, values)
-	}
-
-	if createErr != nil {
-		logger.Error("error making token request", "response", resp, "err", createErr)
-		return nil, createErr
-	}
-
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		var errResp artifactoryErrorResponse
-		err := json.NewDecoder(resp.Body).Decode(&errResp)
-		if err != nil {
-			logger.Error("could not parse error response", "response", resp, "err", err)
-			return nil, fmt.Errorf("could not create access token. Err: %v", err)
-		}
-
-		if resp.StatusCode == http.StatusUnauthorized && invalidTokenRegex.MatchString(errResp.String()) {
-			return nil, &TokenExpiredError{}
-		}
-
-		logger.Error("got non-200 status code", "statusCode", resp.StatusCode, "message", errResp.String())
-		return nil, fmt.Errorf("could not create access token: %s", errResp)
-	}
 
-	var createdToken createTokenResponse
-	if err := json.NewDecoder(resp.Body).Decode(&createdToken); err != nil {
-		logger.Error("could not parse response", "response", resp, "err", err)
-		return nil, fmt.Errorf("could not create access token. Err: %v", err)
-	}
+	var resp *client.CreateTokenResponse
+	err = b.withFailover(ctx, config, false, func(c client.Client) (err error) {
+		resp, err = c.CreateToken(ctx, request)
+		return
+	})
 
-	return &createdToken, nil
+	return resp, err
 }
 
-func (b *backend) RefreshToken(config baseConfiguration, refreshToken string) (*createTokenResponse, error) {
-	if config.AccessToken == "" {
-		return nil, fmt.Errorf("empty access token not allowed")
-	}
-
-	if refreshToken == "" {
-		return nil, fmt.Errorf("no refresh token supplied")
-	}
-
-	logger := b.Logger().With("func", "RefreshToken")
-
-	u, err := url.Parse(config.ArtifactoryURL)
+func (b *backend) RefreshToken(ctx context.Context, config baseConfiguration, refreshToken string) (*client.CreateTokenResponse, error) {
+	c, err := b.getClient(config)
 	if err != nil {
-		logger.Error("could not parse artifactory url", "url", config.ArtifactoryURL, "err", err)
+		b.Logger().With("func", "RefreshToken").Error("could not create Artifactory client", "err", err)
 		return nil, err
 	}
 
-	request := CreateTokenRequest{
-		GrantType:    grantTypeRefreshToken,
-		RefreshToken: refreshToken,
-	}
-
-	var resp *http.Response
-	var refreshErr error
-
-	if config.UseNewAccessAPI {
-		jsonReq, err := json.Marshal(request)
-		if err != nil {
-			return nil, err
-		}
-
-		resp, refreshErr = b.performArtifactoryPostWithJSON(config, "/access/api/v1/tokens", jsonReq)
-	} else {
-		path, err := url.JoinPath(u.Path, "/artifactory/api/security/token")
-		if err != nil {
-			logger.Error("error joining url path", "err", err)
-			return nil, err
-		}
-
-		values := url.Values{
-			"grant_type":    []string{grantTypeRefreshToken},
-			"refresh_token": []string{refreshToken},
-			"access_token":  []string{config.AccessToken},
-		}
-
-		resp, refreshErr = b.performArtifactoryPost(config, path, values)
-	}
-
-	if refreshErr != nil {
-		logger.Error("error making token request", "response", resp, "err", refreshErr)
-		return nil, refreshErr
-	}
-
-	//noinspection GoUnhandledErrorResult
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		var errResp artifactoryErrorResponse
-		err := json.NewDecoder(resp.Body).Decode(&errResp)
-		if err != nil {
-			logger.Error("could not parse error response", "response", resp, "err", err)
-			return nil, fmt.Errorf("could not refresh access token. Err: %v", err)
-		}
-
-		logger.Error("got non-200 status code", "statusCode", resp.StatusCode, "message", errResp.String())
-		return nil, fmt.Errorf("could not refresh access token: %s", errResp.String())
-	}
-
-	var createdToken createTokenResponse
-	if err := json.NewDecoder(resp.Body).Decode(&createdToken); err != nil {
-		logger.Error("could not parse response", "response", resp, "err", err)
-		return nil, fmt.Errorf("could not refresh access token. Err: %w", err)
-	}
-
-	return &createdToken, nil
+	return c.RefreshToken(ctx, refreshToken)
 }
 
 // supportForceRevocable verifies whether or not the Artifactory version is 7.50.3 or higher.
 // The access API changes in v7.50.3 to support force_revocable to allow us to set the expiration for the tokens.
 // REF: https://www.jfrog.com/confluence/display/JFROG/JFrog+Platform+REST+API#JFrogPlatformRESTAPI-CreateToken
-func (b *backend) supportForceRevocable(config baseConfiguration) (bool, error) {
-	return b.checkVersion("7.50.3", config)
+func (b *backend) supportForceRevocable(ctx context.Context, config baseConfiguration) (bool, error) {
+	return b.checkVersion(ctx, "7.50.3", config)
 }
 
 // useNewAccessAPI verifies whether or not the Artifactory version is 7.21.1 or higher.
 // The access API changed in v7.21.1
 // REF: https://www.jfrog.com/confluence/display/JFROG/Artifactory+REST+API#ArtifactoryRESTAPI-AccessTokens
-func (b *backend) useNewAccessAPI(config baseConfiguration) bool {
-	compatible, err := b.checkVersion("7.21.1", config)
+func (b *backend) useNewAccessAPI(ctx context.Context, config baseConfiguration) bool {
+	compatible, err := b.checkVersion(ctx, "7.21.1", config)
 	if err != nil {
 		b.Logger().
 			With("func", "useNewAccessAPI").
@@ -342,19 +190,27 @@ func (b *backend) useNewAccessAPI(config baseConfiguration) bool {
 	return compatible
 }
 
-func (b *backend) refreshExpiredAccessToken(ctx context.Context, req *logical.Request, config *baseConfiguration, userTokenConfig *userTokenConfiguration, username string) error {
+func (b *backend) refreshExpiredAccessToken(ctx context.Context, req *logical.Request, config *baseConfiguration, userTokenConfig *userTokenConfiguration) error {
 	logger := b.Logger().With("func", "refreshExpiredAccessToken")
 
 	// check if user access token is expired or not
 	// if so, refresh it with new tokens
 	logger.Debug("check if access token is expired by getting token itself")
-	err := b.getTokenByID(*config)
+	err := b.getTokenByID(ctx, *config)
 	if err != nil {
 		logger.Debug("failed to get token by ID", "err", err)
 
-		if _, ok := err.(*TokenExpiredError); ok {
+		if _, ok := err.(*client.TokenExpiredError); ok {
 			logger.Info("access token expired. Attempt to refresh using the refresh token.", "err", err)
-			refreshErr := userTokenConfig.RefreshAccessToken(ctx, req, username, b, *config)
+
+			// The refresh token can only be used once, don't use it unless the refreshed tokens can be stored.
+			// Vault forwards the request to the active node of the primary cluster instead.
+			if !b.WriteSafeReplicationState() {
+				logger.Debug("unable to store refreshed tokens on this node, forwarding the request")
+				return logical.ErrReadOnly
+			}
+
+			refreshErr := b.refreshUserToken(ctx, req, userTokenConfig, *config)
 			if refreshErr != nil {
 				logger.Error("failed to refresh access token.", "err", refreshErr)
 				return refreshErr
@@ -370,93 +226,48 @@ func (b *backend) refreshExpiredAccessToken(ctx context.Context, req *logical.Re
 	return nil
 }
 
-var tokenFailedValidationRegex = regexp.MustCompile(`.*Token failed verification: expired.*`)
-
-// getVersion will fetch the current Artifactory version and store it in the backend
-func (b *backend) getVersion(config baseConfiguration) (version string, err error) {
-	logger := b.Logger().With("func", "getVersion")
-
-	logger.Debug("fetching Artifactory version")
-
-	resp, err := b.performArtifactoryGet(config, "/artifactory/api/system/version")
-	if err != nil {
-		logger.Error("error making system version request", "response", resp, "err", err)
-		return "", err
-	}
-
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		logger.Error("got non-200 status code", "statusCode", resp.StatusCode)
-
-		var errResp artifactoryErrorResponse
-		err := json.NewDecoder(resp.Body).Decode(&errResp)
-		if err != nil {
-			logger.Error("could not parse error response", "response", resp, "err", err)
-			return "", fmt.Errorf("could not get Artifactory version. Err: %v", err)
-		}
-
-		if resp.StatusCode == http.StatusUnauthorized && tokenFailedValidationRegex.MatchString(errResp.String()) {
-			return "", &TokenExpiredError{}
-		}
-
-		return "", fmt.Errorf("could not get the system version: HTTP response %v", errResp.String())
+// getVersion will return the current Artifactory version, fetching it only if there is no cached value
+func (b *backend) getVersion(ctx context.Context, config baseConfiguration) (version string, err error) {
+	versionCache := b.connection(config.connection).versionCache
+	if entry, ok := versionCache.get(config.ArtifactoryURL); ok {
+		return entry.Value, nil
 	}
 
-	var systemVersion systemVersionResponse
-	if err = json.NewDecoder(resp.Body).Decode(&systemVersion); err != nil {
-		logger.Error("could not parse system version response", "response", resp, "err", err)
+	var systemVersion *client.SystemVersion
+	err = b.withFailover(ctx, config, true, func(c client.Client) (err error) {
+		systemVersion, err = c.GetVersion(ctx)
+		return
+	})
+	if err != nil {
 		return "", err
 	}
 
-	logger.Debug("found Artifactory version", "version", systemVersion.Version)
+	versionCache.put(config.ArtifactoryURL, systemVersion.Version)
 
 	return systemVersion.Version, nil
 }
 
-func (b *backend) getTokenByID(config baseConfiguration) error {
-	logger := b.Logger().With("func", "getTokenByID")
-
-	logger.Debug("fetching token by ID")
-
-	// '/me' is special value to get info about token itself
-	// https://jfrog.com/help/r/jfrog-rest-apis/get-token-by-id
-	resp, err := b.performArtifactoryGet(config, "/access/api/v1/tokens/me")
+// getTokenByID will fetch the token used by config, to check that it is still valid
+func (b *backend) getTokenByID(ctx context.Context, config baseConfiguration) error {
+	c, err := b.getClient(config)
 	if err != nil {
-		logger.Error("error making get token request", "response", resp, "err", err)
 		return err
 	}
 
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		logger.Error("got non-200 status code", "statusCode", resp.StatusCode)
-
-		var errResp artifactoryErrorResponse
-		err := json.NewDecoder(resp.Body).Decode(&errResp)
-		if err != nil {
-			logger.Error("could not parse error response", "response", resp, "err", err)
-			return fmt.Errorf("could not get token. Err: %w", err)
-		}
-
-		if resp.StatusCode == http.StatusUnauthorized && invalidTokenRegex.MatchString(errResp.String()) {
-			return &TokenExpiredError{}
-		}
-
-		return fmt.Errorf("could not get the token: HTTP response %v", errResp.String())
-	}
-
-	return nil
+	// '/me' is special value to get info about token itself
+	// https://jfrog.com/help/r/jfrog-rest-apis/get-token-by-id
+	_, err = c.GetTokenByID(ctx, "me")
+	return err
 }
 
 // checkVersion will return a boolean and error to check compatibility before making an API call
 // -- This was formerly "checkSystemStatus" but that was hard-coded, that method now calls this one
-func (b *backend) checkVersion(ver string, config baseConfiguration) (compatible bool, err error) {
+func (b *backend) checkVersion(ctx context.Context, ver string, config baseConfiguration) (compatible bool, err error) {
 	logger := b.Logger().With("func", "checkVersion")
 
 	compatible = false
 
-	artifactoryVersion, err := b.getVersion(config)
+	artifactoryVersion, err := b.getVersion(ctx, config)
 	if err != nil {
 		logger.Error("Unable to get Artifactory Version. Check url and access_token fields. TLS connection verification with Artifactory can be skipped by setting bypass_artifactory_tls_verification field to 'true'", "ver", artifactoryVersion, "err", err)
 		return
@@ -487,19 +298,21 @@ type TokenInfo struct {
 	Scope    string `json:"scope"`
 	Username string `json:"username"`
 	Expires  int64  `json:"expires"`
+	// Unverified is set when the token signature could not be verified, see unverifiedTokenWarning
+	Unverified bool `json:"-"`
 }
 
 // getTokenInfo will parse the provided token to return useful information about it
-func (b *backend) getTokenInfo(config baseConfiguration, token string) (info *TokenInfo, err error) {
+func (b *backend) getTokenInfo(ctx context.Context, config baseConfiguration, token string) (info *TokenInfo, err error) {
 	logger := b.Logger().With("func", "getTokenInfo")
 
 	if config.AccessToken == "" {
 		logger.Error("config.AccessToken is empty")
-		return nil, fmt.Errorf("empty access token not allowed")
+		return nil, client.ErrEmptyAccessToken
 	}
 
 	// Parse Current Token (to get tokenID/scope)
-	jwtToken, err := b.parseJWT(config, token)
+	jwtToken, verified, err := b.parseJWT(ctx, config, token)
 	if err != nil {
 		return
 	}
@@ -509,315 +322,208 @@ func (b *backend) getTokenInfo(config baseConfiguration, token string) (info *To
 		return nil, errors.New("error parsing claims in AccessToken")
 	}
 
-	sub := strings.Split(claims["sub"].(string), "/") // sub -> subject (jfac@01fr1x1h805xmg0t17xhqr1v7a/users/admin)
+	// The token may come from storage rather than a request, e.g. when refreshed or rotated in the background, so
+	// its claims are checked rather than assumed
+	tokenID, ok := claims["jti"].(string) // jti -> JFrog Token ID
+	if !ok {
+		return nil, fmt.Errorf("%w: missing jti", ErrInvalidTokenClaims)
+	}
+
+	scope, ok := claims["scp"].(string) // scp -> scope
+	if !ok {
+		return nil, fmt.Errorf("%w: missing scp", ErrInvalidTokenClaims)
+	}
+
+	subject, ok := claims["sub"].(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing sub", ErrInvalidTokenClaims)
+	}
+
+	sub := strings.Split(subject, "/") // sub -> subject (jfac@01fr1x1h805xmg0t17xhqr1v7a/users/admin)
+	if len(sub) < 3 {
+		return nil, fmt.Errorf("%w: unexpected sub %q", ErrInvalidTokenClaims, subject)
+	}
 
 	info = &TokenInfo{
-		TokenID:  claims["jti"].(string),     // jti -> JFrog Token ID
-		Scope:    claims["scp"].(string),     // scp -> scope
-		Username: strings.Join(sub[2:], "/"), // 3rd+ elements (incase username has / in it)
+		TokenID:    tokenID,
+		Scope:      scope,
+		Username:   strings.Join(sub[2:], "/"), // 3rd+ elements (incase username has / in it)
+		Unverified: !verified,
 	}
 
 	// exp -> expires at (unixtime) - may not be present
-	switch exp := claims["exp"].(type) {
+	info.Expires, err = unixClaim(claims, "exp")
+	if err != nil {
+		logger.Error("error parsing token exp as json.Number", "err", err)
+		err = nil
+	}
+
+	return
+}
+
+// unixClaim returns the claim name of a token as a unix time, or 0 if it is not present
+func unixClaim(claims jwt.MapClaims, name string) (int64, error) {
+	switch value := claims[name].(type) {
 	case int64:
-		info.Expires = exp
+		return value, nil
 	case float64:
-		info.Expires = int64(exp) // close enough this should be int64 anyhow
+		return int64(value), nil // close enough this should be int64 anyhow
 	case json.Number:
-		v, err := exp.Int64()
-		if err != nil {
-			logger.Error("error parsing token exp as json.Number", "err", err)
-		}
-		info.Expires = v
+		return value.Int64()
 	}
 
-	return
+	return 0, nil
 }
 
-// parseJWT will parse a JWT token string from Artifactory and return a *jwt.Token, err
-func (b *backend) parseJWT(config baseConfiguration, token string) (jwtToken *jwt.Token, err error) {
+// parseJWT will parse a JWT token string from Artifactory and return a *jwt.Token, whether its signature was verified, and err
+func (b *backend) parseJWT(ctx context.Context, config baseConfiguration, token string) (jwtToken *jwt.Token, verified bool, err error) {
 	logger := b.Logger().With("func", "parseJWT")
 
 	if config.AccessToken == "" {
 		logger.Error("config.AccessToken is empty")
-		return nil, fmt.Errorf("empty access token not allowed")
+		return nil, false, client.ErrEmptyAccessToken
 	}
 
-	validate := true
-
-	cert, err := b.getRootCert(config)
+	cert, cached, err := b.getRootCert(ctx, config)
 	if err != nil {
-		if errors.Is(err, ErrIncompatibleVersion) {
-			logger.Error("outdated artifactory, unable to retrieve root cert, skipping token validation")
-			validate = false
-		} else {
+		if !errors.Is(err, ErrIncompatibleVersion) {
 			logger.Error("error retrieving root cert", "err", err.Error())
-			return
+			return nil, false, err
 		}
-	}
 
-	// Parse Token
-	if validate {
-		jwtToken, err = jwt.Parse(token,
-			func(token *jwt.Token) (interface{}, error) { return cert.PublicKey, nil },
-			jwt.WithValidMethods([]string{"RS256"}))
-		if err != nil {
-			return
-		}
-		if !jwtToken.Valid {
-			return
-		}
-	} else { // SKIP Validation
 		// -- NOTE THIS IGNORES THE SIGNATURE, which is probably bad,
 		//    but it is artifactory's job to validate the token, right?
-		// p := jwt.Parser{}
-		// token, _, err := p.ParseUnverified(oldAccessToken, jwt.MapClaims{})
-		jwtToken, err = jwt.Parse(token, nil, jwt.WithoutClaimsValidation())
-		if err != nil {
-			return
-		}
-	}
-
-	// If we got here, we should have a jwtToken and nil err
-	return
-}
-
-// getRootCert will return the Artifactory access root certificate's public key, for validating token signatures
-func (b *backend) getRootCert(config baseConfiguration) (cert *x509.Certificate, err error) {
-	logger := b.Logger().With("func", "getRootCert")
-
-	if config.AccessToken == "" {
-		return nil, fmt.Errorf("empty access token not allowed")
-	}
-
-	// Verify Artifactory version is at 7.12.0 or higher, prior versions will not work
-	// REF: https://jfrog.com/help/r/jfrog-rest-apis/get-root-certificate
-	valid, err := b.checkVersion("7.12.0", config)
-	if err != nil {
-		return nil, err
-	}
-	if !valid {
-		return cert, ErrIncompatibleVersion
-	}
-
-	resp, err := b.performArtifactoryGet(config, "/access/api/v1/cert/root")
-	if err != nil {
-		logger.Error("error requesting cert/root", "response", resp, "err", err)
-		return
+		logger.Warn("outdated artifactory, unable to retrieve root cert, skipping token validation")
+		jwtToken, _, err = jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+		return jwtToken, false, err
 	}
 
-	defer resp.Body.Close()
+	jwtToken, err = verifyJWT(token, cert)
+	if cached && errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+		// The token may be signed by a key Artifactory started using after we cached its root certificate
+		logger.Debug("token signature does not match cached root cert, fetching it again")
+		b.connection(config.connection).rootCertCache.delete(config.ArtifactoryURL)
 
-	if resp.StatusCode != http.StatusOK {
-		var errResp artifactoryErrorResponse
-		err := json.NewDecoder(resp.Body).Decode(&errResp)
+		cert, _, err = b.getRootCert(ctx, config)
 		if err != nil {
-			logger.Error("could not parse error response", "response", resp, "err", err)
-			return nil, fmt.Errorf("could not create access token. Err: %v", err)
-		}
-
-		if resp.StatusCode == http.StatusUnauthorized && invalidTokenRegex.MatchString(errResp.String()) {
-			return nil, &TokenExpiredError{}
+			logger.Error("error retrieving root cert", "err", err.Error())
+			return nil, false, err
 		}
 
-		logger.Error("got non-200 status code", "statusCode", resp.StatusCode)
-		return cert, fmt.Errorf("could not get the certificate: HTTP response %v", errResp.String())
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logger.Error("error reading root cert response body", "err", err)
-		return
-	}
-
-	// The certificate is base64 encoded DER
-	binCert := make([]byte, len(body))
-	n, err := base64.StdEncoding.Decode(binCert, body)
-	if err != nil {
-		logger.Error("error decoding body", "err", err)
-		return
+		jwtToken, err = verifyJWT(token, cert)
 	}
 
-	cert, err = x509.ParseCertificate(binCert[0:n])
 	if err != nil {
-		logger.Error("error parsing certificate", "err", err)
-		return
+		return nil, false, err
 	}
-	return
-}
-
-type Feature struct {
-	FeatureId string `json:"featureId"`
-}
 
-type Usage struct {
-	ProductId string    `json:"productId"`
-	Features  []Feature `json:"features"`
+	return jwtToken, true, nil
 }
 
-func (b *backend) sendUsage(config baseConfiguration, featureId string) {
-	logger := b.Logger().With("func", "sendUsage")
-
-	if config.AccessToken == "" {
-		logger.Info("access token is empty in config")
-		return
-	}
-
-	features := []Feature{
-		{
-			FeatureId: featureId,
-		},
-	}
-
-	usage := Usage{
-		productId,
-		features,
-	}
-
-	jsonReq, err := json.Marshal(usage)
+func verifyJWT(token string, cert *x509.Certificate) (*jwt.Token, error) {
+	jwtToken, err := jwt.Parse(token,
+		func(token *jwt.Token) (interface{}, error) { return cert.PublicKey, nil },
+		jwt.WithValidMethods([]string{"RS256"}))
 	if err != nil {
-		logger.Info("error marshalling call home request", "err", err)
-		return
+		return nil, err
 	}
 
-	resp, err := b.performArtifactoryPostWithJSON(config, "artifactory/api/system/usage", jsonReq)
-	if err != nil {
-		logger.Info("error making call home request", "response", resp, "err", err)
-		return
+	if !jwtToken.Valid {
+		return nil, errors.New("token is not valid")
 	}
 
-	//noinspection GoUnhandledErrorResult
-	defer resp.Body.Close()
+	return jwtToken, nil
 }
 
-func (b *backend) performArtifactoryGet(config baseConfiguration, path string) (*http.Response, error) {
-	logger := b.Logger().With("func", "performArtifactoryGet")
-
+// getRootCert will return the Artifactory access root certificate, for validating token signatures, and whether it was
+// served from the cache. The certificate is checked against config.RootCertSHA256 when it is set.
+func (b *backend) getRootCert(ctx context.Context, config baseConfiguration) (cert *x509.Certificate, cached bool, err error) {
 	if config.AccessToken == "" {
-		logger.Error("config.AccessToken is empty")
-		return nil, fmt.Errorf("empty access token not allowed")
+		return nil, false, client.ErrEmptyAccessToken
 	}
 
-	u, err := parseURLWithDefaultPort(config.ArtifactoryURL)
-	if err != nil {
-		return nil, err
+	rootCertCache := b.connection(config.connection).rootCertCache
+	if entry, ok := rootCertCache.get(config.ArtifactoryURL); ok {
+		if err := verifyRootCertFingerprint(entry.Value, config.RootCertSHA256); err != nil {
+			return nil, true, err
+		}
+		return entry.Value, true, nil
 	}
 
-	u.Path = path // replace any path in the URL with the provided path
-
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	// Verify Artifactory version is at 7.12.0 or higher, prior versions will not work
+	// REF: https://jfrog.com/help/r/jfrog-rest-apis/get-root-certificate
+	valid, err := b.checkVersion(ctx, "7.12.0", config)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-
-	req.Header.Set("User-Agent", productId)
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", config.AccessToken))
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-
-	return b.httpClient.Do(req)
-}
-
-// performArtifactoryPost will HTTP POST values to the Artifactory API.
-func (b *backend) performArtifactoryPost(config baseConfiguration, path string, values url.Values) (*http.Response, error) {
-	if config.AccessToken == "" {
-		return nil, fmt.Errorf("empty access token not allowed")
+	if !valid {
+		return nil, false, ErrIncompatibleVersion
 	}
 
-	u, err := parseURLWithDefaultPort(config.ArtifactoryURL)
+	c, err := b.getClient(config)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	// Replace URL Path
-	u.Path = path
-
-	req, err := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(values.Encode()))
+	cert, err = c.GetRootCert(ctx)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	req.Header.Set("User-Agent", productId)
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", config.AccessToken))
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-
-	return b.httpClient.Do(req)
-}
-
-// performArtifactoryPost will HTTP POST data to the Artifactory API.
-func (b *backend) performArtifactoryPostWithJSON(config baseConfiguration, path string, postData []byte) (*http.Response, error) {
-	logger := b.Logger().With("func", "performArtifactoryPostWithJSON")
-
-	if config.AccessToken == "" {
-		logger.Error("config.AccessToken is empty")
-		return nil, fmt.Errorf("empty access token not allowed")
+	if err := verifyRootCertFingerprint(cert, config.RootCertSHA256); err != nil {
+		b.Logger().With("func", "getRootCert").Error("refusing root certificate", "err", err)
+		return nil, false, err
 	}
 
-	u, err := parseURLWithDefaultPort(config.ArtifactoryURL)
-	if err != nil {
-		return nil, err
-	}
-
-	// Replace URL Path
-	u.Path = path
-
-	postDataBuf := bytes.NewBuffer(postData)
-	req, err := http.NewRequest(http.MethodPost, u.String(), postDataBuf)
-	if err != nil {
-		return nil, err
-	}
+	rootCertCache.put(config.ArtifactoryURL, cert)
 
-	req.Header.Set("User-Agent", productId)
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", config.AccessToken))
-	req.Header.Add("Content-Type", "application/json")
+	return cert, false, nil
+}
 
-	return b.httpClient.Do(req)
+// rootCertFingerprint returns the hex encoded SHA-256 of the DER encoded certificate
+func rootCertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
 }
 
-// performArtifactoryDelete will HTTP DELETE to the Artifactory API.
-// The path will be appended to the configured configured URL Path (usually /artifactory)
-func (b *backend) performArtifactoryDelete(config baseConfiguration, path string) (*http.Response, error) {
-	logger := b.Logger().With("func", "performArtifactoryDelete")
+// normalizeFingerprint lower cases a hex fingerprint and removes any ':' separators, as printed by openssl
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+}
 
-	if config.AccessToken == "" {
-		logger.Error("config.AccessToken is empty")
-		return nil, fmt.Errorf("empty access token not allowed")
+// verifyRootCertFingerprint returns an error if pinned is set and doesn't match the certificate
+func verifyRootCertFingerprint(cert *x509.Certificate, pinned string) error {
+	if pinned == "" {
+		return nil
 	}
 
-	u, err := parseURLWithDefaultPort(config.ArtifactoryURL)
-	if err != nil {
-		return nil, err
+	if fingerprint := rootCertFingerprint(cert); fingerprint != normalizeFingerprint(pinned) {
+		return fmt.Errorf("%w: got sha256 fingerprint %s", ErrRootCertMismatch, fingerprint)
 	}
 
-	// Replace URL Path
-	u.Path = path
+	return nil
+}
 
-	req, err := http.NewRequest(http.MethodDelete, u.String(), nil)
+// sendUsage reports feature usage to Artifactory. It is usually called in its own goroutine, see
+// reportUsage, so it uses its own context rather than the one from the request which may be
+// cancelled by then.
+func (b *backend) sendUsage(config baseConfiguration, featureId string) {
+	logger := b.Logger().With("func", "sendUsage")
 
-	if err != nil {
-		return nil, err
+	if config.AccessToken == "" {
+		logger.Info("access token is empty in config")
+		return
 	}
 
-	req.Header.Set("User-Agent", productId)
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", config.AccessToken))
-
-	return b.httpClient.Do(req)
-}
-
-func parseURLWithDefaultPort(rawUrl string) (*url.URL, error) {
-	urlParsed, err := url.ParseRequestURI(rawUrl)
+	c, err := b.getClient(config)
 	if err != nil {
-		return nil, err
+		logger.Info("error creating Artifactory client", "err", err)
+		return
 	}
 
-	if urlParsed.Port() == "" {
-		defaultPort, err := net.LookupPort("tcp", urlParsed.Scheme)
-		if err != nil {
-			return nil, err
-		}
-		urlParsed.Host = fmt.Sprintf("%s:%d", urlParsed.Host, defaultPort)
+	if err := c.SendUsage(context.Background(), featureId); err != nil {
+		logger.Info("error making call home request", "err", err)
 	}
-
-	return urlParsed, nil
 }
 
 func testUsernameTemplate(testTemplate string) (up template.StringTemplate, err error) {
@@ -2,12 +2,22 @@ package artifactory
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
 	"net/http"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/jarcoal/httpmock"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -119,7 +129,7 @@ func TestBackend_CreateTokenUnauthorized(t *testing.T) {
 
 	mockArtifactoryUsageVersionRequests("")
 
-	errResp := errorResponse{
+	errResp := client.ErrorResponse{
 		Code:    "Boom",
 		Message: "foo",
 		Detail:  "bar",
@@ -172,7 +182,7 @@ func TestBackend_CreateTokenArtifactoryMisconfigured(t *testing.T) {
 
 	mockArtifactoryUsageVersionRequests("")
 
-	errResp := errorResponse{
+	errResp := client.ErrorResponse{
 		Code:    "Boom",
 		Message: "foo",
 		Detail:  "bar",
@@ -295,6 +305,8 @@ func TestBackend_RotateAdminToken(t *testing.T) {
 		"http://myserver.com:80/access/api/v1/tokens",
 		httpmock.NewStringResponder(200, jwtAccessToken))
 
+	mockArtifactoryTokenRequest()
+
 	httpmock.RegisterResponder(
 		http.MethodDelete,
 		"http://myserver.com:80/access/api/v1/tokens/1079485d-5a29-41cd-968e-e42fe924a521",
@@ -332,3 +344,369 @@ func TestBackend_RotateAdminToken(t *testing.T) {
 	assert.NotNil(t, resp)
 	assert.EqualValues(t, true, resp.Data["revoke_on_delete"])
 }
+
+// fakeArtifactory stands in for Artifactory through b.newClient, without touching the network. Its functions are
+// called with the configuration of the client, e.g. to check the access token it uses. Unset functions succeed: a
+// created or refreshed token is jwtAccessToken, the root certificate is rootCert, and "me" is the access token of the
+// client. Calls are counted by method and argument, e.g. "RevokeToken test-token-id".
+type fakeArtifactory struct {
+	version      string
+	createToken  func(ctx context.Context, config client.Config, request client.CreateTokenRequest) (*client.CreateTokenResponse, error)
+	refreshToken func(ctx context.Context, config client.Config, refreshToken string) (*client.CreateTokenResponse, error)
+	revokeToken  func(ctx context.Context, config client.Config, tokenID string) error
+	getTokenByID func(ctx context.Context, config client.Config, tokenID string) (*client.TokenDetails, error)
+	listTokens   func(ctx context.Context, config client.Config) ([]client.TokenDetails, error)
+	// getVersion replaces version when set
+	getVersion func(ctx context.Context, config client.Config) (*client.SystemVersion, error)
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+// useFakeArtifactory makes b send its requests to Artifactory to fake
+func useFakeArtifactory(b *backend, fake *fakeArtifactory) {
+	b.newClient = func(config client.Config) (client.Client, error) {
+		return &fakeClient{artifactory: fake, config: config}, nil
+	}
+}
+
+// fakeConfiguredBackend returns a backend configured with adminConfig that sends its requests to fake
+func fakeConfiguredBackend(t *testing.T, fake *fakeArtifactory, adminConfig map[string]interface{}) (*backend, *logical.BackendConfig) {
+	b, config := makeBackend(t)
+	useFakeArtifactory(b, fake)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+		Data:      adminConfig,
+	})
+	assert.NoError(t, err)
+	assert.False(t, resp.IsError())
+
+	return b, config
+}
+
+func (f *fakeArtifactory) record(call string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.calls == nil {
+		f.calls = map[string]int{}
+	}
+	f.calls[call]++
+}
+
+// callCount returns how many times call, e.g. "RevokeToken test-token-id", was made
+func (f *fakeArtifactory) callCount(call string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls[call]
+}
+
+// createdToken returns the token Artifactory creates unless told otherwise, see jwtAccessToken
+func createdToken() *client.CreateTokenResponse {
+	var resp client.CreateTokenResponse
+	if err := json.Unmarshal([]byte(jwtAccessToken), &resp); err != nil {
+		panic(err)
+	}
+
+	return &resp
+}
+
+// fakeClient is a client.Client for a fakeArtifactory
+type fakeClient struct {
+	artifactory *fakeArtifactory
+	config      client.Config
+}
+
+func (c *fakeClient) CreateToken(ctx context.Context, request client.CreateTokenRequest) (*client.CreateTokenResponse, error) {
+	c.artifactory.record("CreateToken")
+	if c.artifactory.createToken == nil {
+		return createdToken(), nil
+	}
+
+	return c.artifactory.createToken(ctx, c.config, request)
+}
+
+func (c *fakeClient) RefreshToken(ctx context.Context, refreshToken string) (*client.CreateTokenResponse, error) {
+	c.artifactory.record("RefreshToken")
+	if c.artifactory.refreshToken == nil {
+		return createdToken(), nil
+	}
+
+	return c.artifactory.refreshToken(ctx, c.config, refreshToken)
+}
+
+func (c *fakeClient) RevokeToken(ctx context.Context, tokenID string) error {
+	c.artifactory.record("RevokeToken " + tokenID)
+	if c.artifactory.revokeToken == nil {
+		return nil
+	}
+
+	return c.artifactory.revokeToken(ctx, c.config, tokenID)
+}
+
+func (c *fakeClient) GetTokenByID(ctx context.Context, tokenID string) (*client.TokenDetails, error) {
+	c.artifactory.record("GetTokenByID " + tokenID)
+	if c.artifactory.getTokenByID == nil {
+		// "me" is the token the request is made with
+		if tokenID == "me" {
+			claims := jwt.MapClaims{}
+			if _, _, err := jwt.NewParser().ParseUnverified(c.config.AccessToken, claims); err == nil {
+				tokenID, _ = claims["jti"].(string)
+			}
+		}
+		return &client.TokenDetails{TokenID: tokenID}, nil
+	}
+
+	return c.artifactory.getTokenByID(ctx, c.config, tokenID)
+}
+
+func (c *fakeClient) ListTokens(ctx context.Context) ([]client.TokenDetails, error) {
+	c.artifactory.record("ListTokens")
+	if c.artifactory.listTokens == nil {
+		return nil, nil
+	}
+
+	return c.artifactory.listTokens(ctx, c.config)
+}
+
+func (c *fakeClient) GetVersion(ctx context.Context) (*client.SystemVersion, error) {
+	c.artifactory.record("GetVersion")
+	if c.artifactory.getVersion != nil {
+		return c.artifactory.getVersion(ctx, c.config)
+	}
+	if c.artifactory.version == "" {
+		return &client.SystemVersion{Version: "7.33.8"}, nil
+	}
+
+	return &client.SystemVersion{Version: c.artifactory.version}, nil
+}
+
+func (c *fakeClient) GetRootCert(_ context.Context) (*x509.Certificate, error) {
+	c.artifactory.record("GetRootCert")
+
+	der, err := base64.StdEncoding.DecodeString(rootCert)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+func (c *fakeClient) SendUsage(_ context.Context, featureID string) error {
+	c.artifactory.record("SendUsage " + featureID)
+	return nil
+}
+
+type testContextKey struct{}
+
+// Test that the request context is passed through to the Artifactory client.
+func TestBackend_CreateTokenUsesRequestContext(t *testing.T) {
+	fake := &fakeArtifactory{
+		createToken: func(ctx context.Context, _ client.Config, request client.CreateTokenRequest) (*client.CreateTokenResponse, error) {
+			if ctx.Value(testContextKey{}) != "token/test-role" {
+				return nil, errors.New("request context not passed through")
+			}
+
+			return &client.CreateTokenResponse{
+				TokenId:     "test-token-id",
+				AccessToken: "test-access-token-for-" + request.Username,
+				Scope:       request.Scope,
+			}, nil
+		},
+	}
+
+	b, config := makeBackend(t)
+	useFakeArtifactory(b, fake)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"access_token": "test-access-token",
+			"url":          "http://myserver.com:80",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/test-role",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"username": "test-username",
+			"scope":    "test-scope",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	ctx := context.WithValue(context.Background(), testContextKey{}, "token/test-role")
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "token/test-role",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.EqualValues(t, "test-token-id", resp.Data["token_id"])
+	assert.EqualValues(t, "test-access-token-for-test-username", resp.Data["access_token"])
+}
+
+// Test that a slow token request doesn't keep other tokens from being issued, nor roles and configuration from
+// being written.
+func TestBackend_CreateTokenDoesNotBlockOtherRequests(t *testing.T) {
+	slowRequest := make(chan struct{})
+	releaseSlowRequest := make(chan struct{})
+
+	fake := &fakeArtifactory{
+		createToken: func(ctx context.Context, _ client.Config, request client.CreateTokenRequest) (*client.CreateTokenResponse, error) {
+			if request.Username == "slow-username" {
+				close(slowRequest)
+				<-releaseSlowRequest
+			}
+
+			return &client.CreateTokenResponse{
+				TokenId:     "test-token-id",
+				AccessToken: "test-access-token-for-" + request.Username,
+				Scope:       request.Scope,
+			}, nil
+		},
+	}
+
+	b, config := makeBackend(t)
+	useFakeArtifactory(b, fake)
+
+	write := func(path string, data map[string]interface{}) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   config.StorageView,
+			Data:      data,
+		})
+		assert.NoError(t, err)
+		assert.Nil(t, resp)
+	}
+
+	write(configAdminPath, map[string]interface{}{
+		"access_token": "test-access-token",
+		"url":          "http://myserver.com:80",
+	})
+	write("roles/slow-role", map[string]interface{}{"username": "slow-username", "scope": "test-scope"})
+	write("roles/fast-role", map[string]interface{}{"username": "fast-username", "scope": "test-scope"})
+
+	slowDone := make(chan struct{})
+	go func() {
+		defer close(slowDone)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "token/slow-role",
+			Storage:   config.StorageView,
+		})
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+	}()
+	<-slowRequest
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "token/fast-role",
+			Storage:   config.StorageView,
+		})
+		assert.NoError(t, err)
+		assert.EqualValues(t, "test-access-token-for-fast-username", resp.Data["access_token"])
+
+		write("roles/slow-role", map[string]interface{}{"username": "slow-username", "scope": "other-scope"})
+		write(configAdminPath, map[string]interface{}{"default_ttl": 600})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("requests were blocked by the slow token request")
+	}
+
+	close(releaseSlowRequest)
+	<-slowDone
+}
+
+func TestBackend_ParseJWTRefetchesRootCertOnSignatureMismatch(t *testing.T) {
+	fake := &fakeArtifactory{}
+
+	b, _ := makeBackend(t)
+	useFakeArtifactory(b, fake)
+	b.InitializeHttpClient(&adminConfiguration{})
+
+	// Simulate a root certificate Artifactory no longer signs tokens with
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	staleCert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	b.connection(defaultConnection).rootCertCache.put("http://myserver.com:80", staleCert)
+
+	config := baseConfiguration{
+		AccessToken:    signedAdminAccessToken,
+		ArtifactoryURL: "http://myserver.com:80",
+	}
+
+	_, verified, err := b.parseJWT(context.Background(), config, signedAdminAccessToken)
+	assert.NoError(t, err)
+	assert.True(t, verified)
+	assert.Equal(t, 1, fake.callCount("GetRootCert"))
+
+	entry, ok := b.connection(defaultConnection).rootCertCache.get("http://myserver.com:80")
+	assert.True(t, ok)
+	assert.NotEqual(t, staleCert, entry.Value)
+}
+
+// unsignedToken returns a JWT with the given claims, which is only parsed without verification, i.e. with Artifactory
+// older than 7.12.0
+func unsignedToken(t *testing.T, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-key"))
+	assert.NoError(t, err)
+
+	return token
+}
+
+func TestBackend_GetTokenInfoInvalidClaims(t *testing.T) {
+	b, _ := makeBackend(t)
+	useFakeArtifactory(b, &fakeArtifactory{version: "7.11.0"})
+
+	config := baseConfiguration{
+		AccessToken:    "test-access-token",
+		ArtifactoryURL: "http://myserver.com:80",
+	}
+
+	for name, claims := range map[string]jwt.MapClaims{
+		"missing jti":   {"scp": "applied-permissions/user", "sub": "jfac@01h424hvwpytzk1azxh6k807e5/users/admin"},
+		"missing scp":   {"jti": "test-token-id", "sub": "jfac@01h424hvwpytzk1azxh6k807e5/users/admin"},
+		"missing sub":   {"jti": "test-token-id", "scp": "applied-permissions/user"},
+		"sub not a str": {"jti": "test-token-id", "scp": "applied-permissions/user", "sub": 42},
+		"short sub":     {"jti": "test-token-id", "scp": "applied-permissions/user", "sub": "jfac@01h424hvwpytzk1azxh6k807e5"},
+	} {
+		info, err := b.getTokenInfo(context.Background(), config, unsignedToken(t, claims))
+		assert.ErrorIs(t, err, ErrInvalidTokenClaims, name)
+		assert.Nil(t, info, name)
+	}
+
+	info, err := b.getTokenInfo(context.Background(), config, unsignedToken(t, jwt.MapClaims{
+		"jti": "test-token-id",
+		"scp": "applied-permissions/user",
+		"sub": "jfac@01h424hvwpytzk1azxh6k807e5/users/test/user",
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, "test/user", info.Username)
+}
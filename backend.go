@@ -2,15 +2,19 @@ package artifactory
 
 import (
 	"context"
-	"crypto/tls"
+	"errors"
 	"fmt"
-	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/helper/template"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+	"golang.org/x/sync/singleflight"
 )
 
 var Version = "v1.0.0"
@@ -18,10 +22,22 @@ var productId = "vault-plugin-secrets-artifactory/" + Version[1:] // don't need
 
 type backend struct {
 	*framework.Backend
-	configMutex      sync.RWMutex
-	rolesMutex       sync.RWMutex
-	httpClient       *http.Client
+	// locks guard read-modify-write of storage entries, keyed on their storage path. Storage is not read under
+	// a lock for longer than needed to take a snapshot, and never across requests to Artifactory, unless the
+	// request changes the entry (e.g. rotation). A role lock is always taken before a configuration lock.
+	locks       []*locksutil.LockEntry
+	clientMutex sync.RWMutex
+	connections map[string]*connectionState
+	newClient   client.Factory
+	// reportUsage sends feature usage to Artifactory, see sendUsage. It doesn't wait for the request by default.
+	reportUsage func(config baseConfiguration, featureId string)
+	// usernameProducer is guarded by the lock of config/admin
 	usernameProducer template.StringTemplate
+	// issuedTokensPrunedAt is when the token ledger was last pruned. It is only accessed by periodicFunc, which Vault
+	// doesn't run concurrently.
+	issuedTokensPrunedAt time.Time
+	// userTokenRefreshes deduplicates concurrent refreshes of a user token, keyed on its configuration storage path
+	userTokenRefreshes singleflight.Group
 }
 
 // UsernameMetadata defines the metadata that a user_template can use to dynamically create user account in Artifactory
@@ -41,6 +57,11 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 		return nil, err
 	}
 
+	b.WALRollbackMinAge, err = walRollbackMinAge(conf)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := b.Backend.Setup(ctx, conf); err != nil {
 		return nil, err
 	}
@@ -49,7 +70,11 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 }
 
 func Backend() (*backend, error) {
-	b := &backend{}
+	b := &backend{
+		locks:       locksutil.CreateLocks(),
+		newClient:   client.New,
+		connections: map[string]*connectionState{},
+	}
 
 	up, err := testUsernameTemplate(defaultUserNameTemplate)
 	if err != nil {
@@ -57,17 +82,28 @@ func Backend() (*backend, error) {
 	}
 	b.usernameProducer = up
 
+	b.reportUsage = func(config baseConfiguration, featureId string) {
+		go b.sendUsage(config, featureId)
+	}
+
 	b.Backend = &framework.Backend{
 		Help:           strings.TrimSpace(artifactoryHelp),
 		RunningVersion: Version,
 
 		PathsSpecial: &logical.Paths{
-			SealWrapStorage: []string{configAdminPath},
+			SealWrapStorage: []string{configAdminPath, connectionsPath},
+			// Tokens are revoked by the cluster that queued them, or created them, e.g. for the leases it owns, and
+			// recorded in the ledger of that cluster, which reconciles them
+			LocalStorage: []string{revocationsPath, framework.WALPrefix, issuedTokensPath, reconcilePath},
 		},
 
 		BackendType:    logical.TypeLogical,
 		InitializeFunc: b.initialize,
 		Invalidate:     b.invalidate,
+		PeriodicFunc:   b.periodicFunc,
+
+		WALRollback:       b.walRollback,
+		WALRollbackMinAge: defaultWALRollbackMinAge,
 	}
 	b.Backend.Secrets = append(b.Backend.Secrets, b.secretAccessToken())
 	b.Backend.Paths = append(b.Backend.Paths,
@@ -77,6 +113,23 @@ func Backend() (*backend, error) {
 		b.pathUserTokenCreate(),
 		b.pathConfig(),
 		b.pathConfigRotate(),
+		b.pathListConnections(),
+		b.pathConfigConnections(),
+		b.pathConfigConnectionRotate(),
+		b.pathHealth(),
+		b.pathRevocationsPending(),
+		b.pathListIssuedTokens(),
+		b.pathListIssuedTokensByRole(),
+		b.pathIssuedTokens(),
+		b.pathReconcile(),
+		b.pathRevokeRole(),
+		b.pathRevokeUser(),
+		b.pathRevokeToken(),
+		b.pathIntrospect(),
+		b.pathTokenRefresh(),
+		b.pathUserTokenRefresh(),
+		// Before pathConfigUserToken, which would match config/user_token/rotate as a username
+		b.pathConfigUserTokenRotate(),
 		b.pathConfigUserToken())
 
 	return b, nil
@@ -93,49 +146,97 @@ func (b *backend) initialize(ctx context.Context, req *logical.InitializationReq
 		return nil
 	}
 
-	b.InitializeHttpClient(config)
+	if err := b.InitializeHttpClient(config); err != nil {
+		return err
+	}
 
 	if len(config.UsernameTemplate) != 0 {
 		up, err := testUsernameTemplate(config.UsernameTemplate)
 		if err != nil {
 			return err
 		}
-		b.configMutex.Lock()
+		lock := b.lockForKey(configAdminPath)
+		lock.Lock()
 		b.usernameProducer = up
-		b.configMutex.Unlock()
+		lock.Unlock()
 	}
 
 	return nil
 }
 
-func (b *backend) InitializeHttpClient(config *adminConfiguration) {
-	if config.BypassArtifactoryTLSVerification {
-		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: config.BypassArtifactoryTLSVerification,
-			},
+// InitializeHttpClient builds the HTTP client, retry policy, endpoints and caches of the connection
+// configured by config, replacing any previous state of that connection
+func (b *backend) InitializeHttpClient(config *adminConfiguration) error {
+	httpClient, err := config.newHTTPClient()
+	if err != nil {
+		return err
+	}
+
+	state := newConnectionState()
+	state.httpClient = httpClient
+	state.retryPolicy = config.retryPolicy()
+	threshold, timeout := config.circuitBreakerSettings()
+	state.endpoints = newEndpoints(config.endpointURLs(), threshold, timeout)
+	state.versionCache.setTTL(config.versionCacheTTL())
+	state.rootCertCache.setTTL(config.rootCertCacheTTL())
+
+	b.clientMutex.Lock()
+	defer b.clientMutex.Unlock()
+
+	b.connections[config.connection] = state
+
+	return nil
+}
+
+// lockForKey returns the lock guarding the storage entry at key
+func (b *backend) lockForKey(key string) *locksutil.LockEntry {
+	return locksutil.LockForKey(b.locks, key)
+}
+
+// localStorageWritable returns whether the node can write the local storage of the mount, see LocalStorage.
+// Unlike replicated storage, it is written on performance secondaries too.
+func (b *backend) localStorageWritable() bool {
+	return !b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby | consts.ReplicationDRSecondary)
+}
+
+// periodicFunc runs the backend's scheduled tasks. It is called about once a minute by Vault.
+func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	var errs []error
+
+	if b.localStorageWritable() {
+		errs = append(errs, b.revokePendingTokens(ctx, req))
+
+		if time.Since(b.issuedTokensPrunedAt) >= issuedTokensPruneInterval {
+			b.issuedTokensPrunedAt = time.Now()
+			errs = append(errs, b.pruneIssuedTokens(ctx, req))
 		}
 
-		b.httpClient = &http.Client{Transport: tr}
-	} else {
-		b.httpClient = http.DefaultClient
+		errs = append(errs, b.reconcileConnections(ctx, req))
+	}
+
+	// Rotating and refreshing tokens write the replicated configuration, only the active node of the primary
+	// cluster may do it
+	if b.WriteSafeReplicationState() {
+		errs = append(errs, b.rotateAdminTokens(ctx, req), b.refreshUserTokens(ctx, req))
 	}
+
+	return errors.Join(errs...)
 }
 
 // invalidate clears an existing client configuration in
 // the backend
 func (b *backend) invalidate(ctx context.Context, key string) {
-	if key == "config" {
-		b.reset()
-	}
+	b.invalidateConnection(key)
 }
 
-// reset clears any client configuration for a new
-// backend to be configured
-func (b *backend) reset() {
-	b.configMutex.Lock()
-	defer b.configMutex.Unlock()
-	b.httpClient = nil
+// reset clears the client configuration of the named connection
+// for a new one to be configured. The HTTP client is rebuilt from
+// storage the next time the configuration is fetched.
+func (b *backend) reset(name string) {
+	b.clientMutex.Lock()
+	defer b.clientMutex.Unlock()
+
+	delete(b.connections, name)
 }
 
 const artifactoryHelp = `
@@ -0,0 +1,151 @@
+package artifactory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// readOnlyStorage fails writes like the replicated storage of a performance standby or secondary
+type readOnlyStorage struct {
+	logical.Storage
+}
+
+func (s readOnlyStorage) Put(context.Context, *logical.StorageEntry) error {
+	return logical.ErrReadOnly
+}
+
+func (s readOnlyStorage) Delete(context.Context, string) error {
+	return logical.ErrReadOnly
+}
+
+func setReplicationState(config *logical.BackendConfig, state consts.ReplicationState) {
+	config.System.(*logical.StaticSystemView).ReplicationStateVal = state
+}
+
+// Test that reads which would refresh and store the user tokens are forwarded from a performance standby, before the
+// refresh token is used.
+func TestBackend_PerformanceStandbyForwardsTokenRefresh(t *testing.T) {
+	fake := &fakeArtifactory{
+		getTokenByID: func(_ context.Context, _ client.Config, _ string) (*client.TokenDetails, error) {
+			return nil, &client.TokenExpiredError{}
+		},
+		refreshToken: func(_ context.Context, _ client.Config, _ string) (*client.CreateTokenResponse, error) {
+			return &client.CreateTokenResponse{AccessToken: "refreshed-access-token", RefreshToken: "refreshed-refresh-token"}, nil
+		},
+	}
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-admin-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/user_token/test",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"access_token":  "expired-access-token",
+			"refresh_token": "test-refresh-token",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	setReplicationState(config, consts.ReplicationPerformanceStandby)
+	storage := readOnlyStorage{config.StorageView}
+
+	for _, path := range []string{"config/user_token/test", "user_token/test"} {
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      path,
+			Storage:   storage,
+		})
+		assert.ErrorIs(t, err, logical.ErrReadOnly, path)
+		assert.Nil(t, resp, path)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/user_token/test/rotate",
+		Storage:   storage,
+	})
+	assert.ErrorIs(t, err, logical.ErrReadOnly)
+	assert.Nil(t, resp)
+
+	assert.Equal(t, 0, fake.callCount("RefreshToken"), "refresh token is not used")
+
+	userTokenConfig, err := b.fetchUserTokenConfiguration(context.Background(), config.StorageView, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, "test-refresh-token", userTokenConfig.RefreshToken)
+
+	// The active node refreshes them
+	setReplicationState(config, 0)
+
+	adminConfig, err := b.fetchAdminConfiguration(context.Background(), config.StorageView)
+	assert.NoError(t, err)
+
+	baseConfig := adminConfig.baseConfiguration
+	baseConfig.AccessToken = userTokenConfig.AccessToken
+
+	err = b.refreshExpiredAccessToken(context.Background(), &logical.Request{Storage: config.StorageView}, &baseConfig, userTokenConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, "refreshed-access-token", baseConfig.AccessToken)
+	assert.Equal(t, 1, fake.callCount("RefreshToken"))
+}
+
+// Test that the admin token is not rotated on a performance standby, where the new token couldn't be stored.
+func TestBackend_PerformanceStandbyForwardsAdminTokenRotation(t *testing.T) {
+	fake := &fakeArtifactory{}
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": signedAdminAccessToken,
+		"url":          "http://myserver.com:80",
+	})
+
+	setReplicationState(config, consts.ReplicationPerformanceStandby)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/rotate",
+		Storage:   readOnlyStorage{config.StorageView},
+	})
+	assert.ErrorIs(t, err, logical.ErrReadOnly)
+	assert.Nil(t, resp)
+	assert.Equal(t, 0, fake.callCount("CreateToken"))
+}
+
+// Test that scheduled tasks only write the storage they can on each kind of node.
+func TestBackend_PeriodicFuncReplicationState(t *testing.T) {
+	fake := &fakeArtifactory{}
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token":    signedAdminAccessToken,
+		"url":             "http://myserver.com:80",
+		"rotation_period": "1h",
+	})
+
+	assert.Contains(t, b.SpecialPaths().LocalStorage, revocationsPath)
+
+	makeRotationDue(t, b, config)
+
+	assert.NoError(t, b.deferRevocation(context.Background(), config.StorageView, leaseSource{TokenID: "test-token-id"}, time.Now().Add(-time.Minute)))
+
+	setReplicationState(config, consts.ReplicationPerformanceStandby)
+	assert.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: readOnlyStorage{config.StorageView}}))
+	assert.Equal(t, 0, fake.callCount("CreateToken"))
+	assert.Equal(t, 0, fake.callCount("RevokeToken test-token-id"))
+
+	// A performance secondary revokes the tokens queued in its local storage, but doesn't rotate
+	setReplicationState(config, consts.ReplicationPerformanceSecondary)
+	assert.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: config.StorageView}))
+	assert.Equal(t, 0, fake.callCount("CreateToken"))
+	assert.Equal(t, 1, fake.callCount("RevokeToken test-token-id"))
+
+	setReplicationState(config, 0)
+	assert.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: config.StorageView}))
+	assert.Equal(t, 1, fake.callCount("CreateToken"))
+}
@@ -0,0 +1,72 @@
+package artifactory
+
+import (
+	"sync"
+	"time"
+)
+
+type cacheEntry[T any] struct {
+	Value     T
+	FetchedAt time.Time
+}
+
+// ttlCache holds values looked up from an Artifactory instance, keyed by Artifactory URL, so they don't
+// need a round trip to Artifactory on every request
+type ttlCache[T any] struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	entries map[string]cacheEntry[T]
+	now     func() time.Time
+}
+
+func newTTLCache[T any](ttl time.Duration) *ttlCache[T] {
+	return &ttlCache[T]{
+		ttl:     ttl,
+		entries: map[string]cacheEntry[T]{},
+		now:     time.Now,
+	}
+}
+
+func (c *ttlCache[T]) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ttl = ttl
+}
+
+// get returns the cached entry for url, if there is one that hasn't expired
+func (c *ttlCache[T]) get(url string) (cacheEntry[T], bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.entries[url]
+	if !ok || c.now().Sub(entry.FetchedAt) >= c.ttl {
+		return cacheEntry[T]{}, false
+	}
+
+	return entry, true
+}
+
+func (c *ttlCache[T]) put(url string, value T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[url] = cacheEntry[T]{
+		Value:     value,
+		FetchedAt: c.now(),
+	}
+}
+
+func (c *ttlCache[T]) delete(url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, url)
+}
+
+func (c *ttlCache[T]) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[string]cacheEntry[T]{}
+}
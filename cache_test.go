@@ -0,0 +1,41 @@
+package artifactory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTTLCache_Expiry(t *testing.T) {
+	now := time.Now()
+	cache := newTTLCache[string](time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cache.put("http://myserver.com:80", "7.33.8")
+
+	entry, ok := cache.get("http://myserver.com:80")
+	assert.True(t, ok)
+	assert.Equal(t, "7.33.8", entry.Value)
+
+	_, ok = cache.get("http://otherserver.com:80")
+	assert.False(t, ok)
+
+	now = now.Add(time.Minute)
+	_, ok = cache.get("http://myserver.com:80")
+	assert.False(t, ok)
+}
+
+func TestTTLCache_Delete(t *testing.T) {
+	cache := newTTLCache[string](time.Minute)
+
+	cache.put("http://myserver.com:80", "7.33.8")
+	cache.put("http://otherserver.com:80", "7.55.6")
+	cache.delete("http://myserver.com:80")
+
+	_, ok := cache.get("http://myserver.com:80")
+	assert.False(t, ok)
+
+	_, ok = cache.get("http://otherserver.com:80")
+	assert.True(t, ok)
+}
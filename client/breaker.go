@@ -0,0 +1,112 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open, Artifactory appears to be unavailable")
+
+// CircuitBreaker fails requests fast after a number of consecutive failures to reach Artifactory.
+// After the timeout, a single request is let through to probe whether Artifactory is back.
+// A nil *CircuitBreaker never trips.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	timeout   time.Duration
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	now       func() time.Time
+}
+
+// NewCircuitBreaker returns a CircuitBreaker that opens after threshold consecutive failures and
+// stays open for timeout. A threshold of 0 disables it.
+func NewCircuitBreaker(threshold int, timeout time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		timeout:   timeout,
+		now:       time.Now,
+	}
+}
+
+// Allow returns an error wrapping ErrCircuitOpen if requests should not be sent.
+func (cb *CircuitBreaker) Allow() error {
+	if cb == nil {
+		return nil
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.threshold <= 0 || cb.failures < cb.threshold {
+		return nil
+	}
+
+	if remaining := cb.timeout - cb.now().Sub(cb.openedAt); remaining > 0 {
+		return fmt.Errorf("%w: %d consecutive failures, retrying in %s", ErrCircuitOpen, cb.failures, remaining.Round(time.Second))
+	}
+
+	// half-open: only let one probe through at a time
+	if cb.probing {
+		return fmt.Errorf("%w: waiting for probe request to complete", ErrCircuitOpen)
+	}
+	cb.probing = true
+
+	return nil
+}
+
+// Success closes the circuit.
+func (cb *CircuitBreaker) Success() {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures = 0
+	cb.probing = false
+}
+
+// Failure records a failure to reach Artifactory, opening the circuit once the threshold is met.
+func (cb *CircuitBreaker) Failure() {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	cb.probing = false
+	if cb.threshold > 0 && cb.failures >= cb.threshold {
+		cb.openedAt = cb.now()
+	}
+}
+
+// release ends a probe whose outcome says nothing about availability, e.g. a cancelled request.
+func (cb *CircuitBreaker) release() {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.probing = false
+}
+
+// IsOpen reports whether requests are currently being rejected.
+func (cb *CircuitBreaker) IsOpen() bool {
+	if cb == nil {
+		return false
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	return cb.threshold > 0 && cb.failures >= cb.threshold && cb.now().Sub(cb.openedAt) < cb.timeout
+}
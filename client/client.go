@@ -0,0 +1,262 @@
+// Package client implements the subset of the JFrog Platform REST API used by the
+// Artifactory secrets engine. It is safe to use outside of Vault.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+var ErrEmptyAccessToken = errors.New("empty access token not allowed")
+
+// Client is the Artifactory Access API as seen by the secrets engine.
+type Client interface {
+	// CreateToken creates a new access token.
+	CreateToken(ctx context.Context, request CreateTokenRequest) (*CreateTokenResponse, error)
+	// RefreshToken exchanges a refresh token for a new access token and refresh token.
+	RefreshToken(ctx context.Context, refreshToken string) (*CreateTokenResponse, error)
+	// RevokeToken revokes the access token with the given ID.
+	RevokeToken(ctx context.Context, tokenID string) error
+	// GetTokenByID returns the details of the access token with the given ID. The special
+	// ID "me" returns the details of the token used by the client.
+	GetTokenByID(ctx context.Context, tokenID string) (*TokenDetails, error)
+	// ListTokens returns the details of the access tokens visible to the client, i.e. all of them for an admin.
+	ListTokens(ctx context.Context) ([]TokenDetails, error)
+	// GetVersion returns the Artifactory version.
+	GetVersion(ctx context.Context) (*SystemVersion, error)
+	// GetRootCert returns the Access root certificate used to sign access tokens.
+	GetRootCert(ctx context.Context) (*x509.Certificate, error)
+	// SendUsage reports feature usage to Artifactory.
+	SendUsage(ctx context.Context, featureID string) error
+}
+
+// Config holds the settings used to build a Client.
+type Config struct {
+	// URL is the address of the JFrog Platform, e.g. https://example.jfrog.io. Any path, e.g.
+	// https://example.com/jfrog, is preserved. A trailing /artifactory is ignored.
+	URL string
+	// AccessURL is the address of the Access service, e.g. https://example.com/access.
+	// Optional, defaults to URL + /access.
+	AccessURL string
+	// ArtifactoryURL is the address of the Artifactory service, e.g. https://example.com/artifactory.
+	// Optional, defaults to URL + /artifactory.
+	ArtifactoryURL string
+	// Headers are added to every request, e.g. for an authenticating proxy. Optional.
+	Headers map[string]string
+	// AccessToken is sent as a bearer token on every request.
+	AccessToken string
+	// UseNewAccessAPI selects the Access API (/access/api/v1) over the deprecated
+	// Artifactory token API (/artifactory/api/security/token).
+	UseNewAccessAPI bool
+	// HTTPClient is used for all requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// UserAgent is sent as the User-Agent header.
+	UserAgent string
+	// Logger defaults to a null logger.
+	Logger hclog.Logger
+	// RetryPolicy controls retries of failed requests. Defaults to no retries.
+	RetryPolicy RetryPolicy
+	// CircuitBreaker is shared by all clients of the same Artifactory instance. Optional.
+	CircuitBreaker *CircuitBreaker
+}
+
+// Factory builds a Client from a Config. New is the default implementation.
+type Factory func(config Config) (Client, error)
+
+type client struct {
+	accessURL       *url.URL
+	artifactoryURL  *url.URL
+	headers         map[string]string
+	accessToken     string
+	useNewAccessAPI bool
+	httpClient      *http.Client
+	userAgent       string
+	logger          hclog.Logger
+	retryPolicy     RetryPolicy
+	breaker         *CircuitBreaker
+}
+
+var _ Client = (*client)(nil)
+
+// New returns a Client for the JFrog Platform at config.URL.
+func New(config Config) (Client, error) {
+	if config.AccessToken == "" {
+		return nil, ErrEmptyAccessToken
+	}
+
+	u, err := parseURLWithDefaultPort(config.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	accessURL, err := serviceURL(u, config.AccessURL, "/access")
+	if err != nil {
+		return nil, fmt.Errorf("invalid Access URL: %w", err)
+	}
+
+	artifactoryURL, err := serviceURL(u, config.ArtifactoryURL, "/artifactory")
+	if err != nil {
+		return nil, fmt.Errorf("invalid Artifactory URL: %w", err)
+	}
+
+	c := &client{
+		accessURL:       accessURL,
+		artifactoryURL:  artifactoryURL,
+		headers:         config.Headers,
+		accessToken:     config.AccessToken,
+		useNewAccessAPI: config.UseNewAccessAPI,
+		httpClient:      config.HTTPClient,
+		userAgent:       config.UserAgent,
+		logger:          config.Logger,
+		retryPolicy:     config.RetryPolicy,
+		breaker:         config.CircuitBreaker,
+	}
+
+	if c.httpClient == nil {
+		c.httpClient = http.DefaultClient
+	}
+
+	if c.logger == nil {
+		c.logger = hclog.NewNullLogger()
+	}
+
+	return c, nil
+}
+
+// serviceURL returns the base URL of a JFrog Platform service: override if set, otherwise
+// the platform URL with the service prefix (e.g. /access) appended to its path.
+func serviceURL(platformURL *url.URL, override, prefix string) (*url.URL, error) {
+	if override != "" {
+		u, err := parseURLWithDefaultPort(override)
+		if err != nil {
+			return nil, err
+		}
+		u.Path = strings.TrimRight(u.Path, "/")
+		u.RawPath = ""
+
+		return u, nil
+	}
+
+	// Artifactory used to be configured as https://example.com/artifactory, keep accepting it
+	basePath := strings.TrimSuffix(strings.TrimRight(platformURL.Path, "/"), "/artifactory")
+
+	u := *platformURL
+	u.Path = basePath + prefix
+	u.RawPath = ""
+
+	return &u, nil
+}
+
+// resolve returns the URL of an API path, which must start with /access/ or /artifactory/
+func (c *client) resolve(path string) (*url.URL, error) {
+	var base *url.URL
+
+	switch {
+	case strings.HasPrefix(path, "/access/"):
+		base, path = c.accessURL, strings.TrimPrefix(path, "/access")
+	case strings.HasPrefix(path, "/artifactory/"):
+		base, path = c.artifactoryURL, strings.TrimPrefix(path, "/artifactory")
+	default:
+		return nil, fmt.Errorf("unsupported API path: %s", path)
+	}
+
+	u := *base
+	u.Path = base.Path + path
+
+	return &u, nil
+}
+
+func (c *client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	u, err := c.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.accessToken))
+
+	return req, nil
+}
+
+func (c *client) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	return c.do(req, true)
+}
+
+// postForm will HTTP POST values to the Artifactory API. Set idempotent if the request can
+// safely be sent more than once.
+func (c *client) postForm(ctx context.Context, path string, values url.Values, idempotent bool) (*http.Response, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, path, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	return c.do(req, idempotent)
+}
+
+// postJSON will HTTP POST data to the Artifactory API. Set idempotent if the request can
+// safely be sent more than once.
+func (c *client) postJSON(ctx context.Context, path string, postData []byte, idempotent bool) (*http.Response, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, path, bytes.NewBuffer(postData))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+
+	return c.do(req, idempotent)
+}
+
+// delete will HTTP DELETE to the Artifactory API.
+func (c *client) delete(ctx context.Context, path string) (*http.Response, error) {
+	req, err := c.newRequest(ctx, http.MethodDelete, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(req, true)
+}
+
+func parseURLWithDefaultPort(rawUrl string) (*url.URL, error) {
+	urlParsed, err := url.ParseRequestURI(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if urlParsed.Port() == "" {
+		defaultPort, err := net.LookupPort("tcp", urlParsed.Scheme)
+		if err != nil {
+			return nil, err
+		}
+		urlParsed.Host = fmt.Sprintf("%s:%d", urlParsed.Host, defaultPort)
+	}
+
+	return urlParsed, nil
+}
@@ -0,0 +1,343 @@
+package client
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(t *testing.T, useNewAccessAPI bool) (Client, *httpmock.MockTransport) {
+	transport := httpmock.NewMockTransport()
+
+	c, err := New(Config{
+		URL:             "http://myserver.com",
+		AccessToken:     "test-access-token",
+		UseNewAccessAPI: useNewAccessAPI,
+		HTTPClient:      &http.Client{Transport: transport},
+		UserAgent:       "test-agent",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, transport
+}
+
+func TestNew_EmptyAccessToken(t *testing.T) {
+	_, err := New(Config{URL: "http://myserver.com"})
+	assert.ErrorIs(t, err, ErrEmptyAccessToken)
+}
+
+func TestNew_InvalidURL(t *testing.T) {
+	_, err := New(Config{URL: "not a url", AccessToken: "test-access-token"})
+	assert.Error(t, err)
+}
+
+func TestClient_CreateToken(t *testing.T) {
+	c, transport := newTestClient(t, true)
+
+	transport.RegisterResponder(
+		http.MethodPost,
+		"http://myserver.com:80/access/api/v1/tokens",
+		func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "Bearer test-access-token", req.Header.Get("Authorization"))
+			assert.Equal(t, "test-agent", req.Header.Get("User-Agent"))
+			assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+
+			var body CreateTokenRequest
+			assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+			assert.Equal(t, "test-username", body.Username)
+
+			return httpmock.NewStringResponse(200, `{"token_id": "test-token-id", "access_token": "test-token"}`), nil
+		})
+
+	resp, err := c.CreateToken(context.Background(), CreateTokenRequest{
+		GrantType: GrantTypeClientCredentials,
+		Username:  "test-username",
+		Scope:     "applied-permissions/user",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "test-token-id", resp.TokenId)
+	assert.Equal(t, "test-token", resp.AccessToken)
+}
+
+func TestClient_CreateTokenExpired(t *testing.T) {
+	c, transport := newTestClient(t, true)
+
+	transport.RegisterResponder(
+		http.MethodPost,
+		"http://myserver.com:80/access/api/v1/tokens",
+		httpmock.NewStringResponder(401, `{"errors": [{"code": "UNAUTHORIZED", "message": "Invalid token, expired"}]}`))
+
+	_, err := c.CreateToken(context.Background(), CreateTokenRequest{
+		GrantType: GrantTypeClientCredentials,
+		Username:  "test-username",
+	})
+
+	var expiredErr *TokenExpiredError
+	assert.True(t, errors.As(err, &expiredErr))
+}
+
+func TestClient_RevokeTokenLegacyAPI(t *testing.T) {
+	c, transport := newTestClient(t, false)
+
+	transport.RegisterResponder(
+		http.MethodPost,
+		"http://myserver.com:80/artifactory/api/security/token/revoke",
+		func(req *http.Request) (*http.Response, error) {
+			assert.NoError(t, req.ParseForm())
+			assert.Equal(t, "test-token-id", req.PostForm.Get("token_id"))
+			return httpmock.NewStringResponse(200, ""), nil
+		})
+
+	assert.NoError(t, c.RevokeToken(context.Background(), "test-token-id"))
+}
+
+func TestClient_ListTokens(t *testing.T) {
+	c, transport := newTestClient(t, true)
+
+	transport.RegisterResponder(
+		http.MethodGet,
+		"http://myserver.com:80/access/api/v1/tokens",
+		httpmock.NewStringResponder(200, `{"tokens": [{"token_id": "test-token-id", "subject": "jfac@test/users/test-username", "issued_at": 1655244828, "description": "test-description"}]}`))
+
+	tokens, err := c.ListTokens(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, tokens, 1)
+	assert.Equal(t, "test-token-id", tokens[0].TokenID)
+	assert.Equal(t, "test-description", tokens[0].Description)
+	assert.EqualValues(t, 1655244828, tokens[0].IssuedAt)
+
+	transport.RegisterResponder(
+		http.MethodGet,
+		"http://myserver.com:80/access/api/v1/tokens",
+		httpmock.NewStringResponder(403, `{"errors": [{"code": "FORBIDDEN", "message": "forbidden"}]}`))
+
+	_, err = c.ListTokens(context.Background())
+	var statusErr *StatusError
+	assert.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusForbidden, statusErr.StatusCode)
+}
+
+func TestClient_GetTokenByIDNotFound(t *testing.T) {
+	c, transport := newTestClient(t, true)
+
+	transport.RegisterResponder(
+		http.MethodGet,
+		"http://myserver.com:80/access/api/v1/tokens/test-token-id",
+		httpmock.NewStringResponder(404, `{"errors": [{"code": "NOT_FOUND", "message": "Token not found"}]}`))
+
+	_, err := c.GetTokenByID(context.Background(), "test-token-id")
+	assert.True(t, IsNotFound(err))
+}
+
+func TestClient_RefreshTokenStatusError(t *testing.T) {
+	c, transport := newTestClient(t, true)
+
+	transport.RegisterResponder(
+		http.MethodPost,
+		"http://myserver.com:80/access/api/v1/tokens",
+		httpmock.NewStringResponder(503, `{"errors": [{"code": "SERVICE_UNAVAILABLE", "message": "Service Unavailable"}]}`))
+
+	_, err := c.RefreshToken(context.Background(), "test-refresh-token")
+	var statusErr *StatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, 503, statusErr.StatusCode)
+	assert.True(t, IsUnavailable(err))
+}
+
+func TestClient_GetVersion(t *testing.T) {
+	c, transport := newTestClient(t, true)
+
+	transport.RegisterResponder(
+		http.MethodGet,
+		"http://myserver.com:80/artifactory/api/system/version",
+		httpmock.NewStringResponder(200, `{"version": "7.33.8", "revision": "73308900"}`).
+			HeaderSet(http.Header{"Date": []string{"Tue, 10 Nov 2009 23:00:00 GMT"}}))
+
+	version, err := c.GetVersion(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "7.33.8", version.Version)
+	assert.Equal(t, "73308900", version.Revision)
+	assert.Equal(t, time.Date(2009, 11, 10, 23, 0, 0, 0, time.UTC), version.Date)
+}
+
+func TestIsUnavailable(t *testing.T) {
+	c, transport := newTestClient(t, true)
+
+	transport.RegisterResponder(
+		http.MethodPost,
+		"http://myserver.com:80/access/api/v1/tokens",
+		httpmock.NewStringResponder(502, "<html>Bad Gateway</html>"))
+	transport.RegisterResponder(
+		http.MethodDelete,
+		"http://myserver.com:80/access/api/v1/tokens/test-token-id",
+		httpmock.NewErrorResponder(errors.New("connection refused")))
+	transport.RegisterResponder(
+		http.MethodGet,
+		"http://myserver.com:80/artifactory/api/system/version",
+		httpmock.NewStringResponder(403, `{"errors": [{"message": "forbidden"}]}`))
+
+	_, err := c.CreateToken(context.Background(), CreateTokenRequest{Username: "test-username"})
+	var statusErr *StatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, 502, statusErr.StatusCode)
+	assert.True(t, IsUnavailable(err), "502 response")
+	assert.False(t, IsUnavailable(&StatusError{StatusCode: 500, Err: errors.New("internal server error")}), "500 response, like for the circuit breaker")
+
+	err = c.RevokeToken(context.Background(), "test-token-id")
+	assert.True(t, IsUnavailable(err), "connection error")
+
+	_, err = c.GetVersion(context.Background())
+	assert.False(t, IsUnavailable(err), "4xx response")
+
+	// Like for retries, TLS verification failures are not, they fail on every node
+	tlsErr := &url.Error{Op: "Get", URL: "https://myserver.com/artifactory/api/system/version", Err: x509.UnknownAuthorityError{}}
+	assert.False(t, IsUnavailable(tlsErr), "unknown certificate authority")
+	retry, unavailable := checkRetry(context.Background(), nil, tlsErr, true)
+	assert.False(t, retry)
+	assert.False(t, unavailable)
+
+	assert.True(t, IsUnavailable(ErrCircuitOpen))
+	assert.False(t, IsUnavailable(context.Canceled))
+	assert.False(t, IsUnavailable(nil))
+}
+
+func TestIsNotSent(t *testing.T) {
+	assert.True(t, IsNotSent(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}))
+	assert.True(t, IsNotSent(&net.DNSError{Err: "no such host", Name: "myserver.com"}))
+	assert.True(t, IsNotSent(ErrCircuitOpen))
+	assert.False(t, IsNotSent(&net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}), "may have been sent")
+	assert.False(t, IsNotSent(&StatusError{StatusCode: 503, Err: errors.New("Service Unavailable")}))
+	assert.False(t, IsNotSent(context.Canceled))
+	assert.False(t, IsNotSent(nil))
+}
+
+func TestIsNotFound(t *testing.T) {
+	c, transport := newTestClient(t, true)
+
+	transport.RegisterResponder(
+		http.MethodDelete,
+		"http://myserver.com:80/access/api/v1/tokens/unknown-token-id",
+		httpmock.NewStringResponder(404, `{"errors": [{"code": "NOT_FOUND", "message": "Token not found"}]}`))
+	transport.RegisterResponder(
+		http.MethodDelete,
+		"http://myserver.com:80/access/api/v1/tokens/test-token-id",
+		httpmock.NewStringResponder(403, `{"errors": [{"message": "forbidden"}]}`))
+
+	err := c.RevokeToken(context.Background(), "unknown-token-id")
+	assert.True(t, IsNotFound(err))
+
+	err = c.RevokeToken(context.Background(), "test-token-id")
+	assert.False(t, IsNotFound(err))
+	assert.False(t, IsNotFound(nil))
+}
+
+func TestClient_CancelledContext(t *testing.T) {
+	c, transport := newTestClient(t, true)
+
+	transport.RegisterResponder(
+		http.MethodGet,
+		"http://myserver.com:80/artifactory/api/system/version",
+		func(req *http.Request) (*http.Response, error) {
+			// simulate Artifactory hanging until the caller gives up
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := c.GetVersion(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestClient_URLs(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		config         Config
+		accessURL      string
+		artifactoryURL string
+	}{
+		{
+			name:           "platform URL",
+			config:         Config{URL: "https://example.com"},
+			accessURL:      "https://example.com:443/access/api/v1/tokens",
+			artifactoryURL: "https://example.com:443/artifactory/api/system/version",
+		},
+		{
+			name:           "base path is preserved",
+			config:         Config{URL: "https://example.com/jfrog/"},
+			accessURL:      "https://example.com:443/jfrog/access/api/v1/tokens",
+			artifactoryURL: "https://example.com:443/jfrog/artifactory/api/system/version",
+		},
+		{
+			name:           "trailing /artifactory is ignored",
+			config:         Config{URL: "https://example.com/jfrog/artifactory"},
+			accessURL:      "https://example.com:443/jfrog/access/api/v1/tokens",
+			artifactoryURL: "https://example.com:443/jfrog/artifactory/api/system/version",
+		},
+		{
+			name: "separate service URLs",
+			config: Config{
+				URL:            "https://example.com",
+				AccessURL:      "https://access.example.com/",
+				ArtifactoryURL: "http://gateway.example.com:8081/rt",
+			},
+			accessURL:      "https://access.example.com:443/api/v1/tokens",
+			artifactoryURL: "http://gateway.example.com:8081/rt/api/system/version",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.config.AccessToken = "test-access-token"
+			c, err := New(tc.config)
+			assert.NoError(t, err)
+
+			u, err := c.(*client).resolve("/access/api/v1/tokens")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.accessURL, u.String())
+
+			u, err = c.(*client).resolve("/artifactory/api/system/version")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.artifactoryURL, u.String())
+		})
+	}
+}
+
+func TestClient_Headers(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+
+	c, err := New(Config{
+		URL:         "http://myserver.com/jfrog",
+		AccessToken: "test-access-token",
+		HTTPClient:  &http.Client{Transport: transport},
+		Headers: map[string]string{
+			"X-Proxy-Auth":  "test-proxy-auth",
+			"Authorization": "ignored",
+		},
+	})
+	assert.NoError(t, err)
+
+	transport.RegisterResponder(
+		http.MethodGet,
+		"http://myserver.com:80/jfrog/artifactory/api/system/version",
+		func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "test-proxy-auth", req.Header.Get("X-Proxy-Auth"))
+			assert.Equal(t, "Bearer test-access-token", req.Header.Get("Authorization"))
+
+			return httpmock.NewStringResponse(200, `{"version": "7.33.8"}`), nil
+		})
+
+	_, err = c.GetVersion(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, transport.GetTotalCallCount())
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+
+	"github.com/samber/lo"
+)
+
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Detail  string `json:"detail"`
+}
+
+type ArtifactoryErrorResponse struct {
+	Errors []ErrorResponse `json:"errors"`
+}
+
+func (r ArtifactoryErrorResponse) String() string {
+	return lo.Reduce(r.Errors, func(agg string, e ErrorResponse, _ int) string {
+		if agg == "" {
+			return e.Message
+		}
+		return fmt.Sprintf("%s, %s", agg, e.Message)
+	}, "")
+}
+
+// StatusError is returned when Artifactory responds with an unexpected HTTP status code.
+type StatusError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// IsUnavailable reports whether err means that Artifactory could not be reached or failed to
+// handle the request: a connection error, a 502, 503 or 504 response or an open circuit breaker.
+// Another node of the same JFrog Platform may succeed. Like for retries and the circuit breaker,
+// TLS verification failures are not, they fail on every node.
+func IsUnavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if errors.Is(err, ErrCircuitOpen) {
+		return true
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return isUnavailableStatus(statusErr.StatusCode)
+	}
+
+	if isPermanentTransportError(err) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// isUnavailableStatus reports whether a response status means that Artifactory is unavailable,
+// rather than that it failed to handle this request, as a 500 response does.
+func isUnavailableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// IsNotSent reports whether err means that the request never reached Artifactory: the connection
+// could not be made or the circuit breaker is open. Unlike after a 503 response, a request that
+// is not idempotent can be sent again to another node.
+func IsNotSent(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	return errors.Is(err, ErrCircuitOpen) || neverReachedServer(err)
+}
+
+// IsNotFound reports whether err is a 404 response, e.g. for a token that doesn't exist.
+func IsNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+type TokenExpiredError struct{}
+
+func (e *TokenExpiredError) Error() string {
+	return "token has expired"
+}
+
+var invalidTokenRegex = regexp.MustCompile(`.*Invalid token, expired.*`)
+
+var tokenFailedValidationRegex = regexp.MustCompile(`.*Token failed verification: expired.*`)
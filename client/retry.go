@@ -0,0 +1,181 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried. The zero value disables retries.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt.
+	MaxRetries int
+	// RetryWaitMin is the base wait before the first retry. It doubles on every attempt.
+	RetryWaitMin time.Duration
+	// RetryWaitMax caps the wait between attempts, including waits requested by Retry-After.
+	RetryWaitMax time.Duration
+}
+
+// backoff returns a jittered exponential wait before the next attempt, or the wait requested by
+// the server in a Retry-After header. Both are capped at RetryWaitMax.
+func (p RetryPolicy) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			if p.RetryWaitMax > 0 && wait > p.RetryWaitMax {
+				return p.RetryWaitMax
+			}
+			return wait
+		}
+	}
+
+	wait := p.RetryWaitMin << attempt
+	if wait <= 0 || (p.RetryWaitMax > 0 && wait > p.RetryWaitMax) {
+		wait = p.RetryWaitMax
+	}
+
+	if wait <= 0 {
+		return 0
+	}
+
+	// full jitter on the upper half so concurrent callers don't retry in lockstep
+	half := wait / 2
+	return half + time.Duration(rand.Int63n(int64(wait-half)+1))
+}
+
+// parseRetryAfter parses a Retry-After header in either delay-seconds or HTTP-date form
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		wait := time.Until(at)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+
+	return 0, false
+}
+
+// do sends the request, retrying according to the retry policy. Requests that are not idempotent
+// (e.g. token creation) are only retried when the request provably never reached the server.
+func (c *client) do(req *http.Request, idempotent bool) (*http.Response, error) {
+	logger := c.logger.With("func", "do")
+
+	for attempt := 0; ; attempt++ {
+		if err := c.breaker.Allow(); err != nil {
+			logger.Warn("failing fast", "method", req.Method, "url", req.URL.Redacted(), "err", err)
+			return nil, err
+		}
+
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		resp, err := c.httpClient.Do(req)
+
+		retry, unavailable := checkRetry(req.Context(), resp, err, idempotent)
+		switch {
+		case unavailable:
+			c.breaker.Failure()
+		case err == nil:
+			c.breaker.Success()
+		default:
+			c.breaker.release()
+		}
+
+		if !retry || attempt >= c.retryPolicy.MaxRetries {
+			return resp, err
+		}
+
+		wait := c.retryPolicy.backoff(attempt, resp)
+
+		if resp != nil {
+			logger.Warn("retrying request", "method", req.Method, "url", req.URL.Redacted(), "statusCode", resp.StatusCode, "attempt", attempt+1, "wait", wait)
+			// drain the body so the connection can be reused
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		} else {
+			logger.Warn("retrying request", "method", req.Method, "url", req.URL.Redacted(), "err", err, "attempt", attempt+1, "wait", wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// checkRetry decides whether the outcome of an attempt should be retried, and whether it indicates
+// that Artifactory is unavailable (for the circuit breaker).
+func checkRetry(ctx context.Context, resp *http.Response, err error, idempotent bool) (retry bool, unavailable bool) {
+	if ctx.Err() != nil {
+		return false, false
+	}
+
+	if err != nil {
+		if isPermanentTransportError(err) {
+			return false, false
+		}
+
+		return idempotent || neverReachedServer(err), true
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return idempotent, false
+	}
+
+	if isUnavailableStatus(resp.StatusCode) {
+		return idempotent, true
+	}
+
+	return false, false
+}
+
+// neverReachedServer reports whether the error happened before any bytes of the request could
+// have been sent, i.e. while resolving or connecting to the server.
+func neverReachedServer(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op == "dial"
+	}
+
+	return false
+}
+
+// isPermanentTransportError reports errors that retrying will not fix, such as TLS verification failures.
+func isPermanentTransportError(err error) bool {
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+
+	return errors.As(err, &certErr) || errors.As(err, &unknownAuthorityErr) || errors.As(err, &hostnameErr)
+}
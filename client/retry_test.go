@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRetryingTestClient(t *testing.T, breaker *CircuitBreaker) (Client, *httpmock.MockTransport) {
+	transport := httpmock.NewMockTransport()
+
+	c, err := New(Config{
+		URL:             "http://myserver.com",
+		AccessToken:     "test-access-token",
+		UseNewAccessAPI: true,
+		HTTPClient:      &http.Client{Transport: transport},
+		RetryPolicy: RetryPolicy{
+			MaxRetries:   2,
+			RetryWaitMin: time.Millisecond,
+			RetryWaitMax: 5 * time.Millisecond,
+		},
+		CircuitBreaker: breaker,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, transport
+}
+
+func TestClient_RetriesIdempotentRequests(t *testing.T) {
+	c, transport := newRetryingTestClient(t, nil)
+
+	transport.RegisterResponder(
+		http.MethodGet,
+		"http://myserver.com:80/artifactory/api/system/version",
+		httpmock.ResponderFromMultipleResponses([]*http.Response{
+			httpmock.NewStringResponse(503, ""),
+			httpmock.NewStringResponse(502, ""),
+			httpmock.NewStringResponse(200, `{"version": "7.33.8"}`),
+		}))
+
+	version, err := c.GetVersion(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "7.33.8", version.Version)
+	assert.Equal(t, 3, transport.GetTotalCallCount())
+}
+
+func TestClient_GivesUpAfterMaxRetries(t *testing.T) {
+	c, transport := newRetryingTestClient(t, nil)
+
+	transport.RegisterResponder(
+		http.MethodGet,
+		"http://myserver.com:80/artifactory/api/system/version",
+		httpmock.NewStringResponder(503, `{"errors": [{"message": "unavailable"}]}`))
+
+	_, err := c.GetVersion(context.Background())
+	assert.ErrorContains(t, err, "unavailable")
+	assert.Equal(t, 3, transport.GetTotalCallCount())
+}
+
+func TestClient_DoesNotRetryCreateTokenAfterReachingServer(t *testing.T) {
+	c, transport := newRetryingTestClient(t, nil)
+
+	transport.RegisterResponder(
+		http.MethodPost,
+		"http://myserver.com:80/access/api/v1/tokens",
+		httpmock.NewErrorResponder(errors.New("connection reset by peer")))
+
+	_, err := c.CreateToken(context.Background(), CreateTokenRequest{Username: "test-username"})
+	assert.ErrorContains(t, err, "connection reset by peer")
+	assert.Equal(t, 1, transport.GetTotalCallCount())
+}
+
+func TestClient_RetriesCreateTokenWhenServerNotReached(t *testing.T) {
+	c, transport := newRetryingTestClient(t, nil)
+
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	transport.RegisterResponder(
+		http.MethodPost,
+		"http://myserver.com:80/access/api/v1/tokens",
+		httpmock.NewErrorResponder(dialErr).
+			Then(httpmock.NewStringResponder(200, `{"token_id": "test-token-id"}`)))
+
+	resp, err := c.CreateToken(context.Background(), CreateTokenRequest{Username: "test-username"})
+	assert.NoError(t, err)
+	assert.Equal(t, "test-token-id", resp.TokenId)
+	assert.Equal(t, 2, transport.GetTotalCallCount())
+}
+
+func TestClient_CircuitBreakerFailsFast(t *testing.T) {
+	breaker := NewCircuitBreaker(3, time.Minute)
+	c, transport := newRetryingTestClient(t, breaker)
+
+	transport.RegisterResponder(
+		http.MethodGet,
+		"http://myserver.com:80/artifactory/api/system/version",
+		httpmock.NewStringResponder(503, ""))
+
+	_, err := c.GetVersion(context.Background())
+	assert.Error(t, err)
+	assert.True(t, breaker.IsOpen())
+
+	_, err = c.GetVersion(context.Background())
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 3, transport.GetTotalCallCount())
+}
+
+func TestCircuitBreaker_HalfOpen(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(1, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	breaker.Failure()
+	assert.ErrorIs(t, breaker.Allow(), ErrCircuitOpen)
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, breaker.Allow(), "probe should be let through after the timeout")
+	assert.ErrorIs(t, breaker.Allow(), ErrCircuitOpen, "only one probe at a time")
+
+	breaker.Success()
+	assert.NoError(t, breaker.Allow())
+	assert.False(t, breaker.IsOpen())
+}
+
+func TestRetryPolicy_BackoffHonoursRetryAfter(t *testing.T) {
+	policy := RetryPolicy{
+		MaxRetries:   3,
+		RetryWaitMin: time.Second,
+		RetryWaitMax: 10 * time.Second,
+	}
+
+	resp := httpmock.NewStringResponse(429, "")
+	resp.Header.Set("Retry-After", "7")
+	assert.Equal(t, 7*time.Second, policy.backoff(0, resp))
+
+	resp.Header.Set("Retry-After", "120")
+	assert.Equal(t, 10*time.Second, policy.backoff(0, resp), "Retry-After is capped at RetryWaitMax")
+
+	wait := policy.backoff(2, nil)
+	assert.GreaterOrEqual(t, wait, 2*time.Second)
+	assert.LessOrEqual(t, wait, 4*time.Second)
+
+	wait = policy.backoff(10, nil)
+	assert.LessOrEqual(t, wait, 10*time.Second)
+}
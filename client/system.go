@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type SystemVersion struct {
+	Version  string `json:"version"`
+	Revision string `json:"revision"`
+	// Date is the time on the Artifactory server, from the HTTP Date header. Zero if not sent.
+	Date time.Time `json:"-"`
+}
+
+type Feature struct {
+	FeatureId string `json:"featureId"`
+}
+
+type Usage struct {
+	ProductId string    `json:"productId"`
+	Features  []Feature `json:"features"`
+}
+
+func (c *client) GetVersion(ctx context.Context) (*SystemVersion, error) {
+	logger := c.logger.With("func", "GetVersion")
+
+	logger.Debug("fetching Artifactory version")
+
+	resp, err := c.get(ctx, "/artifactory/api/system/version")
+	if err != nil {
+		logger.Error("error making system version request", "response", resp, "err", err)
+		return nil, err
+	}
+
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("got non-200 status code", "statusCode", resp.StatusCode)
+
+		var errResp ArtifactoryErrorResponse
+		err := json.NewDecoder(resp.Body).Decode(&errResp)
+		if err != nil {
+			logger.Error("could not parse error response", "response", resp, "err", err)
+			return nil, &StatusError{StatusCode: resp.StatusCode, Err: fmt.Errorf("could not get Artifactory version. Err: %v", err)}
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && tokenFailedValidationRegex.MatchString(errResp.String()) {
+			return nil, &TokenExpiredError{}
+		}
+
+		return nil, &StatusError{StatusCode: resp.StatusCode, Err: fmt.Errorf("could not get the system version: HTTP response %v", errResp.String())}
+	}
+
+	var systemVersion SystemVersion
+	if err = json.NewDecoder(resp.Body).Decode(&systemVersion); err != nil {
+		logger.Error("could not parse system version response", "response", resp, "err", err)
+		return nil, err
+	}
+
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		systemVersion.Date = date
+	}
+
+	logger.Debug("found Artifactory version", "version", systemVersion.Version)
+
+	return &systemVersion, nil
+}
+
+// GetRootCert will return the Artifactory access root certificate, for validating token signatures.
+// Only available from Artifactory 7.12.0.
+// REF: https://jfrog.com/help/r/jfrog-rest-apis/get-root-certificate
+func (c *client) GetRootCert(ctx context.Context) (*x509.Certificate, error) {
+	logger := c.logger.With("func", "GetRootCert")
+
+	resp, err := c.get(ctx, "/access/api/v1/cert/root")
+	if err != nil {
+		logger.Error("error requesting cert/root", "response", resp, "err", err)
+		return nil, err
+	}
+
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ArtifactoryErrorResponse
+		err := json.NewDecoder(resp.Body).Decode(&errResp)
+		if err != nil {
+			logger.Error("could not parse error response", "response", resp, "err", err)
+			return nil, fmt.Errorf("could not get the certificate. Err: %v", err)
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && invalidTokenRegex.MatchString(errResp.String()) {
+			return nil, &TokenExpiredError{}
+		}
+
+		logger.Error("got non-200 status code", "statusCode", resp.StatusCode)
+		return nil, fmt.Errorf("could not get the certificate: HTTP response %v", errResp.String())
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("error reading root cert response body", "err", err)
+		return nil, err
+	}
+
+	// The certificate is base64 encoded DER
+	binCert := make([]byte, len(body))
+	n, err := base64.StdEncoding.Decode(binCert, body)
+	if err != nil {
+		logger.Error("error decoding body", "err", err)
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(binCert[0:n])
+	if err != nil {
+		logger.Error("error parsing certificate", "err", err)
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+func (c *client) SendUsage(ctx context.Context, featureID string) error {
+	usage := Usage{
+		ProductId: c.userAgent,
+		Features: []Feature{
+			{
+				FeatureId: featureID,
+			},
+		},
+	}
+
+	jsonReq, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.postJSON(ctx, "/artifactory/api/system/usage", jsonReq, false)
+	if err != nil {
+		return err
+	}
+
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	return nil
+}
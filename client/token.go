@@ -0,0 +1,283 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const (
+	GrantTypeClientCredentials string = "client_credentials"
+	GrantTypeRefreshToken      string = "refresh_token"
+)
+
+type CreateTokenRequest struct {
+	GrantType             string `json:"grant_type,omitempty"`
+	Username              string `json:"username,omitempty"`
+	Scope                 string `json:"scope,omitempty"`
+	ExpiresIn             int64  `json:"expires_in"`
+	Refreshable           bool   `json:"refreshable,omitempty"`
+	Description           string `json:"description,omitempty"`
+	Audience              string `json:"audience,omitempty"`
+	ForceRevocable        bool   `json:"force_revocable,omitempty"`
+	IncludeReferenceToken bool   `json:"include_reference_token,omitempty"`
+	RefreshToken          string `json:"refresh_token,omitempty"`
+}
+
+type CreateTokenResponse struct {
+	TokenId        string `json:"token_id"`
+	AccessToken    string `json:"access_token"`
+	RefreshToken   string `json:"refresh_token"`
+	ExpiresIn      int    `json:"expires_in"`
+	Scope          string `json:"scope"`
+	TokenType      string `json:"token_type"`
+	ReferenceToken string `json:"reference_token"`
+}
+
+// TokenDetails is the response of the Get Token by ID API.
+// REF: https://jfrog.com/help/r/jfrog-rest-apis/get-token-by-id
+type TokenDetails struct {
+	TokenID     string `json:"token_id"`
+	Subject     string `json:"subject"`
+	Expiry      int64  `json:"expiry,omitempty"`
+	IssuedAt    int64  `json:"issued_at"`
+	Issuer      string `json:"issuer"`
+	Description string `json:"description,omitempty"`
+	Refreshable bool   `json:"refreshable"`
+	LastUsed    int64  `json:"last_used,omitempty"`
+}
+
+func (c *client) CreateToken(ctx context.Context, request CreateTokenRequest) (*CreateTokenResponse, error) {
+	logger := c.logger.With("func", "CreateToken")
+
+	var resp *http.Response
+	var createErr error
+
+	if c.useNewAccessAPI {
+		jsonReq, err := json.Marshal(request)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, createErr = c.postJSON(ctx, "/access/api/v1/tokens", jsonReq, false)
+	} else {
+		values := url.Values{
+			"grant_type":  []string{GrantTypeClientCredentials},
+			"username":    []string{request.Username},
+			"scope":       []string{request.Scope},
+			"expires_in":  []string{fmt.Sprintf("%d", request.ExpiresIn)},
+			"refreshable": []string{fmt.Sprintf("%t", request.Refreshable)},
+			"audience":    []string{request.Audience},
+		}
+
+		resp, createErr = c.postForm(ctx, "/artifactory/api/security/token", values, false)
+	}
+
+	if createErr != nil {
+		logger.Error("error making token request", "response", resp, "err", createErr)
+		return nil, createErr
+	}
+
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ArtifactoryErrorResponse
+		err := json.NewDecoder(resp.Body).Decode(&errResp)
+		if err != nil {
+			logger.Error("could not parse error response", "response", resp, "err", err)
+			return nil, &StatusError{StatusCode: resp.StatusCode, Err: fmt.Errorf("could not create access token. Err: %v", err)}
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && invalidTokenRegex.MatchString(errResp.String()) {
+			return nil, &TokenExpiredError{}
+		}
+
+		logger.Error("got non-200 status code", "statusCode", resp.StatusCode, "message", errResp.String())
+		return nil, &StatusError{StatusCode: resp.StatusCode, Err: fmt.Errorf("could not create access token: %s", errResp)}
+	}
+
+	var createdToken CreateTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&createdToken); err != nil {
+		logger.Error("could not parse response", "response", resp, "err", err)
+		return nil, fmt.Errorf("could not create access token. Err: %v", err)
+	}
+
+	return &createdToken, nil
+}
+
+func (c *client) RefreshToken(ctx context.Context, refreshToken string) (*CreateTokenResponse, error) {
+	if refreshToken == "" {
+		return nil, fmt.Errorf("no refresh token supplied")
+	}
+
+	logger := c.logger.With("func", "RefreshToken")
+
+	var resp *http.Response
+	var refreshErr error
+
+	if c.useNewAccessAPI {
+		request := CreateTokenRequest{
+			GrantType:    GrantTypeRefreshToken,
+			RefreshToken: refreshToken,
+		}
+
+		jsonReq, err := json.Marshal(request)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, refreshErr = c.postJSON(ctx, "/access/api/v1/tokens", jsonReq, false)
+	} else {
+		values := url.Values{
+			"grant_type":    []string{GrantTypeRefreshToken},
+			"refresh_token": []string{refreshToken},
+			"access_token":  []string{c.accessToken},
+		}
+
+		resp, refreshErr = c.postForm(ctx, "/artifactory/api/security/token", values, false)
+	}
+
+	if refreshErr != nil {
+		logger.Error("error making token request", "response", resp, "err", refreshErr)
+		return nil, refreshErr
+	}
+
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ArtifactoryErrorResponse
+		err := json.NewDecoder(resp.Body).Decode(&errResp)
+		if err != nil {
+			logger.Error("could not parse error response", "response", resp, "err", err)
+			return nil, &StatusError{StatusCode: resp.StatusCode, Err: fmt.Errorf("could not refresh access token. Err: %v", err)}
+		}
+
+		logger.Error("got non-200 status code", "statusCode", resp.StatusCode, "message", errResp.String())
+		return nil, &StatusError{StatusCode: resp.StatusCode, Err: fmt.Errorf("could not refresh access token: %s", errResp.String())}
+	}
+
+	var createdToken CreateTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&createdToken); err != nil {
+		logger.Error("could not parse response", "response", resp, "err", err)
+		return nil, fmt.Errorf("could not refresh access token. Err: %w", err)
+	}
+
+	return &createdToken, nil
+}
+
+func (c *client) RevokeToken(ctx context.Context, tokenID string) error {
+	logger := c.logger.With("func", "RevokeToken")
+
+	var resp *http.Response
+	var err error
+
+	if c.useNewAccessAPI {
+		resp, err = c.delete(ctx, "/access/api/v1/tokens/"+tokenID)
+		if err != nil {
+			logger.Error("error deleting access token", "tokenId", tokenID, "response", resp, "err", err)
+			return err
+		}
+	} else {
+		values := url.Values{}
+		values.Set("token_id", tokenID)
+
+		resp, err = c.postForm(ctx, "/artifactory/api/security/token/revoke", values, true)
+		if err != nil {
+			logger.Error("error deleting token", "tokenId", tokenID, "response", resp, "err", err)
+			return err
+		}
+	}
+
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			logger.Error("revokenToken could not read error response body", "err", err)
+			return &StatusError{StatusCode: resp.StatusCode, Err: fmt.Errorf("could not parse response body. Err: %v", err)}
+		}
+		logger.Error("revokenToken got non-200 status code", "statusCode", resp.StatusCode, "body", string(body))
+		return &StatusError{StatusCode: resp.StatusCode, Err: fmt.Errorf("could not revoke tokenID: %v - HTTP response %v", tokenID, string(body))}
+	}
+
+	return nil
+}
+
+// listTokensResponse is the response of the Get Tokens API.
+// REF: https://jfrog.com/help/r/jfrog-rest-apis/get-tokens
+type listTokensResponse struct {
+	Tokens []TokenDetails `json:"tokens"`
+}
+
+func (c *client) ListTokens(ctx context.Context) ([]TokenDetails, error) {
+	logger := c.logger.With("func", "ListTokens")
+
+	resp, err := c.get(ctx, "/access/api/v1/tokens")
+	if err != nil {
+		logger.Error("error making list tokens request", "response", resp, "err", err)
+		return nil, err
+	}
+
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		logger.Error("got non-200 status code", "statusCode", resp.StatusCode, "body", string(body))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Err: fmt.Errorf("could not list tokens: HTTP response %v", string(body))}
+	}
+
+	var tokens listTokensResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		logger.Error("could not parse list tokens response", "response", resp, "err", err)
+		return nil, fmt.Errorf("could not list tokens. Err: %w", err)
+	}
+
+	return tokens.Tokens, nil
+}
+
+func (c *client) GetTokenByID(ctx context.Context, tokenID string) (*TokenDetails, error) {
+	logger := c.logger.With("func", "GetTokenByID")
+
+	logger.Debug("fetching token by ID", "tokenId", tokenID)
+
+	resp, err := c.get(ctx, "/access/api/v1/tokens/"+tokenID)
+	if err != nil {
+		logger.Error("error making get token request", "response", resp, "err", err)
+		return nil, err
+	}
+
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("got non-200 status code", "statusCode", resp.StatusCode)
+
+		var errResp ArtifactoryErrorResponse
+		err := json.NewDecoder(resp.Body).Decode(&errResp)
+		if err != nil {
+			logger.Error("could not parse error response", "response", resp, "err", err)
+			return nil, &StatusError{StatusCode: resp.StatusCode, Err: fmt.Errorf("could not get token. Err: %w", err)}
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && invalidTokenRegex.MatchString(errResp.String()) {
+			return nil, &TokenExpiredError{}
+		}
+
+		return nil, &StatusError{StatusCode: resp.StatusCode, Err: fmt.Errorf("could not get the token: HTTP response %v", errResp.String())}
+	}
+
+	var details TokenDetails
+	if err := json.NewDecoder(resp.Body).Decode(&details); err != nil {
+		logger.Error("could not parse get token response", "response", resp, "err", err)
+		return nil, fmt.Errorf("could not get token. Err: %w", err)
+	}
+
+	return &details, nil
+}
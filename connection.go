@@ -0,0 +1,176 @@
+package artifactory
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+)
+
+// defaultConnection is the name of the connection configured with config/admin
+const defaultConnection = ""
+
+const connectionsPath = "config/connections/"
+
+// connectionState is the runtime state of a connection to a JFrog instance, built from its configuration
+type connectionState struct {
+	httpClient    *http.Client
+	retryPolicy   client.RetryPolicy
+	endpoints     *endpoints
+	versionCache  *ttlCache[string]
+	rootCertCache *ttlCache[*x509.Certificate]
+}
+
+func newConnectionState() *connectionState {
+	return &connectionState{
+		versionCache:  newTTLCache[string](defaultVersionCacheTTL),
+		rootCertCache: newTTLCache[*x509.Certificate](defaultRootCertCacheTTL),
+	}
+}
+
+// connection returns the runtime state of the named connection. If it hasn't been initialized, a
+// state with the default settings is returned, which is not kept.
+func (b *backend) connection(name string) *connectionState {
+	b.clientMutex.RLock()
+	defer b.clientMutex.RUnlock()
+
+	if state, ok := b.connections[name]; ok {
+		return state
+	}
+
+	return newConnectionState()
+}
+
+// connectionInitialized reports if the named connection has been initialized since the last reset
+func (b *backend) connectionInitialized(name string) bool {
+	b.clientMutex.RLock()
+	defer b.clientMutex.RUnlock()
+
+	_, ok := b.connections[name]
+	return ok
+}
+
+// connectionStoragePath returns the storage path of the named connection's configuration
+func connectionStoragePath(name string) string {
+	if name == defaultConnection {
+		return configAdminPath
+	}
+
+	return connectionsPath + name
+}
+
+// connectionName returns the name of the connection a config path request is for
+func connectionName(data *framework.FieldData) string {
+	if val, ok := data.GetOk("name"); ok {
+		return val.(string)
+	}
+
+	return defaultConnection
+}
+
+func (b *backend) pathListConnections() *framework.Path {
+	return &framework.Path{
+		Pattern: connectionsPath + "?$",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathConnectionList,
+				Summary:  "List the named Artifactory connections.",
+			},
+		},
+		HelpSynopsis: `List the named Artifactory connections.`,
+	}
+}
+
+func (b *backend) pathConfigConnections() *framework.Path {
+	fields := configFields()
+	fields["name"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Required:    true,
+		Description: "The name of the connection, must be conform to alphanumeric plus dash, and underscore.",
+	}
+
+	return &framework.Path{
+		Pattern: connectionsPath + framework.GenericNameRegex("name"),
+		Fields:  fields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigUpdate,
+				Summary:  "Configure a named Artifactory connection.",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathConfigDelete,
+				Summary:  "Delete a named Artifactory connection.",
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigRead,
+				Summary:  "Examine a named Artifactory connection.",
+			},
+		},
+		HelpSynopsis: `Interact with named Artifactory connections.`,
+		HelpDescription: `
+Configure an additional Artifactory instance this backend issues tokens for. A named connection takes the same
+parameters as config/admin, which is the default connection.
+
+Roles use a named connection by setting their "connection" parameter. A connection cannot be deleted while roles use it.
+`,
+	}
+}
+
+func (b *backend) pathConfigConnectionRotate() *framework.Path {
+	path := b.pathConfigRotate()
+	path.Pattern = connectionsPath + framework.GenericNameRegex("name") + "/rotate"
+	path.Fields["name"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Required:    true,
+		Description: "The name of the connection.",
+	}
+	path.HelpSynopsis = `Rotate the Artifactory Access Token of a named connection.`
+
+	return path
+}
+
+func (b *backend) pathConnectionList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, connectionsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+// rolesUsingConnection returns the names of the roles using the named connection. Roles are written while holding the
+// lock of their connection, so the caller holding the connection lock gets a stable answer.
+func (b *backend) rolesUsingConnection(ctx context.Context, storage logical.Storage, name string) ([]string, error) {
+	roleNames, err := storage.List(ctx, rolePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var using []string
+	for _, roleName := range roleNames {
+		role, err := b.Role(ctx, storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+
+		if role != nil && role.Connection == name {
+			using = append(using, roleName)
+		}
+	}
+
+	return using, nil
+}
+
+// invalidateConnection resets the connection whose configuration is stored at key, if any
+func (b *backend) invalidateConnection(key string) {
+	switch {
+	case key == configAdminPath:
+		b.reset(defaultConnection)
+	case strings.HasPrefix(key, connectionsPath):
+		b.reset(strings.TrimPrefix(key, connectionsPath))
+	}
+}
@@ -0,0 +1,171 @@
+package artifactory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_NamedConnection(t *testing.T) {
+	// Requests for the role are made with the other connection
+	fake := &fakeArtifactory{
+		createToken: func(_ context.Context, config client.Config, _ client.CreateTokenRequest) (*client.CreateTokenResponse, error) {
+			assert.Equal(t, "http://other.example.com", config.URL)
+			assert.Equal(t, "other-access-token", config.AccessToken)
+			return createdToken(), nil
+		},
+		revokeToken: func(_ context.Context, config client.Config, _ string) error {
+			assert.Equal(t, "http://other.example.com", config.URL)
+			assert.Equal(t, "other-access-token", config.AccessToken)
+			return nil
+		},
+	}
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-access-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/connections/other",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"access_token": "other-access-token",
+			"url":          "http://other.example.com",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "config/connections/",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"other"}, resp.Data["keys"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/connections/other",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://other.example.com", resp.Data["url"])
+	assert.Equal(t, "7.33.8", resp.Data["version"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/test-role",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"username":   "test-username",
+			"scope":      "test-scope",
+			"connection": "other",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "roles/test-role",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "other", resp.Data["connection"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "token/test-role",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.Equal(t, "other", resp.Secret.InternalData["connection"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Secret:    resp.Secret,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	assert.Equal(t, 1, fake.callCount("CreateToken"))
+	assert.Equal(t, 1, fake.callCount("RevokeToken 59e39159-19eb-463d-953d-1d6baf567db6"))
+
+	// A connection cannot be deleted while a role uses it
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "config/connections/other",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.IsError())
+	assert.Contains(t, resp.Error().Error(), "test-role")
+}
+
+func TestBackend_RoleWithUnknownConnection(t *testing.T) {
+	b, config := fakeConfiguredBackend(t, &fakeArtifactory{}, map[string]interface{}{
+		"access_token": "test-access-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/test-role",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"scope":      "test-scope",
+			"connection": "missing",
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.IsError())
+	assert.Contains(t, resp.Error().Error(), "no such connection: missing")
+}
+
+func TestBackend_NamedConnectionsAreIsolated(t *testing.T) {
+	b, config := fakeConfiguredBackend(t, &fakeArtifactory{}, map[string]interface{}{
+		"access_token": "test-access-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/connections/other",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"access_token":              "other-access-token",
+			"url":                       "http://other.example.com",
+			"circuit_breaker_threshold": 0,
+		},
+	})
+	assert.NoError(t, err)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/connections/other",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, resp.Data["circuit_breaker_threshold"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.EqualValues(t, defaultCircuitBreakerThreshold, resp.Data["circuit_breaker_threshold"])
+
+	// Changing a named connection on another node leaves the default connection alone
+	b.invalidate(context.Background(), "config/connections/other")
+	assert.False(t, b.connectionInitialized("other"))
+	assert.True(t, b.connectionInitialized(defaultConnection))
+}
@@ -0,0 +1,158 @@
+package artifactory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+)
+
+// endpoints tracks which of the URLs of a connection requests are sent to. Each URL has its own
+// circuit breaker, so an unavailable node doesn't make requests to the others fail fast.
+type endpoints struct {
+	mu           sync.RWMutex
+	urls         []string
+	breakers     []*client.CircuitBreaker
+	active       int
+	lastFailover time.Time
+	now          func() time.Time
+}
+
+func newEndpoints(urls []string, threshold int, timeout time.Duration) *endpoints {
+	e := &endpoints{
+		urls:     urls,
+		breakers: make([]*client.CircuitBreaker, len(urls)),
+		now:      time.Now,
+	}
+
+	for i := range urls {
+		e.breakers[i] = client.NewCircuitBreaker(threshold, timeout)
+	}
+
+	return e
+}
+
+// current returns the active URL and its circuit breaker. If the endpoints were not built for the
+// primary URL, e.g. before the connection is initialized, primary is returned without a circuit breaker.
+func (e *endpoints) current(primary string) (string, *client.CircuitBreaker) {
+	if e == nil || len(e.urls) == 0 || e.urls[0] != primary {
+		return primary, nil
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.urls[e.active], e.breakers[e.active]
+}
+
+// status returns the active URL and when requests last failed over to another URL
+func (e *endpoints) status() (active string, lastFailover time.Time) {
+	if e == nil || len(e.urls) == 0 {
+		return "", time.Time{}
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.urls[e.active], e.lastFailover
+}
+
+// len returns the number of URLs requests can be sent to
+func (e *endpoints) len() int {
+	if e == nil {
+		return 0
+	}
+
+	return len(e.urls)
+}
+
+// activate sends further requests to the URL at index i
+func (e *endpoints) activate(i int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.active != i {
+		e.active = i
+		e.lastFailover = e.now()
+	}
+}
+
+// withFailover calls fn with a client for the active URL of the connection. If fn fails because
+// Artifactory is unavailable (connection error, or 502, 503 or 504 response), the first other URL
+// that answers the version probe becomes active and fn is called again. Like retries, requests that
+// are not idempotent (e.g. token creation) are only sent again when they provably never reached the
+// server.
+func (b *backend) withFailover(ctx context.Context, config baseConfiguration, idempotent bool, fn func(c client.Client) error) error {
+	state := b.connection(config.connection)
+
+	activeURL, breaker := state.endpoints.current(config.ArtifactoryURL)
+
+	c, err := b.newEndpointClient(config, state, activeURL, breaker)
+	if err != nil {
+		return err
+	}
+
+	err = fn(c)
+
+	shouldFailover := client.IsUnavailable
+	if !idempotent {
+		shouldFailover = client.IsNotSent
+	}
+
+	for tried := 1; shouldFailover(err) && tried < state.endpoints.len(); tried++ {
+		if !b.failover(ctx, config, state, activeURL) {
+			return err
+		}
+
+		activeURL, breaker = state.endpoints.current(config.ArtifactoryURL)
+
+		c, err = b.newEndpointClient(config, state, activeURL, breaker)
+		if err != nil {
+			return err
+		}
+
+		err = fn(c)
+	}
+
+	return err
+}
+
+// failover makes the first URL, in configured order, other than failed that answers the version probe
+// active. It reports whether requests should be sent again.
+func (b *backend) failover(ctx context.Context, config baseConfiguration, state *connectionState, failed string) bool {
+	logger := b.Logger().With("func", "failover")
+
+	e := state.endpoints
+
+	// Another request already failed over
+	if activeURL, _ := e.current(config.ArtifactoryURL); activeURL != failed {
+		return true
+	}
+
+	for i, candidate := range e.urls {
+		if candidate == failed {
+			continue
+		}
+
+		c, err := b.newEndpointClient(config, state, candidate, e.breakers[i])
+		if err != nil {
+			logger.Warn("could not create Artifactory client", "url", candidate, "err", err)
+			continue
+		}
+
+		if _, err := c.GetVersion(ctx); err != nil {
+			logger.Warn("Artifactory is unavailable", "url", candidate, "err", err)
+			continue
+		}
+
+		logger.Warn("failing over to another Artifactory URL", "from", failed, "to", candidate)
+		e.activate(i)
+
+		return true
+	}
+
+	logger.Error("no Artifactory URL is available")
+
+	return false
+}
@@ -0,0 +1,222 @@
+package artifactory
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// The failover tests use httpmock rather than the fake client: whether a request fails over depends on the HTTP
+// status, and on whether it was sent at all, which only the client package sees.
+
+func mockFailoverNodes(primaryDown *bool) {
+	for _, node := range []string{"http://primary.example.com:80", "http://secondary.example.com:80"} {
+		down := func() bool { return false }
+		if node == "http://primary.example.com:80" {
+			down = func() bool { return *primaryDown }
+		}
+
+		httpmock.RegisterResponder(
+			http.MethodPost,
+			node+"/artifactory/api/system/usage",
+			httpmock.NewStringResponder(200, ""))
+		httpmock.RegisterResponder(
+			http.MethodGet,
+			node+"/artifactory/api/system/version",
+			func(req *http.Request) (*http.Response, error) {
+				if down() {
+					return httpmock.NewStringResponse(503, ""), nil
+				}
+				return httpmock.NewStringResponse(200, `{"version" : "7.33.8", "revision" : "73308900"}`), nil
+			})
+		httpmock.RegisterResponder(
+			http.MethodGet,
+			node+"/access/api/v1/cert/root",
+			httpmock.NewStringResponder(200, rootCert))
+		httpmock.RegisterResponder(
+			http.MethodPost,
+			node+"/access/api/v1/tokens",
+			func(req *http.Request) (*http.Response, error) {
+				// Token creation is not idempotent, it only fails over if the request was not sent
+				if down() {
+					return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+				}
+				return httpmock.NewStringResponse(200, jwtAccessToken), nil
+			})
+		httpmock.RegisterResponder(
+			http.MethodDelete,
+			node+"/access/api/v1/tokens/59e39159-19eb-463d-953d-1d6baf567db6",
+			httpmock.NewStringResponder(200, ""))
+	}
+}
+
+func TestBackend_Failover(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	primaryDown := false
+	mockFailoverNodes(&primaryDown)
+
+	b, config := configuredBackend(t, map[string]interface{}{
+		"access_token": "test-access-token",
+		"urls":         "http://primary.example.com,http://secondary.example.com",
+		"max_retries":  0,
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://primary.example.com", resp.Data["url"])
+	assert.Equal(t, "http://primary.example.com", resp.Data["active_url"])
+	assert.NotContains(t, resp.Data, "last_failover")
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/test-role",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"username": "test-username",
+			"scope":    "test-scope",
+		},
+	})
+	assert.NoError(t, err)
+
+	primaryDown = true
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "token/test-role",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.Equal(t, "59e39159-19eb-463d-953d-1d6baf567db6", resp.Data["token_id"])
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Secret:    resp.Secret,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+
+	info := httpmock.GetCallCountInfo()
+	assert.Equal(t, 1, info["POST http://primary.example.com:80/access/api/v1/tokens"])
+	assert.Equal(t, 1, info["POST http://secondary.example.com:80/access/api/v1/tokens"])
+	assert.Equal(t, 0, info["DELETE http://primary.example.com:80/access/api/v1/tokens/59e39159-19eb-463d-953d-1d6baf567db6"])
+	assert.Equal(t, 1, info["DELETE http://secondary.example.com:80/access/api/v1/tokens/59e39159-19eb-463d-953d-1d6baf567db6"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://secondary.example.com", resp.Data["active_url"])
+	assert.WithinDuration(t, time.Now(), resp.Data["last_failover"].(time.Time), time.Minute)
+}
+
+func TestBackend_FailoverAllUnavailable(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	primaryDown := false
+	mockFailoverNodes(&primaryDown)
+
+	b, config := configuredBackend(t, map[string]interface{}{
+		"access_token": "test-access-token",
+		"urls":         "http://primary.example.com,http://secondary.example.com",
+		"max_retries":  0,
+	})
+
+	httpmock.RegisterResponder(
+		http.MethodDelete,
+		"=~^http://(primary|secondary).example.com:80/access/api/v1/tokens/",
+		httpmock.NewStringResponder(502, "Bad Gateway"))
+	httpmock.RegisterResponder(
+		http.MethodGet,
+		"http://secondary.example.com:80/artifactory/api/system/version",
+		httpmock.NewStringResponder(502, "Bad Gateway"))
+
+	adminConfig, err := b.fetchAdminConfiguration(context.Background(), config.StorageView)
+	assert.NoError(t, err)
+
+	err = b.RevokeToken(context.Background(), adminConfig.baseConfiguration, "test-token-id")
+	assert.ErrorContains(t, err, "Bad Gateway")
+
+	activeURL, lastFailover := b.connection(defaultConnection).endpoints.status()
+	assert.Equal(t, "http://primary.example.com", activeURL)
+	assert.True(t, lastFailover.IsZero())
+}
+
+func TestBackend_FailoverNotIdempotent(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	primaryDown := false
+	mockFailoverNodes(&primaryDown)
+
+	b, config := configuredBackend(t, map[string]interface{}{
+		"access_token": "test-access-token",
+		"urls":         "http://primary.example.com,http://secondary.example.com",
+		"max_retries":  0,
+	})
+
+	// The primary may have created the token before failing
+	httpmock.RegisterResponder(
+		http.MethodPost,
+		"http://primary.example.com:80/access/api/v1/tokens",
+		httpmock.NewStringResponder(503, `{"errors": [{"code": "SERVICE_UNAVAILABLE", "message": "Service Unavailable"}]}`))
+
+	adminConfig, err := b.fetchAdminConfiguration(context.Background(), config.StorageView)
+	assert.NoError(t, err)
+
+	_, err = b.CreateToken(context.Background(), adminConfig.baseConfiguration, artifactoryRole{Username: "test-username", Scope: "test-scope"})
+	assert.Error(t, err)
+
+	info := httpmock.GetCallCountInfo()
+	assert.Equal(t, 1, info["POST http://primary.example.com:80/access/api/v1/tokens"])
+	assert.Equal(t, 0, info["POST http://secondary.example.com:80/access/api/v1/tokens"])
+
+	activeURL, _ := b.connection(defaultConnection).endpoints.status()
+	assert.Equal(t, "http://primary.example.com", activeURL)
+}
+
+func TestBackend_URLsValidation(t *testing.T) {
+	b, config := makeBackend(t)
+	useFakeArtifactory(b, &fakeArtifactory{})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"url":  "http://secondary.example.com",
+			"urls": "http://primary.example.com,http://secondary.example.com",
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.IsError())
+	assert.Contains(t, resp.Error().Error(), "url must be the first of urls")
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"urls": "http://primary.example.com,not a url",
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.IsError())
+	assert.Contains(t, resp.Error().Error(), "invalid urls")
+}
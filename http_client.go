@@ -0,0 +1,185 @@
+package artifactory
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultRequestTimeout bounds each request to Artifactory, http.DefaultClient has no timeout
+const defaultRequestTimeout = 30 * time.Second
+
+// Transport defaults, matching http.DefaultTransport
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = http.DefaultMaxIdleConnsPerHost
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+)
+
+var tlsVersions = map[string]uint16{
+	"tls10": tls.VersionTLS10,
+	"tls11": tls.VersionTLS11,
+	"tls12": tls.VersionTLS12,
+	"tls13": tls.VersionTLS13,
+}
+
+// transportSettings are the effective connection settings for Artifactory, with defaults applied
+type transportSettings struct {
+	RequestTimeout      time.Duration
+	ProxyURL            *url.URL
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	KeepAlive           time.Duration
+	DisableKeepAlives   bool
+}
+
+// transportSettings returns the effective connection settings, applying defaults for unset values
+func (c *adminConfiguration) transportSettings() (transportSettings, error) {
+	settings := transportSettings{
+		RequestTimeout:      defaultRequestTimeout,
+		MaxIdleConns:        defaultMaxIdleConns,
+		MaxIdleConnsPerHost: defaultMaxIdleConnsPerHost,
+		IdleConnTimeout:     defaultIdleConnTimeout,
+		KeepAlive:           defaultKeepAlive,
+		DisableKeepAlives:   c.DisableKeepAlives,
+	}
+
+	if c.RequestTimeout > 0 {
+		settings.RequestTimeout = c.RequestTimeout
+	}
+
+	if c.ProxyURL != "" {
+		proxyURL, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return settings, fmt.Errorf("invalid proxy_url: %w", err)
+		}
+
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return settings, fmt.Errorf("invalid proxy_url %q, scheme must be http, https or socks5", proxyURL.Redacted())
+		}
+
+		settings.ProxyURL = proxyURL
+	}
+
+	if c.MaxIdleConns != nil {
+		settings.MaxIdleConns = *c.MaxIdleConns
+	}
+
+	if c.MaxIdleConnsPerHost != nil {
+		settings.MaxIdleConnsPerHost = *c.MaxIdleConnsPerHost
+	}
+
+	if c.IdleConnTimeout > 0 {
+		settings.IdleConnTimeout = c.IdleConnTimeout
+	}
+
+	if c.KeepAlive > 0 {
+		settings.KeepAlive = c.KeepAlive
+	}
+
+	return settings, nil
+}
+
+// customTransport reports if any setting requires a dedicated transport rather than http.DefaultTransport
+func (c *adminConfiguration) customTransport() bool {
+	return c.ProxyURL != "" || c.MaxIdleConns != nil || c.MaxIdleConnsPerHost != nil || c.IdleConnTimeout > 0 ||
+		c.KeepAlive > 0 || c.DisableKeepAlives
+}
+
+// newHTTPClient builds the HTTP client used to connect to Artifactory
+func (c *adminConfiguration) newHTTPClient() (*http.Client, error) {
+	settings, err := c.transportSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := &http.Client{
+		Timeout: settings.RequestTimeout,
+	}
+
+	// Leaving Transport unset uses http.DefaultTransport
+	if tlsConfig == nil && !c.customTransport() {
+		return httpClient, nil
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if settings.ProxyURL != nil {
+		proxy = http.ProxyURL(settings.ProxyURL)
+	}
+
+	httpClient.Transport = &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: settings.KeepAlive,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          settings.MaxIdleConns,
+		MaxIdleConnsPerHost:   settings.MaxIdleConnsPerHost,
+		IdleConnTimeout:       settings.IdleConnTimeout,
+		DisableKeepAlives:     settings.DisableKeepAlives,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	return httpClient, nil
+}
+
+// tlsConfig returns the TLS configuration for connecting to Artifactory, or nil if the defaults should be used
+func (c *adminConfiguration) tlsConfig() (*tls.Config, error) {
+	if !c.BypassArtifactoryTLSVerification && c.CACert == "" && c.ClientCert == "" && c.ClientKey == "" &&
+		c.TLSServerName == "" && c.TLSMinVersion == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.BypassArtifactoryTLSVerification,
+		ServerName:         c.TLSServerName,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if c.TLSMinVersion != "" {
+		minVersion, ok := tlsVersions[c.TLSMinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid tls_min_version %q, must be one of tls10, tls11, tls12 or tls13", c.TLSMinVersion)
+		}
+		tlsConfig.MinVersion = minVersion
+	}
+
+	if c.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(c.CACert)) {
+			return nil, errors.New("ca_cert does not contain any PEM encoded certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.ClientCert != "" || c.ClientKey != "" {
+		if c.ClientCert == "" || c.ClientKey == "" {
+			return nil, errors.New("client_cert and client_key must be set together")
+		}
+
+		cert, err := tls.X509KeyPair([]byte(c.ClientCert), []byte(c.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client_cert or client_key: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
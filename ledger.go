@@ -0,0 +1,587 @@
+package artifactory
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+)
+
+const (
+	issuedTokensPath = "tokens/"
+
+	// The ledger is indexed by role, by username and by refresh token, to look tokens up without reading it all
+	issuedTokensByRolePath         = issuedTokensPath + "by-role/"
+	issuedTokensByUserPath         = issuedTokensPath + "by-user/"
+	issuedTokensByRefreshTokenPath = issuedTokensPath + "by-refresh-token/"
+
+	// issuedTokenRetention is how long a token is kept in the ledger once it is revoked or expired
+	issuedTokenRetention = 30 * 24 * time.Hour
+	// issuedTokensPruneInterval is how often the periodic function prunes the ledger
+	issuedTokensPruneInterval = time.Hour
+)
+
+// issuedToken is an entry of the ledger of the tokens issued by the backend, written when the token is created and
+// updated when its lease is revoked
+type issuedToken struct {
+	TokenID string `json:"token_id"`
+	// Source is leaseSourceRole or leaseSourceUserToken, like in the internal data of the lease
+	Source          string `json:"source"`
+	Role            string `json:"role,omitempty"`
+	UserTokenConfig string `json:"user_token_config,omitempty"`
+	Connection      string `json:"connection,omitempty"`
+	// Username is the Artifactory user of the token, generated from the username template for roles without one
+	Username string `json:"username"`
+	Scope    string `json:"scope,omitempty"`
+	// LeasePathPrefix is the prefix of the lease ID, shared by all the leases of the path: Vault generates the lease ID
+	// once the token is returned
+	LeasePathPrefix string `json:"lease_path_prefix,omitempty"`
+	// LeaseID is recorded when the lease is first renewed or revoked, the backend doesn't see it before
+	LeaseID     string    `json:"lease_id,omitempty"`
+	EntityID    string    `json:"entity_id,omitempty"`
+	DisplayName string    `json:"display_name,omitempty"`
+	IssuedAt    time.Time `json:"issued_at"`
+	// ExpiresAt is when the token expires in Artifactory, zero if it doesn't
+	ExpiresAt time.Time `json:"expires_at"`
+	// LeaseExpiresAt is when the lease reaches its max TTL, and is revoked at the latest
+	LeaseExpiresAt time.Time `json:"lease_expires_at"`
+	RevokedAt      time.Time `json:"revoked_at"`
+	// RevocationPending is set when the lease was revoked but the token is queued to be revoked in Artifactory
+	RevocationPending bool `json:"revocation_pending,omitempty"`
+	// RefreshTokenSHA256 identifies the token of a refresh token, without storing it
+	RefreshTokenSHA256 string `json:"refresh_token_sha256,omitempty"`
+	// RefreshedTo is the ID of the token that replaced this one when it was refreshed
+	RefreshedTo string `json:"refreshed_to,omitempty"`
+}
+
+// newIssuedToken returns the ledger entry of the token created by req from source, for username
+func newIssuedToken(req *logical.Request, source leaseSource, username string, resp *client.CreateTokenResponse, maxLeaseTTL time.Duration) issuedToken {
+	now := time.Now()
+
+	token := issuedToken{
+		TokenID:         resp.TokenId,
+		Source:          source.Source,
+		Role:            source.Role,
+		UserTokenConfig: source.UserTokenConfig,
+		Connection:      source.Connection,
+		Username:        username,
+		Scope:           resp.Scope,
+		LeasePathPrefix: req.MountPoint + req.Path,
+		EntityID:        req.EntityID,
+		DisplayName:     req.DisplayName,
+		IssuedAt:        now,
+	}
+
+	if resp.ExpiresIn > 0 {
+		token.ExpiresAt = now.Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+
+	if maxLeaseTTL > 0 {
+		token.LeaseExpiresAt = now.Add(maxLeaseTTL)
+	}
+
+	if resp.RefreshToken != "" {
+		token.RefreshTokenSHA256 = refreshTokenSHA256(resp.RefreshToken)
+	}
+
+	return token
+}
+
+// refreshTokenSHA256 returns the hash of refreshToken recorded in the ledger
+func refreshTokenSHA256(refreshToken string) string {
+	hash := sha256.Sum256([]byte(refreshToken))
+	return fmt.Sprintf("%x", hash[:])
+}
+
+// indexKeys returns the keys of the indexes of the ledger that point to the token
+func (t issuedToken) indexKeys() []string {
+	var keys []string
+
+	if t.Source == leaseSourceRole && t.Role != "" {
+		keys = append(keys, issuedTokensByRolePath+url.PathEscape(t.Role)+"/"+t.TokenID)
+	}
+
+	if t.Username != "" {
+		keys = append(keys, issuedTokensByUserPath+url.PathEscape(t.Username)+"/"+t.TokenID)
+	}
+
+	if t.RefreshTokenSHA256 != "" {
+		keys = append(keys, issuedTokensByRefreshTokenPath+t.RefreshTokenSHA256)
+	}
+
+	return keys
+}
+
+// source returns where the token comes from, to revoke it with the same credentials as its lease
+func (t issuedToken) source() leaseSource {
+	return leaseSource{
+		Source:          t.Source,
+		Role:            t.Role,
+		UserTokenConfig: t.UserTokenConfig,
+		Connection:      t.Connection,
+		TokenID:         t.TokenID,
+	}
+}
+
+// endedAt returns when the token stopped being usable, or zero if it may still be
+func (t issuedToken) endedAt() time.Time {
+	if t.RevocationPending {
+		return time.Time{}
+	}
+
+	if !t.RevokedAt.IsZero() {
+		return t.RevokedAt
+	}
+
+	if !t.ExpiresAt.IsZero() && (t.LeaseExpiresAt.IsZero() || t.ExpiresAt.Before(t.LeaseExpiresAt)) {
+		return t.ExpiresAt
+	}
+
+	return t.LeaseExpiresAt
+}
+
+func (t issuedToken) responseData() map[string]interface{} {
+	data := map[string]interface{}{
+		"token_id":           t.TokenID,
+		"source":             t.Source,
+		"connection":         t.Connection,
+		"username":           t.Username,
+		"scope":              t.Scope,
+		"lease_path_prefix":  t.LeasePathPrefix,
+		"entity_id":          t.EntityID,
+		"display_name":       t.DisplayName,
+		"issued_at":          t.IssuedAt,
+		"revocation_pending": t.RevocationPending,
+	}
+
+	switch t.Source {
+	case leaseSourceRole:
+		data["role"] = t.Role
+	case leaseSourceUserToken:
+		data["user_token_config"] = t.UserTokenConfig
+	}
+
+	if t.LeaseID != "" {
+		data["lease_id"] = t.LeaseID
+	}
+
+	if t.RefreshedTo != "" {
+		data["refreshed_to"] = t.RefreshedTo
+	}
+
+	for key, value := range map[string]time.Time{
+		"expires_at":       t.ExpiresAt,
+		"lease_expires_at": t.LeaseExpiresAt,
+		"revoked_at":       t.RevokedAt,
+	} {
+		if !value.IsZero() {
+			data[key] = value
+		}
+	}
+
+	return data
+}
+
+func (b *backend) pathListIssuedTokens() *framework.Path {
+	return &framework.Path{
+		Pattern: "tokens/?$",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathIssuedTokensList,
+				Summary:  "List the tokens issued by the backend.",
+			},
+		},
+		HelpSynopsis:    `List the tokens issued by the backend.`,
+		HelpDescription: issuedTokensHelp,
+	}
+}
+
+func (b *backend) pathListIssuedTokensByRole() *framework.Path {
+	return &framework.Path{
+		Pattern: "tokens/by-role/" + framework.GenericNameRegex("role") + "/?$",
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeString,
+				Description: "Name of the role.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathIssuedTokensListByRole,
+				Summary:  "List the tokens issued for a role.",
+			},
+		},
+		HelpSynopsis:    `List the tokens issued for a role.`,
+		HelpDescription: issuedTokensHelp,
+	}
+}
+
+func (b *backend) pathIssuedTokens() *framework.Path {
+	return &framework.Path{
+		Pattern: "tokens/" + framework.GenericNameRegex("token_id"),
+		Fields: map[string]*framework.FieldSchema{
+			"token_id": {
+				Type:        framework.TypeString,
+				Description: "ID of the token.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathIssuedTokensRead,
+				Summary:  "Read a token issued by the backend.",
+			},
+		},
+		HelpSynopsis:    `Read a token issued by the backend.`,
+		HelpDescription: issuedTokensHelp,
+	}
+}
+
+func (b *backend) pathIssuedTokensList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	tokens, err := b.listIssuedTokens(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return issuedTokensListResponse(tokens), nil
+}
+
+func (b *backend) pathIssuedTokensListByRole(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	tokens, err := b.listIssuedTokensByRole(ctx, req.Storage, data.Get("role").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	return issuedTokensListResponse(tokens), nil
+}
+
+// issuedTokensListResponse lists tokens of the ledger
+func issuedTokensListResponse(tokens []*issuedToken) *logical.Response {
+	keys := []string{}
+	keyInfo := map[string]interface{}{}
+	for _, token := range tokens {
+		info := map[string]interface{}{
+			"username":  token.Username,
+			"issued_at": token.IssuedAt,
+		}
+		if token.Source == leaseSourceRole {
+			info["role"] = token.Role
+		}
+		if !token.RevokedAt.IsZero() {
+			info["revoked_at"] = token.RevokedAt
+		}
+
+		keys = append(keys, token.TokenID)
+		keyInfo[token.TokenID] = info
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo)
+}
+
+func (b *backend) pathIssuedTokensRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	token, err := fetchIssuedToken(ctx, req.Storage, data.Get("token_id").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if token == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: token.responseData(),
+	}, nil
+}
+
+func fetchIssuedToken(ctx context.Context, storage logical.Storage, tokenID string) (*issuedToken, error) {
+	entry, err := storage.Get(ctx, issuedTokensPath+tokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var token issuedToken
+	if err := entry.DecodeJSON(&token); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// listIssuedTokens returns all the tokens of the ledger
+func (b *backend) listIssuedTokens(ctx context.Context, storage logical.Storage) ([]*issuedToken, error) {
+	tokenIDs, err := storage.List(ctx, issuedTokensPath)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]*issuedToken, 0, len(tokenIDs))
+	for _, tokenID := range tokenIDs {
+		// The indexes are listed as folders
+		if strings.HasSuffix(tokenID, "/") {
+			continue
+		}
+
+		token, err := fetchIssuedToken(ctx, storage, tokenID)
+		if err != nil {
+			return nil, err
+		}
+
+		if token != nil {
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens, nil
+}
+
+// listIssuedTokensByRole returns the tokens of the ledger issued for the named role
+func (b *backend) listIssuedTokensByRole(ctx context.Context, storage logical.Storage, roleName string) ([]*issuedToken, error) {
+	return listIndexedIssuedTokens(ctx, storage, issuedTokensByRolePath+url.PathEscape(roleName)+"/")
+}
+
+// listIssuedTokensByUser returns the tokens of the ledger issued for the named Artifactory user
+func (b *backend) listIssuedTokensByUser(ctx context.Context, storage logical.Storage, username string) ([]*issuedToken, error) {
+	return listIndexedIssuedTokens(ctx, storage, issuedTokensByUserPath+url.PathEscape(username)+"/")
+}
+
+// listIndexedIssuedTokens returns the tokens of the ledger whose IDs are listed under the index prefix. Index entries
+// left behind by a failed write are skipped.
+func listIndexedIssuedTokens(ctx context.Context, storage logical.Storage, prefix string) ([]*issuedToken, error) {
+	tokenIDs, err := storage.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]*issuedToken, 0, len(tokenIDs))
+	for _, tokenID := range tokenIDs {
+		token, err := fetchIssuedToken(ctx, storage, tokenID)
+		if err != nil {
+			return nil, err
+		}
+
+		if token != nil {
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens, nil
+}
+
+// fetchIssuedTokenByRefreshToken returns the token of the ledger that was issued with refreshToken, if any
+func fetchIssuedTokenByRefreshToken(ctx context.Context, storage logical.Storage, refreshToken string) (*issuedToken, error) {
+	entry, err := storage.Get(ctx, issuedTokensByRefreshTokenPath+refreshTokenSHA256(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	return fetchIssuedToken(ctx, storage, string(entry.Value))
+}
+
+// storeIssuedToken adds token to the ledger, with its index entries. They are written first, so that a token is
+// never in the ledger without them.
+func (b *backend) storeIssuedToken(ctx context.Context, storage logical.Storage, token issuedToken) error {
+	if token.TokenID == "" {
+		return nil
+	}
+
+	for _, key := range token.indexKeys() {
+		if err := storage.Put(ctx, &logical.StorageEntry{Key: key, Value: []byte(token.TokenID)}); err != nil {
+			return err
+		}
+	}
+
+	return putIssuedToken(ctx, storage, token)
+}
+
+// putIssuedToken writes the ledger entry of token, without its index entries
+func putIssuedToken(ctx context.Context, storage logical.Storage, token issuedToken) error {
+	entry, err := logical.StorageEntryJSON(issuedTokensPath+token.TokenID, token)
+	if err != nil {
+		return err
+	}
+
+	return storage.Put(ctx, entry)
+}
+
+// trackIssuedToken records the token created with config for source in the ledger. The token is written to the WAL
+// until it is recorded, so that it is revoked if it can't be. errRoleDeleted is returned, once the token is revoked,
+// if its role was deleted since it was created.
+func (b *backend) trackIssuedToken(ctx context.Context, req *logical.Request, config baseConfiguration, source leaseSource, username string, resp *client.CreateTokenResponse, maxLeaseTTL time.Duration) error {
+	walID, err := b.putAccessTokenWAL(ctx, req.Storage, config, walAccessToken{
+		TokenID:    source.TokenID,
+		Connection: source.Connection,
+		UserToken:  source.Source == leaseSourceUserToken,
+		Username:   source.UserTokenConfig,
+	})
+	if err != nil {
+		return err
+	}
+
+	token := newIssuedToken(req, source, username, resp, maxLeaseTTL)
+	if source.Source == leaseSourceRole {
+		err = b.storeIssuedRoleToken(ctx, req.Storage, token)
+	} else {
+		err = b.storeIssuedToken(ctx, req.Storage, token)
+	}
+	if err != nil && !errors.Is(err, errRoleDeleted) {
+		return err
+	}
+
+	if err := b.deleteAccessTokenWAL(ctx, req.Storage, walID); err != nil {
+		return err
+	}
+
+	return err
+}
+
+// errRoleDeleted is returned by storeIssuedRoleToken when the role of the token was deleted while it was issued
+var errRoleDeleted = errors.New("role was deleted")
+
+// storeIssuedRoleToken records token, issued for a role, in the ledger while holding the lock of the role, so that a
+// role deleted with revoke_tokens finds all its tokens in the ledger. If the role was deleted since the token was
+// created, the token is revoked instead and errRoleDeleted is returned.
+func (b *backend) storeIssuedRoleToken(ctx context.Context, storage logical.Storage, token issuedToken) error {
+	lock := b.lockForKey(rolePath + token.Role)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	role, err := b.Role(ctx, storage, token.Role)
+	if err != nil {
+		return err
+	}
+
+	if role != nil {
+		return b.storeIssuedToken(ctx, storage, token)
+	}
+
+	source := token.source()
+	credentials, err := b.revocationCredentials(ctx, storage, source)
+	if err == nil {
+		err = b.revokeTokenWithCredentials(ctx, credentials, source.Connection, source.TokenID)
+	}
+	if err != nil {
+		return fmt.Errorf("role %s was deleted while token %s was issued, and it could not be revoked: %w", token.Role, token.TokenID, err)
+	}
+
+	return errRoleDeleted
+}
+
+// updateIssuedToken applies update to the ledger entry of tokenID, if there's one. Tokens issued before the ledger
+// was introduced have none.
+func (b *backend) updateIssuedToken(ctx context.Context, storage logical.Storage, tokenID string, update func(*issuedToken)) error {
+	if tokenID == "" {
+		return nil
+	}
+
+	lock := b.lockForKey(issuedTokensPath + tokenID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	token, err := fetchIssuedToken(ctx, storage, tokenID)
+	if err != nil || token == nil {
+		return err
+	}
+
+	update(token)
+
+	// The indexed fields are never updated
+	return putIssuedToken(ctx, storage, *token)
+}
+
+// recordIssuedTokenLease records leaseID, the ID of the lease of tokenID, in the ledger. The lease is renewed or
+// revoked even if the ledger can't be updated.
+func (b *backend) recordIssuedTokenLease(ctx context.Context, storage logical.Storage, tokenID string, leaseID string) {
+	if leaseID == "" {
+		return
+	}
+
+	err := b.updateIssuedToken(ctx, storage, tokenID, func(token *issuedToken) {
+		token.LeaseID = leaseID
+	})
+	if err != nil {
+		b.Logger().With("func", "recordIssuedTokenLease").Warn("failed to update token ledger", "tokenId", tokenID, "err", err)
+	}
+}
+
+// markIssuedTokenRevoked records in the ledger that the lease of tokenID was revoked, and whether the token is queued
+// to be revoked in Artifactory. The lease is revoked even if the ledger can't be updated.
+func (b *backend) markIssuedTokenRevoked(ctx context.Context, storage logical.Storage, tokenID string, pending bool) {
+	err := b.updateIssuedToken(ctx, storage, tokenID, func(token *issuedToken) {
+		if token.RevokedAt.IsZero() {
+			token.RevokedAt = time.Now()
+		}
+		token.RevocationPending = pending
+	})
+	if err != nil {
+		b.Logger().With("func", "markIssuedTokenRevoked").Warn("failed to update token ledger", "tokenId", tokenID, "err", err)
+	}
+}
+
+// pruneIssuedTokens deletes the tokens revoked or expired for longer than issuedTokenRetention from the ledger
+func (b *backend) pruneIssuedTokens(ctx context.Context, req *logical.Request) error {
+	logger := b.Logger().With("func", "pruneIssuedTokens")
+
+	tokens, err := b.listIssuedTokens(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	pruned := 0
+	for _, token := range tokens {
+		endedAt := token.endedAt()
+		if endedAt.IsZero() || time.Since(endedAt) < issuedTokenRetention {
+			continue
+		}
+
+		if err := deleteIssuedToken(ctx, req.Storage, token); err != nil {
+			errs = append(errs, fmt.Errorf("error pruning token %s: %w", token.TokenID, err))
+			continue
+		}
+		pruned++
+	}
+
+	if pruned > 0 {
+		logger.Debug("pruned token ledger", "pruned", pruned)
+	}
+
+	return errors.Join(errs...)
+}
+
+// deleteIssuedToken deletes the ledger entry of token and its index entries. The index entries are deleted first, so
+// that the entry is pruned again if they can't be.
+func deleteIssuedToken(ctx context.Context, storage logical.Storage, token *issuedToken) error {
+	for _, key := range token.indexKeys() {
+		if err := storage.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return storage.Delete(ctx, issuedTokensPath+token.TokenID)
+}
+
+const issuedTokensHelp = `
+The backend keeps a ledger of the tokens it issues, with the role or user token configuration they were issued for,
+the Artifactory username and scope, the Vault entity and display name of the requester, when they were issued and when
+they expire in Artifactory and in Vault. Vault generates the lease ID once the token is returned, so it is recorded,
+as lease_id, when the lease is first renewed or revoked. Until then, only the prefix of the lease ID is known, as
+lease_path_prefix. The prefix is shared by all the leases of the same path, list them with
+sys/leases/lookup/<lease_path_prefix> to find the lease of a token.
+
+Tokens are marked revoked when their lease is revoked, and removed from the ledger 30 days after they are revoked or
+expired.
+`
@@ -0,0 +1,163 @@
+package artifactory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+func listIssuedTokens(t *testing.T, b *backend, storage logical.Storage, path string) map[string]interface{} {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      path,
+		Storage:   storage,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+
+	keyInfo, _ := resp.Data["key_info"].(map[string]interface{})
+	return keyInfo
+}
+
+func TestBackend_IssuedTokensLedger(t *testing.T) {
+	tokenID := "59e39159-19eb-463d-953d-1d6baf567db6"
+
+	b, config := fakeConfiguredBackend(t, &fakeArtifactory{}, map[string]interface{}{
+		"access_token": "test-admin-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	assert.Contains(t, b.SpecialPaths().LocalStorage, issuedTokensPath)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/test-role",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"username": "test-username",
+			"scope":    "test-scope",
+			"max_ttl":  "1h",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "token/test-role",
+		MountPoint:  "artifactory/",
+		EntityID:    "test-entity-id",
+		DisplayName: "test-display-name",
+		Storage:     config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+
+	tokens := listIssuedTokens(t, b, config.StorageView, "tokens/")
+	assert.Len(t, tokens, 1)
+	assert.Equal(t, "test-role", tokens[tokenID].(map[string]interface{})["role"])
+
+	assert.Len(t, listIssuedTokens(t, b, config.StorageView, "tokens/by-role/test-role"), 1)
+	assert.Empty(t, listIssuedTokens(t, b, config.StorageView, "tokens/by-role/other-role"))
+
+	read, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "tokens/" + tokenID,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, read)
+	assert.Equal(t, leaseSourceRole, read.Data["source"])
+	assert.Equal(t, "test-username", read.Data["username"])
+	assert.Equal(t, "applied-permissions/admin", read.Data["scope"])
+	assert.Equal(t, "artifactory/token/test-role", read.Data["lease_path_prefix"])
+	assert.Equal(t, "test-entity-id", read.Data["entity_id"])
+	assert.Equal(t, "test-display-name", read.Data["display_name"])
+	assert.WithinDuration(t, time.Now().Add(31536000*time.Second), read.Data["expires_at"].(time.Time), 5*time.Second)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), read.Data["lease_expires_at"].(time.Time), 5*time.Second)
+	assert.NotContains(t, read.Data, "revoked_at")
+	assert.NotContains(t, read.Data, "lease_id")
+
+	// The lease ID is recorded once Vault passes it, when the lease is renewed
+	resp.Secret.LeaseID = "artifactory/token/test-role/test-lease-id"
+	resp.Secret.IssueTime = time.Now()
+	renewed, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RenewOperation,
+		Path:      "token/test-role",
+		Secret:    resp.Secret,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.False(t, renewed.IsError())
+
+	read, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "tokens/" + tokenID,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "artifactory/token/test-role/test-lease-id", read.Data["lease_id"])
+
+	// Marked revoked with the lease
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Path:      "token/test-role",
+		Secret:    resp.Secret,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+
+	token, err := fetchIssuedToken(context.Background(), config.StorageView, tokenID)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), token.RevokedAt, 5*time.Second)
+	assert.False(t, token.RevocationPending)
+	assert.Equal(t, "artifactory/token/test-role/test-lease-id", token.LeaseID)
+
+	// Pruned once revoked for longer than the retention
+	assert.NoError(t, b.pruneIssuedTokens(context.Background(), &logical.Request{Storage: config.StorageView}))
+	assert.Len(t, listIssuedTokens(t, b, config.StorageView, "tokens/"), 1)
+
+	token.RevokedAt = time.Now().Add(-issuedTokenRetention - time.Minute)
+	assert.NoError(t, b.storeIssuedToken(context.Background(), config.StorageView, *token))
+
+	indexed, err := b.listIssuedTokensByUser(context.Background(), config.StorageView, token.Username)
+	assert.NoError(t, err)
+	assert.Len(t, indexed, 1)
+
+	assert.NoError(t, b.pruneIssuedTokens(context.Background(), &logical.Request{Storage: config.StorageView}))
+	assert.Empty(t, listIssuedTokens(t, b, config.StorageView, "tokens/"))
+
+	// With its index entries
+	keys, err := config.StorageView.List(context.Background(), issuedTokensPath)
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	// User tokens are recorded with their user token configuration
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "user_token/test-user",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+
+	token, err = fetchIssuedToken(context.Background(), config.StorageView, tokenID)
+	assert.NoError(t, err)
+	assert.Equal(t, leaseSourceUserToken, token.Source)
+	assert.Equal(t, "test-user", token.Username)
+	assert.Empty(t, listIssuedTokens(t, b, config.StorageView, "tokens/by-role/test-role"))
+}
+
+func TestIssuedToken_EndedAt(t *testing.T) {
+	now := time.Now()
+
+	assert.True(t, issuedToken{}.endedAt().IsZero())
+	assert.Equal(t, now, issuedToken{RevokedAt: now, ExpiresAt: now.Add(time.Hour)}.endedAt())
+	assert.True(t, issuedToken{RevokedAt: now, RevocationPending: true}.endedAt().IsZero())
+	assert.Equal(t, now, issuedToken{ExpiresAt: now, LeaseExpiresAt: now.Add(time.Hour)}.endedAt())
+	assert.Equal(t, now, issuedToken{ExpiresAt: now.Add(time.Hour), LeaseExpiresAt: now}.endedAt())
+	assert.Equal(t, now, issuedToken{LeaseExpiresAt: now}.endedAt())
+}
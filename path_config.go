@@ -3,58 +3,214 @@ package artifactory
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/template"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/hashicorp/vault/sdk/rotation"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
 )
 
 const configAdminPath = "config/admin"
 
+var rootCertFingerprintRegex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+const (
+	defaultMaxRetries              = 3
+	defaultRetryWaitMin            = 1 * time.Second
+	defaultRetryWaitMax            = 10 * time.Second
+	defaultCircuitBreakerThreshold = 5
+	defaultCircuitBreakerTimeout   = 30 * time.Second
+	defaultVersionCacheTTL         = 1 * time.Hour
+	defaultRootCertCacheTTL        = 24 * time.Hour
+)
+
+// configFields returns the fields of config/admin, which named connections share
+func configFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"access_token": {
+			Type:        framework.TypeString,
+			Description: "Administrator token to access Artifactory",
+		},
+		"url": {
+			Type:        framework.TypeString,
+			Required:    true,
+			Description: "Address of the Artifactory instance",
+		},
+		"urls": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "Optional. Ordered list of addresses of the same Artifactory instance, e.g. the nodes of an active/passive HA setup. Requests fail over to the next address that answers on connection errors or 502, 503 and 504 responses. `url` is set to the first one. Set to an empty list to remove.",
+		},
+		"access_url": {
+			Type:        framework.TypeString,
+			Description: "Optional. Address of the Access service, when it is not reachable at `url` + `/access`. E.g. `https://access.example.com/access`. Set to an empty string to remove.",
+		},
+		"artifactory_url": {
+			Type:        framework.TypeString,
+			Description: "Optional. Address of the Artifactory service, when it is not reachable at `url` + `/artifactory`. E.g. `https://artifactory.example.com/artifactory`. Set to an empty string to remove.",
+		},
+		"request_headers": {
+			Type:        framework.TypeKVPairs,
+			Description: "Optional. Additional HTTP headers sent with every request to Artifactory, e.g. for an authenticating proxy. Values are stored seal wrapped when available, and cannot be read back.",
+		},
+		"username_template": {
+			Type:        framework.TypeString,
+			Description: "Optional. Vault Username Template for dynamically generating usernames.",
+		},
+		"use_expiring_tokens": {
+			Type:        framework.TypeBool,
+			Default:     false,
+			Description: "Optional. If Artifactory version >= 7.50.3, set expires_in to max_ttl and force_revocable.",
+		},
+		"force_revocable": {
+			Type:        framework.TypeBool,
+			Default:     false,
+			Description: "Optional. When set to true, we will add the 'force_revocable' flag to the token's extension. In addition, a new configuration has been added that sets the default for setting the 'force_revocable' default when creating a new token - the default of this configuration will be 'false' to ensure that the Circle of Trust remains in place.",
+		},
+		"bypass_artifactory_tls_verification": {
+			Type:        framework.TypeBool,
+			Default:     false,
+			Description: "Optional. Bypass certification verification for TLS connection with Artifactory. Default to `false`.",
+		},
+		"ca_cert": {
+			Type:        framework.TypeString,
+			Description: "Optional. PEM encoded CA certificate bundle used to verify the Artifactory TLS certificate, instead of the system CA pool.",
+		},
+		"client_cert": {
+			Type:        framework.TypeString,
+			Description: "Optional. PEM encoded client certificate presented to Artifactory for mutual TLS. Requires `client_key`.",
+		},
+		"client_key": {
+			Type:        framework.TypeString,
+			Description: "Optional. PEM encoded private key of `client_cert`. This value is stored seal wrapped when available, and cannot be read back.",
+		},
+		"tls_server_name": {
+			Type:        framework.TypeString,
+			Description: "Optional. Server name used to verify the Artifactory TLS certificate and for SNI, when it differs from the host in `url`.",
+		},
+		"tls_min_version": {
+			Type:        framework.TypeString,
+			Description: "Optional. Minimum TLS version accepted when connecting to Artifactory. One of `tls10`, `tls11`, `tls12` or `tls13`. Default to `tls12`.",
+		},
+		"request_timeout": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional. Time limit for a single request to Artifactory, including reading the response. Default to `30s`.",
+		},
+		"proxy_url": {
+			Type:        framework.TypeString,
+			Description: "Optional. URL of the HTTP(S) or SOCKS5 proxy used to connect to Artifactory. Default to the proxy set in the Vault process environment.",
+		},
+		"max_idle_conns": {
+			Type:        framework.TypeInt,
+			Description: "Optional. Maximum number of idle connections kept open to Artifactory. Set to 0 for no limit. Default to `100`.",
+		},
+		"max_idle_conns_per_host": {
+			Type:        framework.TypeInt,
+			Description: "Optional. Maximum number of idle connections kept open per Artifactory host. Default to `2`.",
+		},
+		"idle_conn_timeout": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional. Time an idle connection to Artifactory is kept open before being closed. Default to `90s`.",
+		},
+		"keep_alive": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional. Interval between TCP keep-alive probes on connections to Artifactory. Default to `30s`.",
+		},
+		"disable_keep_alives": {
+			Type:        framework.TypeBool,
+			Default:     false,
+			Description: "Optional. Use a new connection for every request to Artifactory. Default to `false`.",
+		},
+		"allow_scope_override": {
+			Type:        framework.TypeBool,
+			Default:     false,
+			Description: "Optional. Determine if scoped tokens should be allowed. This is an advanced configuration option. Default to `false`.",
+		},
+		"revoke_on_delete": {
+			Type:        framework.TypeBool,
+			Default:     false,
+			Description: "Optional. Revoke Administrator access token when this configuration is deleted. Default to `false`. Will be set to `true` if token is rotated.",
+		},
+		"max_retries": {
+			Type:        framework.TypeInt,
+			Description: "Optional. Maximum number of times a failed request to Artifactory is retried. Token creation is only retried if the request never reached Artifactory. Set to 0 to disable retries. Default to `3`.",
+		},
+		"retry_wait_min": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional. Minimum time to wait before retrying a failed request. Doubled (with jitter) on every retry. Default to `1s`.",
+		},
+		"retry_wait_max": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional. Maximum time to wait before retrying a failed request, including waits requested by Artifactory with a `Retry-After` header. Default to `10s`.",
+		},
+		"circuit_breaker_threshold": {
+			Type:        framework.TypeInt,
+			Description: "Optional. Number of consecutive failures to reach Artifactory after which requests fail fast without contacting Artifactory. Set to 0 to disable. Default to `5`.",
+		},
+		"circuit_breaker_timeout": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional. Time requests fail fast once the circuit breaker opens, before a single request is let through to check if Artifactory is back. Default to `30s`.",
+		},
+		"version_cache_ttl": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional. How long the detected Artifactory version, and the capabilities derived from it, are cached before being fetched again. Default to `1h`.",
+		},
+		"root_cert_sha256": {
+			Type:        framework.TypeString,
+			Description: "Optional. Hex encoded SHA-256 fingerprint of the Artifactory Access root certificate. When set, a root certificate with a different fingerprint is refused and tokens are not validated against it. Set to an empty string to remove.",
+		},
+		"root_cert_cache_ttl": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional. How long the Artifactory Access root certificate used to validate token signatures is cached before being fetched again. It is also fetched again when a token signature doesn't match the cached certificate. Default to `24h`.",
+		},
+		"rotation_period": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional. Rotate the access token automatically at this interval, counted from the last rotation. Mutually exclusive with `rotation_schedule`. Set to 0 to disable. Default to `0`.",
+		},
+		"rotation_schedule": {
+			Type:        framework.TypeString,
+			Description: "Optional. Rotate the access token automatically on this cron-style schedule, e.g. `0 0 * * SAT`. Mutually exclusive with `rotation_period`. Set to an empty string to disable.",
+		},
+		"revoke_grace_period": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional. Time to wait before revoking the previous access token after a rotation, so requests still using it can complete. Set to 0 to revoke it immediately. Default to `0`.",
+		},
+		"rotated_token_ttl": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional. Time a rotated access token is valid for. Only set if use_expiring_tokens is true and Artifactory supports it. Set to 0 for no expiry. Default to `0`.",
+		},
+		"rotate_before_expiry": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional. Rotate the access token automatically when it expires within this duration. Set to 0 to disable. Default to `0`.",
+		},
+		"token_marker": {
+			Type:        framework.TypeString,
+			Description: "Optional. Prefix of the marker added to the description of the tokens issued for leases, to find the tokens left without a lease. A random ID of the mount on this Vault cluster is appended. Default to `vault:<mount accessor>`.",
+		},
+		"reconcile_interval": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Optional. Reconcile the tokens issued with this configuration against their leases automatically at this interval. Set to 0 to disable. Default to `0`.",
+		},
+		"reconcile_revoke": {
+			Type:        framework.TypeBool,
+			Description: "Optional. Revoke the tokens left without a lease found by the automatic reconciliation, rather than only report them. Default to `false`.",
+		},
+	}
+}
+
 func (b *backend) pathConfig() *framework.Path {
 	return &framework.Path{
 		Pattern: configAdminPath,
-		Fields: map[string]*framework.FieldSchema{
-			"access_token": {
-				Type:        framework.TypeString,
-				Description: "Administrator token to access Artifactory",
-			},
-			"url": {
-				Type:        framework.TypeString,
-				Required:    true,
-				Description: "Address of the Artifactory instance",
-			},
-			"username_template": {
-				Type:        framework.TypeString,
-				Description: "Optional. Vault Username Template for dynamically generating usernames.",
-			},
-			"use_expiring_tokens": {
-				Type:        framework.TypeBool,
-				Default:     false,
-				Description: "Optional. If Artifactory version >= 7.50.3, set expires_in to max_ttl and force_revocable.",
-			},
-			"force_revocable": {
-				Type:        framework.TypeBool,
-				Default:     false,
-				Description: "Optional. When set to true, we will add the 'force_revocable' flag to the token's extension. In addition, a new configuration has been added that sets the default for setting the 'force_revocable' default when creating a new token - the default of this configuration will be 'false' to ensure that the Circle of Trust remains in place.",
-			},
-			"bypass_artifactory_tls_verification": {
-				Type:        framework.TypeBool,
-				Default:     false,
-				Description: "Optional. Bypass certification verification for TLS connection with Artifactory. Default to `false`.",
-			},
-			"allow_scope_override": {
-				Type:        framework.TypeBool,
-				Default:     false,
-				Description: "Optional. Determine if scoped tokens should be allowed. This is an advanced configuration option. Default to `false`.",
-			},
-			"revoke_on_delete": {
-				Type:        framework.TypeBool,
-				Default:     false,
-				Description: "Optional. Revoke Administrator access token when this configuration is deleted. Default to `false`. Will be set to `true` if token is rotated.",
-			},
-		},
+		Fields:  configFields(),
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.UpdateOperation: &framework.PathOperation{
 				Callback: b.pathConfigUpdate,
@@ -73,8 +229,12 @@ func (b *backend) pathConfig() *framework.Path {
 		HelpDescription: `
 Configure the parameters used to connect to the Artifactory server integrated with this backend.
 
-The two main parameters are "url" which is the absolute URL to the Artifactory server. Note that "/artifactory/api"
-is prepended by the individual calls, so do not include it in the URL here.
+The two main parameters are "url" which is the absolute URL to the Artifactory server. Any path, e.g. "https://example.com/jfrog",
+is preserved. Note that "/access/api" and "/artifactory/api" are appended by the individual calls, so do not include them in the URL here.
+An optional "urls" parameter lists several addresses of the same instance, e.g. an active/passive HA setup. Requests fail over
+to the next address that answers on connection errors or 502, 503 and 504 responses. "url" is the first one.
+Optional "access_url" and "artifactory_url" parameters override where the Access and Artifactory APIs are found, for gateways that
+route them differently. An optional "request_headers" parameter adds HTTP headers to every request, e.g. for an authenticating proxy.
 
 The second is "access_token" which must be an access token powerful enough to generate the other access tokens you'll
 be using. This value is stored seal wrapped when available. Once set, the access token cannot be retrieved, but the backend
@@ -86,9 +246,34 @@ usernames if a static one is not provided.
 
 An optional "bypass_artifactory_tls_verification" parameter will enable bypassing the TLS connection verification with Artifactory.
 
+Optional "ca_cert", "client_cert", "client_key", "tls_server_name" and "tls_min_version" parameters configure the TLS connection
+with Artifactory, e.g. for an internal CA or a gateway enforcing mutual TLS. "client_key" is stored seal wrapped when available.
+
+Optional "request_timeout", "proxy_url", "max_idle_conns", "max_idle_conns_per_host", "idle_conn_timeout", "keep_alive" and
+"disable_keep_alives" parameters configure the connections to Artifactory.
+
 An optional "allow_scope_override" parameter will enable issuing scoped tokens with Artifactory. This is an advanced option that must
 have more sophisticated Vault policies. Please see README for an example.
 
+Optional "max_retries", "retry_wait_min" and "retry_wait_max" parameters control how requests to Artifactory are retried on
+connection errors and 429, 502, 503 or 504 responses. Optional "circuit_breaker_threshold" and "circuit_breaker_timeout" parameters
+control when requests fail fast while Artifactory is unavailable.
+
+The Artifactory version is cached for "version_cache_ttl" and fetched again whenever this configuration is written.
+
+The Access root certificate used to validate token signatures is cached for "root_cert_cache_ttl". An optional "root_cert_sha256"
+parameter pins the root certificate to the given fingerprint.
+
+Optional "rotation_period" or "rotation_schedule" parameters rotate the access token automatically, like config/rotate, at a fixed
+interval or on a cron-style schedule. An optional "rotate_before_expiry" parameter also rotates it when it expires within the given
+duration. The last rotation, the last rotation error and the next scheduled rotation are returned when reading this configuration.
+An optional "revoke_grace_period" parameter delays revoking the previous access token after a rotation, and an optional
+"rotated_token_ttl" parameter sets the expiry of rotated access tokens.
+
+The description of the tokens issued for leases is tagged with a marker, made of an optional "token_marker" prefix and a random
+ID of the mount on this Vault cluster. An optional "reconcile_interval" parameter finds the tokens with this marker that no longer
+have a lease, like reconcile, at a fixed interval. They are only reported, unless the optional "reconcile_revoke" parameter is set.
+
 No renewals or new tokens will be issued if the backend configuration (config/admin) is deleted.
 `,
 	}
@@ -96,18 +281,116 @@ No renewals or new tokens will be issued if the backend configuration (config/ad
 
 type adminConfiguration struct {
 	baseConfiguration
-	UsernameTemplate                 string `json:"username_template,omitempty"`
-	BypassArtifactoryTLSVerification bool   `json:"bypass_artifactory_tls_verification,omitempty"`
-	AllowScopeOverride               bool   `json:"allow_scope_override,omitempty"`
-	RevokeOnDelete                   bool   `json:"revoke_on_delete,omitempty"`
+	URLs                             []string      `json:"urls,omitempty"`
+	UsernameTemplate                 string        `json:"username_template,omitempty"`
+	BypassArtifactoryTLSVerification bool          `json:"bypass_artifactory_tls_verification,omitempty"`
+	CACert                           string        `json:"ca_cert,omitempty"`
+	ClientCert                       string        `json:"client_cert,omitempty"`
+	ClientKey                        string        `json:"client_key,omitempty"`
+	TLSServerName                    string        `json:"tls_server_name,omitempty"`
+	TLSMinVersion                    string        `json:"tls_min_version,omitempty"`
+	RequestTimeout                   time.Duration `json:"request_timeout,omitempty"`
+	ProxyURL                         string        `json:"proxy_url,omitempty"`
+	MaxIdleConns                     *int          `json:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost              *int          `json:"max_idle_conns_per_host,omitempty"`
+	IdleConnTimeout                  time.Duration `json:"idle_conn_timeout,omitempty"`
+	KeepAlive                        time.Duration `json:"keep_alive,omitempty"`
+	DisableKeepAlives                bool          `json:"disable_keep_alives,omitempty"`
+	AllowScopeOverride               bool          `json:"allow_scope_override,omitempty"`
+	RevokeOnDelete                   bool          `json:"revoke_on_delete,omitempty"`
+	MaxRetries                       *int          `json:"max_retries,omitempty"`
+	RetryWaitMin                     time.Duration `json:"retry_wait_min,omitempty"`
+	RetryWaitMax                     time.Duration `json:"retry_wait_max,omitempty"`
+	CircuitBreakerThreshold          *int          `json:"circuit_breaker_threshold,omitempty"`
+	CircuitBreakerTimeout            time.Duration `json:"circuit_breaker_timeout,omitempty"`
+	VersionCacheTTL                  time.Duration `json:"version_cache_ttl,omitempty"`
+	RootCertCacheTTL                 time.Duration `json:"root_cert_cache_ttl,omitempty"`
+	RotationPeriod                   time.Duration `json:"rotation_period,omitempty"`
+	RotationSchedule                 string        `json:"rotation_schedule,omitempty"`
+	RotateBeforeExpiry               time.Duration `json:"rotate_before_expiry,omitempty"`
+	RevokeGracePeriod                time.Duration `json:"revoke_grace_period,omitempty"`
+	RotatedTokenTTL                  time.Duration `json:"rotated_token_ttl,omitempty"`
+	TokenMarker                      string        `json:"token_marker,omitempty"`
+	ReconcileInterval                time.Duration `json:"reconcile_interval,omitempty"`
+	ReconcileRevoke                  bool          `json:"reconcile_revoke,omitempty"`
+}
+
+// retryPolicy returns the effective retry settings, applying defaults for unset values
+func (c *adminConfiguration) retryPolicy() client.RetryPolicy {
+	policy := client.RetryPolicy{
+		MaxRetries:   defaultMaxRetries,
+		RetryWaitMin: defaultRetryWaitMin,
+		RetryWaitMax: defaultRetryWaitMax,
+	}
+
+	if c.MaxRetries != nil {
+		policy.MaxRetries = *c.MaxRetries
+	}
+
+	if c.RetryWaitMin > 0 {
+		policy.RetryWaitMin = c.RetryWaitMin
+	}
+
+	if c.RetryWaitMax > 0 {
+		policy.RetryWaitMax = c.RetryWaitMax
+	}
+
+	return policy
+}
+
+// circuitBreakerSettings returns the effective circuit breaker threshold and timeout, applying defaults for unset values
+func (c *adminConfiguration) circuitBreakerSettings() (threshold int, timeout time.Duration) {
+	threshold = defaultCircuitBreakerThreshold
+	if c.CircuitBreakerThreshold != nil {
+		threshold = *c.CircuitBreakerThreshold
+	}
+
+	timeout = defaultCircuitBreakerTimeout
+	if c.CircuitBreakerTimeout > 0 {
+		timeout = c.CircuitBreakerTimeout
+	}
+
+	return
+}
+
+// endpointURLs returns the URLs requests are sent to, in order of preference
+func (c *adminConfiguration) endpointURLs() []string {
+	if len(c.URLs) > 0 {
+		return c.URLs
+	}
+
+	return []string{c.ArtifactoryURL}
+}
+
+// versionCacheTTL returns the effective version cache TTL, applying the default if unset
+func (c *adminConfiguration) versionCacheTTL() time.Duration {
+	if c.VersionCacheTTL > 0 {
+		return c.VersionCacheTTL
+	}
+
+	return defaultVersionCacheTTL
+}
+
+// rootCertCacheTTL returns the effective root certificate cache TTL, applying the default if unset
+func (c *adminConfiguration) rootCertCacheTTL() time.Duration {
+	if c.RootCertCacheTTL > 0 {
+		return c.RootCertCacheTTL
+	}
+
+	return defaultRootCertCacheTTL
 }
 
 // fetchAdminConfiguration will return nil,nil if there's no configuration
 func (b *backend) fetchAdminConfiguration(ctx context.Context, storage logical.Storage) (*adminConfiguration, error) {
+	return b.fetchConnectionConfiguration(ctx, storage, defaultConnection)
+}
+
+// fetchConnectionConfiguration will return nil,nil if the named connection is not configured
+func (b *backend) fetchConnectionConfiguration(ctx context.Context, storage logical.Storage, name string) (*adminConfiguration, error) {
 	var config adminConfiguration
 
-	// Read in the backend configuration
-	entry, err := storage.Get(ctx, configAdminPath)
+	// Read in the connection configuration
+	entry, err := storage.Get(ctx, connectionStoragePath(name))
 	if err != nil {
 		return nil, err
 	}
@@ -119,15 +402,26 @@ func (b *backend) fetchAdminConfiguration(ctx context.Context, storage logical.S
 	if err := entry.DecodeJSON(&config); err != nil {
 		return nil, err
 	}
+	config.connection = name
+
+	// The HTTP client is reset when the configuration changes on another node, rebuild it from the new configuration
+	if !b.connectionInitialized(name) {
+		if err := b.InitializeHttpClient(&config); err != nil {
+			return nil, err
+		}
+	}
 
 	return &config, nil
 }
 
 func (b *backend) pathConfigUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	b.configMutex.Lock()
-	defer b.configMutex.Unlock()
+	name := connectionName(data)
 
-	config, err := b.fetchAdminConfiguration(ctx, req.Storage)
+	lock := b.lockForKey(connectionStoragePath(name))
+	lock.Lock()
+	defer lock.Unlock()
+
+	config, err := b.fetchConnectionConfiguration(ctx, req.Storage, name)
 	if err != nil {
 		return nil, err
 	}
@@ -136,6 +430,7 @@ func (b *backend) pathConfigUpdate(ctx context.Context, req *logical.Request, da
 		config = &adminConfiguration{
 			baseConfiguration: baseConfiguration{
 				UseNewAccessAPI: true,
+				connection:      name,
 			},
 		}
 	}
@@ -145,17 +440,73 @@ func (b *backend) pathConfigUpdate(ctx context.Context, req *logical.Request, da
 		config.AccessToken = "" // clear access token if URL changes, requires setting access_token and url together for security reasons
 	}
 
+	// Like url, changing the failover URLs requires setting access_token again
+	if val, ok := data.GetOk("urls"); ok && !slices.Equal(val.([]string), config.URLs) {
+		config.URLs = val.([]string)
+		config.AccessToken = ""
+
+		if _, ok := data.GetOk("url"); !ok && len(config.URLs) > 0 {
+			config.ArtifactoryURL = config.URLs[0]
+		}
+	}
+
+	for _, endpointURL := range config.URLs {
+		if _, err := url.ParseRequestURI(endpointURL); err != nil {
+			return logical.ErrorResponse("invalid urls: %s", err), nil
+		}
+	}
+
+	if len(config.URLs) > 0 && config.ArtifactoryURL != config.URLs[0] {
+		return logical.ErrorResponse("url must be the first of urls"), nil
+	}
+
+	// Like url, changing where the APIs are found requires setting access_token again
+	if val, ok := data.GetOk("access_url"); ok && val.(string) != config.AccessURL {
+		config.AccessURL = val.(string)
+		config.AccessToken = ""
+	}
+
+	if val, ok := data.GetOk("artifactory_url"); ok && val.(string) != config.ArtifactoryServiceURL {
+		config.ArtifactoryServiceURL = val.(string)
+		config.AccessToken = ""
+	}
+
+	for name, serviceURL := range map[string]string{"access_url": config.AccessURL, "artifactory_url": config.ArtifactoryServiceURL} {
+		if serviceURL == "" {
+			continue
+		}
+		if _, err := url.ParseRequestURI(serviceURL); err != nil {
+			return logical.ErrorResponse("invalid %s: %s", name, err), nil
+		}
+	}
+
+	if val, ok := data.GetOk("request_headers"); ok {
+		headers := map[string]string{}
+		for name, value := range val.(map[string]string) {
+			name = http.CanonicalHeaderKey(name)
+			if name == "Authorization" || name == "User-Agent" {
+				return logical.ErrorResponse("request_headers must not set the %s header", name), nil
+			}
+			headers[name] = value
+		}
+		config.RequestHeaders = headers
+	}
+
 	if val, ok := data.GetOk("access_token"); ok {
 		config.AccessToken = val.(string)
 	}
 
+	// Named connections compile their username_template when a token is created
+	var usernameProducer *template.StringTemplate
 	if val, ok := data.GetOk("username_template"); ok {
 		config.UsernameTemplate = val.(string)
 		up, err := testUsernameTemplate(config.UsernameTemplate)
 		if err != nil {
 			return logical.ErrorResponse("username_template error"), err
 		}
-		b.usernameProducer = up
+		if name == defaultConnection {
+			usernameProducer = &up
+		}
 	}
 
 	if val, ok := data.GetOk("use_expiring_tokens"); ok {
@@ -173,6 +524,66 @@ func (b *backend) pathConfigUpdate(ctx context.Context, req *logical.Request, da
 		config.BypassArtifactoryTLSVerification = val.(bool)
 	}
 
+	if val, ok := data.GetOk("ca_cert"); ok {
+		config.CACert = val.(string)
+	}
+
+	if val, ok := data.GetOk("client_cert"); ok {
+		config.ClientCert = val.(string)
+	}
+
+	if val, ok := data.GetOk("client_key"); ok {
+		config.ClientKey = val.(string)
+	}
+
+	if val, ok := data.GetOk("tls_server_name"); ok {
+		config.TLSServerName = val.(string)
+	}
+
+	if val, ok := data.GetOk("tls_min_version"); ok {
+		config.TLSMinVersion = val.(string)
+	}
+
+	if val, ok := data.GetOk("request_timeout"); ok {
+		config.RequestTimeout = time.Duration(val.(int)) * time.Second
+	}
+
+	if val, ok := data.GetOk("proxy_url"); ok {
+		config.ProxyURL = val.(string)
+	}
+
+	if val, ok := data.GetOk("max_idle_conns"); ok {
+		maxIdleConns := val.(int)
+		if maxIdleConns < 0 {
+			return logical.ErrorResponse("max_idle_conns must not be negative"), nil
+		}
+		config.MaxIdleConns = &maxIdleConns
+	}
+
+	if val, ok := data.GetOk("max_idle_conns_per_host"); ok {
+		maxIdleConnsPerHost := val.(int)
+		if maxIdleConnsPerHost < 0 {
+			return logical.ErrorResponse("max_idle_conns_per_host must not be negative"), nil
+		}
+		config.MaxIdleConnsPerHost = &maxIdleConnsPerHost
+	}
+
+	if val, ok := data.GetOk("idle_conn_timeout"); ok {
+		config.IdleConnTimeout = time.Duration(val.(int)) * time.Second
+	}
+
+	if val, ok := data.GetOk("keep_alive"); ok {
+		config.KeepAlive = time.Duration(val.(int)) * time.Second
+	}
+
+	if val, ok := data.GetOk("disable_keep_alives"); ok {
+		config.DisableKeepAlives = val.(bool)
+	}
+
+	if _, err := config.newHTTPClient(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
 	if val, ok := data.GetOk("allow_scope_override"); ok {
 		config.AllowScopeOverride = val.(bool)
 	}
@@ -181,19 +592,132 @@ func (b *backend) pathConfigUpdate(ctx context.Context, req *logical.Request, da
 		config.RevokeOnDelete = val.(bool)
 	}
 
+	if val, ok := data.GetOk("max_retries"); ok {
+		maxRetries := val.(int)
+		if maxRetries < 0 {
+			return logical.ErrorResponse("max_retries must not be negative"), nil
+		}
+		config.MaxRetries = &maxRetries
+	}
+
+	if val, ok := data.GetOk("retry_wait_min"); ok {
+		config.RetryWaitMin = time.Duration(val.(int)) * time.Second
+	}
+
+	if val, ok := data.GetOk("retry_wait_max"); ok {
+		config.RetryWaitMax = time.Duration(val.(int)) * time.Second
+	}
+
+	if retryPolicy := config.retryPolicy(); retryPolicy.RetryWaitMin > retryPolicy.RetryWaitMax {
+		return logical.ErrorResponse("retry_wait_min must not be greater than retry_wait_max"), nil
+	}
+
+	if val, ok := data.GetOk("circuit_breaker_threshold"); ok {
+		threshold := val.(int)
+		if threshold < 0 {
+			return logical.ErrorResponse("circuit_breaker_threshold must not be negative"), nil
+		}
+		config.CircuitBreakerThreshold = &threshold
+	}
+
+	if val, ok := data.GetOk("circuit_breaker_timeout"); ok {
+		config.CircuitBreakerTimeout = time.Duration(val.(int)) * time.Second
+	}
+
+	if val, ok := data.GetOk("version_cache_ttl"); ok {
+		config.VersionCacheTTL = time.Duration(val.(int)) * time.Second
+	}
+
+	if val, ok := data.GetOk("root_cert_sha256"); ok {
+		fingerprint := normalizeFingerprint(val.(string))
+		if fingerprint != "" && !rootCertFingerprintRegex.MatchString(fingerprint) {
+			return logical.ErrorResponse("root_cert_sha256 must be a hex encoded SHA-256 fingerprint"), nil
+		}
+		config.RootCertSHA256 = fingerprint
+	}
+
+	if val, ok := data.GetOk("root_cert_cache_ttl"); ok {
+		config.RootCertCacheTTL = time.Duration(val.(int)) * time.Second
+	}
+
+	rotationChanged := false
+
+	if val, ok := data.GetOk("rotation_period"); ok {
+		rotationPeriod := time.Duration(val.(int)) * time.Second
+		rotationChanged = rotationChanged || rotationPeriod != config.RotationPeriod
+		config.RotationPeriod = rotationPeriod
+	}
+
+	if val, ok := data.GetOk("rotation_schedule"); ok {
+		rotationChanged = rotationChanged || val.(string) != config.RotationSchedule
+		config.RotationSchedule = val.(string)
+	}
+
+	if config.RotationPeriod > 0 && config.RotationSchedule != "" {
+		return logical.ErrorResponse("rotation_period and rotation_schedule are mutually exclusive"), nil
+	}
+
+	if config.RotationSchedule != "" {
+		if _, err := rotation.DefaultScheduler.Parse(config.RotationSchedule); err != nil {
+			return logical.ErrorResponse("invalid rotation_schedule: %s", err), nil
+		}
+	}
+
+	if val, ok := data.GetOk("rotate_before_expiry"); ok {
+		config.RotateBeforeExpiry = time.Duration(val.(int)) * time.Second
+	}
+
+	if val, ok := data.GetOk("revoke_grace_period"); ok {
+		config.RevokeGracePeriod = time.Duration(val.(int)) * time.Second
+	}
+
+	if val, ok := data.GetOk("rotated_token_ttl"); ok {
+		config.RotatedTokenTTL = time.Duration(val.(int)) * time.Second
+	}
+
+	if val, ok := data.GetOk("token_marker"); ok {
+		config.TokenMarker = val.(string)
+	}
+
+	if val, ok := data.GetOk("reconcile_interval"); ok {
+		config.ReconcileInterval = time.Duration(val.(int)) * time.Second
+	}
+
+	if val, ok := data.GetOk("reconcile_revoke"); ok {
+		config.ReconcileRevoke = val.(bool)
+	}
+
 	if config.ArtifactoryURL == "" {
 		return logical.ErrorResponse("url is required"), nil
 	}
 
-	b.InitializeHttpClient(config)
+	// Capabilities and the root certificate are fetched again below, against the (possibly new) url and access_token
+	b.reset(name)
+	if err := b.InitializeHttpClient(config); err != nil {
+		return nil, err
+	}
 
-	if config.AccessToken != "" {
-		go b.sendUsage(config.baseConfiguration, "pathConfigRotateUpdate")
+	var warnings []string
 
-		config.UseNewAccessAPI = b.useNewAccessAPI(config.baseConfiguration)
+	if config.AccessToken != "" {
+		b.reportUsage(config.baseConfiguration, "pathConfigRotateUpdate")
+
+		config.UseNewAccessAPI = b.useNewAccessAPI(ctx, config.baseConfiguration)
+
+		if config.RootCertSHA256 != "" {
+			_, _, err := b.getRootCert(ctx, config.baseConfiguration)
+			switch {
+			case errors.Is(err, ErrRootCertMismatch):
+				return logical.ErrorResponse(err.Error()), nil
+			case errors.Is(err, ErrIncompatibleVersion):
+				warnings = append(warnings, "root_cert_sha256 is set, but Artifactory is older than 7.12.0 and does not provide its root certificate")
+			case err != nil:
+				b.Logger().With("func", "pathConfigUpdate").Warn("unable to check root_cert_sha256", "err", err)
+			}
+		}
 	}
 
-	entry, err := logical.StorageEntryJSON(configAdminPath, config)
+	entry, err := logical.StorageEntryJSON(connectionStoragePath(name), config)
 	if err != nil {
 		return nil, err
 	}
@@ -203,14 +727,32 @@ func (b *backend) pathConfigUpdate(ctx context.Context, req *logical.Request, da
 		return nil, err
 	}
 
+	// Only once stored, the write may be forwarded to the active node instead
+	if usernameProducer != nil {
+		b.usernameProducer = *usernameProducer
+	}
+
+	if rotationChanged {
+		if err := b.scheduleRotation(ctx, req.Storage, config, false); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(warnings) > 0 {
+		return &logical.Response{Warnings: warnings}, nil
+	}
+
 	return nil, nil
 }
 
-func (b *backend) pathConfigDelete(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
-	b.configMutex.Lock()
-	defer b.configMutex.Unlock()
+func (b *backend) pathConfigDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := connectionName(data)
 
-	config, err := b.fetchAdminConfiguration(ctx, req.Storage)
+	lock := b.lockForKey(connectionStoragePath(name))
+	lock.Lock()
+	defer lock.Unlock()
+
+	config, err := b.fetchConnectionConfiguration(ctx, req.Storage, name)
 	if err != nil {
 		return nil, err
 	}
@@ -219,26 +761,43 @@ func (b *backend) pathConfigDelete(ctx context.Context, req *logical.Request, _
 		return logical.ErrorResponse("backend not configured"), nil
 	}
 
-	if err := req.Storage.Delete(ctx, configAdminPath); err != nil {
+	if name != defaultConnection {
+		roles, err := b.rolesUsingConnection(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(roles) > 0 {
+			return logical.ErrorResponse("connection %s is used by roles: %s", name, strings.Join(roles, ", ")), nil
+		}
+	}
+
+	if err := req.Storage.Delete(ctx, connectionStoragePath(name)); err != nil {
 		return nil, err
 	}
 
+	if err := req.Storage.Delete(ctx, rotationStatusStoragePath(name)); err != nil {
+		return nil, err
+	}
+
+	// Keep the HTTP client until the access token is revoked below
+	defer b.reset(name)
+
 	if config.AccessToken == "" {
 		return nil, nil
 	}
 
-	go b.sendUsage(config.baseConfiguration, "pathConfigDelete")
+	b.reportUsage(config.baseConfiguration, "pathConfigDelete")
 
 	if config.RevokeOnDelete {
 		b.Logger().Info("config.RevokeOnDelete is 'true'. Attempt to revoke access token.")
 
-		token, err := b.getTokenInfo(config.baseConfiguration, config.AccessToken)
+		token, err := b.getTokenInfo(ctx, config.baseConfiguration, config.AccessToken)
 		if err != nil {
 			b.Logger().Warn("error parsing existing access token", "err", err)
 			return nil, nil
 		}
 
-		err = b.RevokeToken(config.baseConfiguration, token.TokenID)
+		err = b.RevokeToken(ctx, config.baseConfiguration, token.TokenID)
 		if err != nil {
 			b.Logger().Warn("error revoking existing access token", "tokenId", token.TokenID, "err", err)
 			return nil, nil
@@ -248,13 +807,12 @@ func (b *backend) pathConfigDelete(ctx context.Context, req *logical.Request, _
 	return nil, nil
 }
 
-func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
-	b.configMutex.RLock()
-	defer b.configMutex.RUnlock()
-
+func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	logger := b.Logger().With("func", "pathConfigRead")
 
-	config, err := b.fetchAdminConfiguration(ctx, req.Storage)
+	name := connectionName(data)
+
+	config, err := b.fetchConnectionConfiguration(ctx, req.Storage, name)
 	if err != nil {
 		return nil, err
 	}
@@ -265,13 +823,83 @@ func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, _ *f
 
 	configMap := map[string]interface{}{
 		"url":                                 config.ArtifactoryURL,
+		"access_url":                          config.AccessURL,
+		"artifactory_url":                     config.ArtifactoryServiceURL,
+		"request_headers":                     slices.Sorted(maps.Keys(config.RequestHeaders)),
 		"use_expiring_tokens":                 config.UseExpiringTokens,
 		"force_revocable":                     config.ForceRevocable,
 		"bypass_artifactory_tls_verification": config.BypassArtifactoryTLSVerification,
+		"ca_cert":                             config.CACert,
+		"client_cert":                         config.ClientCert,
+		"tls_server_name":                     config.TLSServerName,
+		"tls_min_version":                     config.TLSMinVersion,
 		"allow_scope_override":                config.AllowScopeOverride,
 		"revoke_on_delete":                    config.RevokeOnDelete,
 	}
 
+	transport, err := config.transportSettings()
+	if err != nil {
+		return nil, err
+	}
+	configMap["request_timeout"] = transport.RequestTimeout.Seconds()
+	configMap["proxy_url"] = ""
+	if transport.ProxyURL != nil {
+		configMap["proxy_url"] = transport.ProxyURL.Redacted()
+	}
+	configMap["max_idle_conns"] = transport.MaxIdleConns
+	configMap["max_idle_conns_per_host"] = transport.MaxIdleConnsPerHost
+	configMap["idle_conn_timeout"] = transport.IdleConnTimeout.Seconds()
+	configMap["keep_alive"] = transport.KeepAlive.Seconds()
+	configMap["disable_keep_alives"] = transport.DisableKeepAlives
+
+	retryPolicy := config.retryPolicy()
+	configMap["max_retries"] = retryPolicy.MaxRetries
+	configMap["retry_wait_min"] = retryPolicy.RetryWaitMin.Seconds()
+	configMap["retry_wait_max"] = retryPolicy.RetryWaitMax.Seconds()
+
+	circuitBreakerThreshold, circuitBreakerTimeout := config.circuitBreakerSettings()
+	configMap["circuit_breaker_threshold"] = circuitBreakerThreshold
+	configMap["circuit_breaker_timeout"] = circuitBreakerTimeout.Seconds()
+	state := b.connection(name)
+	activeURL, breaker := state.endpoints.current(config.ArtifactoryURL)
+	configMap["circuit_breaker_open"] = breaker.IsOpen()
+	if len(config.URLs) > 0 {
+		configMap["urls"] = config.URLs
+		configMap["active_url"] = activeURL
+		if _, lastFailover := state.endpoints.status(); !lastFailover.IsZero() {
+			configMap["last_failover"] = lastFailover.Local()
+		}
+	}
+	configMap["version_cache_ttl"] = config.versionCacheTTL().Seconds()
+	configMap["root_cert_cache_ttl"] = config.rootCertCacheTTL().Seconds()
+	if config.RootCertSHA256 != "" {
+		configMap["root_cert_sha256"] = config.RootCertSHA256
+	}
+
+	configMap["rotation_period"] = config.RotationPeriod.Seconds()
+	configMap["rotation_schedule"] = config.RotationSchedule
+	configMap["rotate_before_expiry"] = config.RotateBeforeExpiry.Seconds()
+	configMap["revoke_grace_period"] = config.RevokeGracePeriod.Seconds()
+	configMap["rotated_token_ttl"] = config.RotatedTokenTTL.Seconds()
+	configMap["token_marker"] = config.TokenMarker
+	configMap["reconcile_interval"] = config.ReconcileInterval.Seconds()
+	configMap["reconcile_revoke"] = config.ReconcileRevoke
+
+	status, err := b.fetchRotationStatus(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if !status.LastRotation.IsZero() {
+		configMap["last_rotation"] = status.LastRotation.Local()
+	}
+	if status.LastError != "" {
+		configMap["last_rotation_error"] = status.LastError
+		configMap["last_rotation_error_time"] = status.LastErrorTime.Local()
+	}
+	if !status.NextRotation.IsZero() {
+		configMap["next_rotation"] = status.NextRotation.Local()
+	}
+
 	if config.AccessToken == "" {
 		return &logical.Response{
 			Warnings: []string{"access_token is not set"},
@@ -283,25 +911,36 @@ func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, _ *f
 	accessTokenHash := sha256.Sum256([]byte(config.AccessToken))
 	configMap["access_token_sha256"] = fmt.Sprintf("%x", accessTokenHash[:])
 
-	go b.sendUsage(config.baseConfiguration, "pathConfigRead")
+	b.reportUsage(config.baseConfiguration, "pathConfigRead")
 
-	version, err := b.getVersion(config.baseConfiguration)
+	version, err := b.getVersion(ctx, config.baseConfiguration)
 	if err != nil {
 		logger.Error("failed to get system version", "err", err)
 		return nil, err
 	}
 	configMap["version"] = version
+	if entry, ok := state.versionCache.get(config.ArtifactoryURL); ok {
+		configMap["version_fetched_at"] = entry.FetchedAt.Local()
+	}
 
 	// Optionally include username_template
 	if len(config.UsernameTemplate) > 0 {
 		configMap["username_template"] = config.UsernameTemplate
 	}
 
+	var warnings []string
+
 	// Optionally include token info if it parses properly
-	token, err := b.getTokenInfo(config.baseConfiguration, config.AccessToken)
+	token, err := b.getTokenInfo(ctx, config.baseConfiguration, config.AccessToken)
 	if err != nil {
 		logger.Warn("Error parsing AccessToken", "err", err.Error())
+		if errors.Is(err, ErrRootCertMismatch) {
+			warnings = append(warnings, err.Error())
+		}
 	} else {
+		if token.Unverified {
+			warnings = append(warnings, unverifiedTokenWarning)
+		}
 		configMap["token_id"] = token.TokenID
 		configMap["username"] = token.Username
 		configMap["scope"] = token.Scope
@@ -312,7 +951,7 @@ func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, _ *f
 		}
 	}
 
-	supportForceRevocable, err := b.supportForceRevocable(config.baseConfiguration)
+	supportForceRevocable, err := b.supportForceRevocable(ctx, config.baseConfiguration)
 	if err != nil {
 		logger.Warn("failed to determine if force_revocable is supported. Set 'supportForceRevocable' to 'false'.", "err", err)
 		supportForceRevocable = false
@@ -323,6 +962,7 @@ func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, _ *f
 	}
 
 	return &logical.Response{
-		Data: configMap,
+		Data:     configMap,
+		Warnings: warnings,
 	}, nil
 }
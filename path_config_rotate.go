@@ -3,9 +3,12 @@ package artifactory
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
 )
 
 func (b *backend) pathConfigRotate() *framework.Path {
@@ -20,6 +23,18 @@ func (b *backend) pathConfigRotate() *framework.Path {
 				Type:        framework.TypeString,
 				Description: "Optional. Set Artifactory token description on new access token.",
 			},
+			"scope": {
+				Type:        framework.TypeString,
+				Description: "Optional. Scope of the new access token. Default to the scope of the current access token.",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional. Time the new access token is valid for. Only set if use_expiring_tokens is true and Artifactory supports it. Default to `rotated_token_ttl`.",
+			},
+			"revoke_grace_period": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional. Time to wait before revoking the current access token. Default to `revoke_grace_period`.",
+			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.UpdateOperation: &framework.PathOperation{
@@ -27,16 +42,26 @@ func (b *backend) pathConfigRotate() *framework.Path {
 				Summary:  "Rotate the Artifactory Access Token.",
 			},
 		},
-		HelpSynopsis:    `Rotate the Artifactory Access Token.`,
-		HelpDescription: `This will rotate the "access_token" used to access artifactory from this plugin. A new access token is created first then revokes the old access token.`,
+		HelpSynopsis: `Rotate the Artifactory Access Token.`,
+		HelpDescription: `
+This will rotate the "access_token" used to access artifactory from this plugin. A new access token is created first,
+checked against Artifactory, stored, and then the old access token is revoked.
+
+The old access token is revoked after "revoke_grace_period", so requests still using it, e.g. on performance standbys,
+can complete. Its revocation is kept in storage until then.
+`,
 	}
 }
 
 func (b *backend) pathConfigRotateWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	b.configMutex.Lock()
-	defer b.configMutex.Unlock()
+	name := connectionName(data)
+
+	// Hold the lock while rotating, so the token isn't rotated twice at once
+	lock := b.lockForKey(connectionStoragePath(name))
+	lock.Lock()
+	defer lock.Unlock()
 
-	config, err := b.fetchAdminConfiguration(ctx, req.Storage)
+	config, err := b.fetchConnectionConfiguration(ctx, req.Storage, name)
 	if err != nil {
 		return nil, err
 	}
@@ -49,12 +74,12 @@ func (b *backend) pathConfigRotateWrite(ctx context.Context, req *logical.Reques
 		return logical.ErrorResponse("missing access token"), errors.New("missing access token")
 	}
 
-	go b.sendUsage(config.baseConfiguration, "pathConfigRotateWrite")
+	b.reportUsage(config.baseConfiguration, "pathConfigRotateWrite")
 
 	oldAccessToken := config.AccessToken
 
 	// Parse Current Token (to get tokenID/scope)
-	token, err := b.getTokenInfo(config.baseConfiguration, oldAccessToken)
+	token, err := b.getTokenInfo(ctx, config.baseConfiguration, oldAccessToken)
 	if err != nil {
 		return logical.ErrorResponse("error parsing existing access token: " + err.Error()), err
 	}
@@ -70,8 +95,37 @@ func (b *backend) pathConfigRotateWrite(ctx context.Context, req *logical.Reques
 
 	// Create admin role for the new token
 	role := &artifactoryRole{
-		Username: token.Username,
-		Scope:    token.Scope,
+		Username:  token.Username,
+		Scope:     token.Scope,
+		ExpiresIn: config.RotatedTokenTTL,
+	}
+
+	if val, ok := data.GetOk("scope"); ok {
+		role.Scope = val.(string)
+	}
+
+	if val, ok := data.GetOk("ttl"); ok {
+		role.ExpiresIn = time.Duration(val.(int)) * time.Second
+	}
+
+	revokeGracePeriod := config.RevokeGracePeriod
+	if val, ok := data.GetOk("revoke_grace_period"); ok {
+		revokeGracePeriod = time.Duration(val.(int)) * time.Second
+	}
+
+	var warnings []string
+	if token.Unverified {
+		warnings = append(warnings, unverifiedTokenWarning)
+	}
+
+	if role.ExpiresIn > 0 {
+		supportForceRevocable, err := b.supportForceRevocable(ctx, config.baseConfiguration)
+		if err != nil {
+			return nil, err
+		}
+		if !config.UseExpiringTokens || !supportForceRevocable {
+			warnings = append(warnings, "ttl is ignored unless use_expiring_tokens is true and Artifactory is 7.50.3 or higher, the new access token does not expire")
+		}
 	}
 
 	// Check for new description
@@ -81,33 +135,137 @@ func (b *backend) pathConfigRotateWrite(ctx context.Context, req *logical.Reques
 		role.Description = "Rotated access token for artifactory-secrets plugin in Vault"
 	}
 
-	// Create a new token
-	resp, err := b.CreateToken(config.baseConfiguration, *role)
+	_, errResp, err := b.replaceStoredToken(ctx, req, config.baseConfiguration, walAccessToken{Connection: config.connection}, *role, func(resp *client.CreateTokenResponse) error {
+		// Set new token and set revoke_on_delete to true
+		config.AccessToken = resp.AccessToken
+		b.Logger().With("func", "pathConfigRotateWrite").Info("set config.RevokeOnDelete to 'true'")
+		config.RevokeOnDelete = true
+
+		// Save new config
+		entry, err := logical.StorageEntryJSON(connectionStoragePath(config.connection), config)
+		if err != nil {
+			return err
+		}
+
+		return req.Storage.Put(ctx, entry)
+	})
+	if errResp != nil || err != nil {
+		return errResp, err
+	}
+
+	logger := b.Logger().With("func", "pathConfigRotateWrite")
+
+	// Invalidate Old Token, before anything else can fail and leave it valid
+	warning, err := b.revokeReplacedToken(ctx, req.Storage, config.baseConfiguration, leaseSource{Connection: config.connection, TokenID: token.TokenID}, revokeGracePeriod)
 	if err != nil {
-		return logical.ErrorResponse("error creating new access token"), err
+		return logical.ErrorResponse("error revoking existing access token %s", token.TokenID), err
+	}
+	if warning != "" {
+		warnings = append(warnings, warning)
 	}
 
-	// Set new token and set revoke_on_delete to true
-	config.AccessToken = resp.AccessToken
-	b.Logger().With("func", "pathConfigRotateWrite").Info("set config.RevokeOnDelete to 'true'")
-	config.RevokeOnDelete = true
+	// The new token is stored, failing to schedule the next rotation doesn't fail this one
+	if err := b.scheduleRotation(ctx, req.Storage, config, true); err != nil {
+		logger.Warn("failed to schedule the next rotation", "err", err)
+		warnings = append(warnings, fmt.Sprintf("failed to schedule the next rotation: %s", err))
+	}
 
-	// Save new config
-	entry, err := logical.StorageEntryJSON(configAdminPath, config)
+	if len(warnings) > 0 {
+		return &logical.Response{Warnings: warnings}, nil
+	}
+
+	return nil, nil
+}
+
+// replaceStoredToken creates a token for role with config, to replace the access token of config, checks that
+// Artifactory accepts it and passes it to store. The token is written to the WAL until it is stored, so that it is
+// revoked if the request fails. A token that is not usable is revoked, and an error response is returned.
+func (b *backend) replaceStoredToken(ctx context.Context, req *logical.Request, config baseConfiguration, wal walAccessToken, role artifactoryRole, store func(resp *client.CreateTokenResponse) error) (*client.CreateTokenResponse, *logical.Response, error) {
+	logger := b.Logger().With("func", "replaceStoredToken")
+
+	// Don't create a new token unless it can be stored, Vault forwards the request to the active node of the
+	// primary cluster instead
+	if !b.WriteSafeReplicationState() {
+		return nil, nil, logical.ErrReadOnly
+	}
+
+	resp, err := b.CreateToken(ctx, config, role)
 	if err != nil {
-		return nil, err
+		return nil, logical.ErrorResponse("error creating new access token"), err
 	}
 
-	err = req.Storage.Put(ctx, entry)
+	wal.TokenID = resp.TokenId
+	wal.Rotated = true
+	walID, err := b.putAccessTokenWAL(ctx, req.Storage, config, wal)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	// Check the new token works before replacing the old one
+	newConfig := config
+	newConfig.AccessToken = resp.AccessToken
+	if err := b.verifyAccessToken(ctx, newConfig, resp.TokenId); err != nil {
+		if resp.TokenId != "" {
+			if revokeErr := b.RevokeToken(ctx, config, resp.TokenId); revokeErr != nil {
+				logger.Warn("error revoking unusable access token", "tokenId", resp.TokenId, "err", revokeErr)
+			} else if err := b.deleteAccessTokenWAL(ctx, req.Storage, walID); err != nil {
+				return nil, nil, err
+			}
+		}
+		return nil, logical.ErrorResponse("new access token is not usable, keeping the existing access token: %s", err), nil
+	}
+
+	if err := store(resp); err != nil {
+		return nil, nil, err
 	}
 
-	// Invalidate Old Token
-	err = b.RevokeToken(config.baseConfiguration, token.TokenID)
+	if err := b.deleteAccessTokenWAL(ctx, req.Storage, walID); err != nil {
+		return nil, nil, err
+	}
+
+	return resp, nil, nil
+}
+
+// revokeReplacedToken revokes the token of source, replaced by the access token of config, after gracePeriod. It is
+// queued if it can't be revoked now, and the returned warning says so: the token was replaced, this doesn't fail the
+// rotation.
+func (b *backend) revokeReplacedToken(ctx context.Context, storage logical.Storage, config baseConfiguration, source leaseSource, gracePeriod time.Duration) (string, error) {
+	if gracePeriod > 0 {
+		return "", b.deferRevocation(ctx, storage, source, time.Now().Add(gracePeriod))
+	}
+
+	err := b.RevokeToken(ctx, config, source.TokenID)
+	if err == nil {
+		return "", nil
+	}
+
+	b.Logger().With("func", "revokeReplacedToken").Warn("error revoking existing access token, queued to retry", "tokenId", source.TokenID, "err", err)
+
+	return fmt.Sprintf("existing access token %s could not be revoked and is queued to be revoked again: %s", source.TokenID, err), b.queueFailedRevocation(ctx, storage, source, err)
+}
+
+// verifyAccessToken checks that Artifactory accepts the access token of config, and that it is the token with the
+// given ID when tokenID is set
+func (b *backend) verifyAccessToken(ctx context.Context, config baseConfiguration, tokenID string) error {
+	// Artifactory older than 7.21.1 has no API to get a token by ID
+	if !config.UseNewAccessAPI {
+		b.Logger().With("func", "verifyAccessToken").Warn("Artifactory does not support checking the access token, skipping")
+		return nil
+	}
+
+	var details *client.TokenDetails
+	err := b.withFailover(ctx, config, true, func(c client.Client) (err error) {
+		// '/me' is special value to get info about token itself
+		details, err = c.GetTokenByID(ctx, "me")
+		return
+	})
 	if err != nil {
-		return logical.ErrorResponse("error revoking existing access token %s", token.TokenID), err
+		return err
 	}
 
-	return nil, nil
+	if tokenID != "" && details.TokenID != tokenID {
+		return fmt.Errorf("expected token %s, Artifactory returned token %s", tokenID, details.TokenID)
+	}
+
+	return nil
 }
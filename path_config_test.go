@@ -2,9 +2,19 @@ package artifactory
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
 	"net/http"
+	"net/http/httptest"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/jarcoal/httpmock"
@@ -279,3 +289,372 @@ func TestBackend_RevokeOnDelete(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Nil(t, resp)
 }
+
+func TestBackend_RetrySettings(t *testing.T) {
+	b, config := fakeConfiguredBackend(t, &fakeArtifactory{}, map[string]interface{}{
+		"access_token":              "test-access-token",
+		"url":                       "http://myserver.com:80",
+		"max_retries":               5,
+		"retry_wait_min":            2,
+		"retry_wait_max":            20,
+		"circuit_breaker_threshold": 0,
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+	})
+
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.EqualValues(t, 5, resp.Data["max_retries"])
+	assert.EqualValues(t, 2, resp.Data["retry_wait_min"])
+	assert.EqualValues(t, 20, resp.Data["retry_wait_max"])
+	assert.EqualValues(t, 0, resp.Data["circuit_breaker_threshold"])
+	assert.EqualValues(t, defaultCircuitBreakerTimeout.Seconds(), resp.Data["circuit_breaker_timeout"])
+	assert.EqualValues(t, false, resp.Data["circuit_breaker_open"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"retry_wait_min": 30,
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.True(t, resp.IsError())
+	assert.Contains(t, resp.Error().Error(), "retry_wait_min")
+}
+
+func TestBackend_VersionIsCached(t *testing.T) {
+	fake := &fakeArtifactory{}
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token":      "test-access-token",
+		"url":               "http://myserver.com:80",
+		"version_cache_ttl": 600,
+	})
+
+	assert.Equal(t, 1, fake.callCount("GetVersion"), "version is fetched when configuring the backend")
+
+	for i := 0; i < 2; i++ {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      configAdminPath,
+			Storage:   config.StorageView,
+		})
+
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+		assert.Equal(t, "7.33.8", resp.Data["version"])
+		assert.NotNil(t, resp.Data["version_fetched_at"])
+		assert.EqualValues(t, 600, resp.Data["version_cache_ttl"])
+	}
+	assert.Equal(t, 1, fake.callCount("GetVersion"), "version is served from the cache")
+
+	b.invalidate(context.Background(), configAdminPath)
+
+	_, err := b.getVersion(context.Background(), baseConfiguration{
+		AccessToken:    "test-access-token",
+		ArtifactoryURL: "http://myserver.com:80",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fake.callCount("GetVersion"), "version is fetched again after invalidation")
+}
+
+func TestBackend_RootCertIsCached(t *testing.T) {
+	fake := &fakeArtifactory{}
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": signedAdminAccessToken,
+		"url":          "http://myserver.com:80",
+	})
+
+	for i := 0; i < 2; i++ {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      configAdminPath,
+			Storage:   config.StorageView,
+		})
+
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+		assert.Equal(t, "1079485d-5a29-41cd-968e-e42fe924a521", resp.Data["token_id"])
+		assert.Empty(t, resp.Warnings)
+	}
+
+	assert.Equal(t, 1, fake.callCount("GetRootCert"))
+}
+
+func TestBackend_RootCertPinning(t *testing.T) {
+	b, config := fakeConfiguredBackend(t, &fakeArtifactory{}, map[string]interface{}{
+		"access_token": signedAdminAccessToken,
+		"url":          "http://myserver.com:80",
+	})
+
+	cert, _, err := b.getRootCert(context.Background(), baseConfiguration{
+		AccessToken:    signedAdminAccessToken,
+		ArtifactoryURL: "http://myserver.com:80",
+	})
+	assert.NoError(t, err)
+	fingerprint := rootCertFingerprint(cert)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"root_cert_sha256": "not-a-fingerprint",
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.IsError())
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"root_cert_sha256": strings.Repeat("ab", 32),
+		},
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.True(t, resp.IsError())
+	assert.Contains(t, resp.Error().Error(), "root_cert_sha256")
+
+	// openssl style fingerprints are accepted
+	var colonSeparated []string
+	for i := 0; i < len(fingerprint); i += 2 {
+		colonSeparated = append(colonSeparated, strings.ToUpper(fingerprint[i:i+2]))
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"root_cert_sha256": strings.Join(colonSeparated, ":"),
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, fingerprint, resp.Data["root_cert_sha256"])
+	assert.Equal(t, "1079485d-5a29-41cd-968e-e42fe924a521", resp.Data["token_id"])
+}
+
+func TestBackend_UnverifiedTokenWarning(t *testing.T) {
+	// Older Artifactory doesn't provide its root certificate
+	fake := &fakeArtifactory{version: "7.11.0"}
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": signedAdminAccessToken,
+		"url":          "http://myserver.com:80",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+	})
+
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.Equal(t, "1079485d-5a29-41cd-968e-e42fe924a521", resp.Data["token_id"])
+	assert.Contains(t, resp.Warnings, unverifiedTokenWarning)
+}
+
+func TestBackend_TLSSettingsValidation(t *testing.T) {
+	b, config := makeBackend(t)
+
+	for name, data := range map[string]map[string]interface{}{
+		"ca_cert":         {"ca_cert": "not a certificate"},
+		"client_key":      {"client_cert": "-----BEGIN CERTIFICATE-----"},
+		"client_cert":     {"client_cert": "not a certificate", "client_key": "not a key"},
+		"tls_min_version": {"tls_min_version": "ssl3"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data["url"] = "https://myserver.com"
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configAdminPath,
+				Storage:   config.StorageView,
+				Data:      data,
+			})
+
+			assert.NoError(t, err)
+			assert.NotNil(t, resp)
+			assert.True(t, resp.IsError())
+			assert.Contains(t, resp.Error().Error(), name)
+		})
+	}
+}
+
+func TestBackend_TLSClientCertificate(t *testing.T) {
+	clientCert, clientKey := generateTestCertificate(t)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/artifactory/api/system/version":
+			_, _ = w.Write([]byte(`{"version" : "7.33.8", "revision" : "73308900"}`))
+		case "/access/api/v1/cert/root":
+			_, _ = w.Write([]byte(rootCert))
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	b, config := makeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"access_token":    "test-access-token",
+			"url":             server.URL,
+			"ca_cert":         string(caCert),
+			"client_cert":     clientCert,
+			"client_key":      clientKey,
+			"tls_server_name": "example.com",
+			"tls_min_version": "tls12",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	// Simulate the configuration being written on another node
+	b.invalidate(context.Background(), configAdminPath)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+	})
+
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.Equal(t, "7.33.8", resp.Data["version"])
+	assert.Equal(t, string(caCert), resp.Data["ca_cert"])
+	assert.Equal(t, clientCert, resp.Data["client_cert"])
+	assert.Equal(t, "example.com", resp.Data["tls_server_name"])
+	assert.Equal(t, "tls12", resp.Data["tls_min_version"])
+	assert.NotContains(t, resp.Data, "client_key")
+}
+
+// generateTestCertificate returns a PEM encoded self-signed certificate and its private key
+func generateTestCertificate(t *testing.T) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vault"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	return string(certPEM), string(keyPEM)
+}
+
+// Unlike the fake client, httpmock sees the URLs and headers of the HTTP requests
+func TestBackend_ServiceURLsAndHeaders(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder(
+		http.MethodPost,
+		"http://myserver.com:80/jfrog/artifactory/api/system/usage",
+		httpmock.NewStringResponder(200, ""))
+	httpmock.RegisterResponder(
+		http.MethodGet,
+		"http://myserver.com:80/jfrog/artifactory/api/system/version",
+		func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "test-proxy-auth", req.Header.Get("X-Proxy-Auth"))
+			return httpmock.NewStringResponse(200, `{"version" : "7.33.8", "revision" : "73308900"}`), nil
+		})
+	httpmock.RegisterResponder(
+		http.MethodGet,
+		"http://access.myserver.com:80/access/api/v1/cert/root",
+		httpmock.NewStringResponder(200, rootCert))
+
+	b, config := configuredBackend(t, map[string]interface{}{
+		"access_token":    signedAdminAccessToken,
+		"url":             "http://myserver.com:80/jfrog/",
+		"access_url":      "http://access.myserver.com/access",
+		"request_headers": map[string]interface{}{"x-proxy-auth": "test-proxy-auth"},
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.Equal(t, "7.33.8", resp.Data["version"])
+	assert.Equal(t, "1079485d-5a29-41cd-968e-e42fe924a521", resp.Data["token_id"])
+	assert.Equal(t, "http://access.myserver.com/access", resp.Data["access_url"])
+	assert.Equal(t, []string{"X-Proxy-Auth"}, resp.Data["request_headers"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"request_headers": map[string]interface{}{"authorization": "Basic Zm9vOmJhcg=="},
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.IsError())
+	assert.Contains(t, resp.Error().Error(), "Authorization")
+
+	// Like url, pointing the Access API elsewhere requires setting access_token again
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"access_url": "http://elsewhere.example.com/access",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, resp.Warnings, "access_token is not set")
+}
@@ -20,7 +20,7 @@ func (b *backend) pathConfigUserToken() *framework.Path {
 		Fields: map[string]*framework.FieldSchema{
 			"username": {
 				Type:        framework.TypeString,
-				Description: `Optional. The username of the user. If not specified, the configuration will apply to *all* users.`,
+				Description: `Optional. The username of the user, other than "rotate", which is reserved. If not specified, the configuration will apply to *all* users.`,
 			},
 			"access_token": {
 				Type:        framework.TypeString,
@@ -66,6 +66,10 @@ func (b *backend) pathConfigUserToken() *framework.Path {
 				Type:        framework.TypeString,
 				Description: `Optional. Default token description to set in Artifactory for issued user access tokens.`,
 			},
+			"refresh_before_expiry": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional. Refresh the access token in the background, using the refresh token, when it expires within this duration. Set to 0 to only refresh it once it has expired and is used. Default to `0`.",
+			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.UpdateOperation: &framework.PathOperation{
@@ -77,8 +81,14 @@ func (b *backend) pathConfigUserToken() *framework.Path {
 				Summary:  "Examine the Artifactory secrets configuration for user token.",
 			},
 		},
-		HelpSynopsis:    `Configuration for issuing user tokens.`,
-		HelpDescription: `Configures default values for the user_token/<user name> path. The optional 'username' field allows the configuration to be set for each username.`,
+		HelpSynopsis: `Configuration for issuing user tokens.`,
+		HelpDescription: `
+Configures default values for the user_token/<user name> path. The optional 'username' field allows the configuration to be set for each username.
+
+The access token is refreshed with the refresh token once it has expired. An optional 'refresh_before_expiry' field refreshes it
+in the background before it expires, so the configuration keeps working when it is not used for longer than the lifetime of the
+refresh token. The last background refresh and its error, if it failed, are returned when reading the configuration.
+`,
 	}
 }
 
@@ -91,6 +101,12 @@ type userTokenConfiguration struct {
 	DefaultTTL            time.Duration `json:"default_ttl,omitempty"`
 	MaxTTL                time.Duration `json:"max_ttl,omitempty"`
 	DefaultDescription    string        `json:"default_description,omitempty"`
+	RefreshBeforeExpiry   time.Duration `json:"refresh_before_expiry,omitempty"`
+	LastRefresh           time.Time     `json:"last_refresh,omitempty"`
+	LastRefreshError      string        `json:"last_refresh_error,omitempty"`
+	LastRefreshErrorTime  time.Time     `json:"last_refresh_error_time,omitempty"`
+	// username the configuration is stored for, empty for the default configuration
+	username string
 }
 
 func (c *userTokenConfiguration) RefreshAccessToken(ctx context.Context, req *logical.Request, username string, b *backend, adminBaseConfig baseConfiguration) error {
@@ -100,7 +116,9 @@ func (c *userTokenConfiguration) RefreshAccessToken(ctx context.Context, req *lo
 		return fmt.Errorf("refresh_token is empty")
 	}
 
-	refreshResp, err := b.RefreshToken(adminBaseConfig, c.RefreshToken)
+	usedRefreshToken := c.RefreshToken
+
+	refreshResp, err := b.RefreshToken(ctx, adminBaseConfig, c.RefreshToken)
 	if err != nil {
 		return err
 	}
@@ -108,18 +126,44 @@ func (c *userTokenConfiguration) RefreshAccessToken(ctx context.Context, req *lo
 
 	c.AccessToken = refreshResp.AccessToken
 	c.RefreshToken = refreshResp.RefreshToken
+	c.LastRefresh = time.Now()
+	c.LastRefreshError = ""
+	c.LastRefreshErrorTime = time.Time{}
+
+	lock := b.lockForKey(userTokenConfigStoragePath(username))
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Never overwrite a newer token pair, e.g. written to the configuration while this one was refreshed
+	stored, err := b.fetchStoredUserTokenConfiguration(ctx, req.Storage, username)
+	if err != nil {
+		return err
+	}
+
+	if stored != nil && stored.RefreshToken != usedRefreshToken {
+		logger.Warn("user token configuration changed while refreshing the access token, not storing the refreshed tokens")
+		return nil
+	}
 
 	return b.storeUserTokenConfiguration(ctx, req, username, c)
 }
 
-// fetchAdminConfiguration will return nil,nil if there's no configuration
-func (b *backend) fetchUserTokenConfiguration(ctx context.Context, storage logical.Storage, username string) (*userTokenConfiguration, error) {
+// userTokenConfigStoragePath returns the storage path of the user token configuration of username, or of the
+// default user token configuration if username is empty
+func userTokenConfigStoragePath(username string) string {
 	// If username is not empty, then append to the path to fetch username specific configuration
 	path := configUserTokenPath
 	if len(username) > 0 && !strings.HasSuffix(path, username) {
 		path = fmt.Sprintf("%s/%s", path, username)
 	}
 
+	return path
+}
+
+// fetchAdminConfiguration will return nil,nil if there's no configuration
+func (b *backend) fetchUserTokenConfiguration(ctx context.Context, storage logical.Storage, username string) (*userTokenConfiguration, error) {
+	path := userTokenConfigStoragePath(username)
+
 	logger := b.Logger().With("func", "fetchUserTokenConfiguration")
 
 	// Read in the backend configuration
@@ -139,6 +183,7 @@ func (b *backend) fetchUserTokenConfiguration(ctx context.Context, storage logic
 		if e != nil {
 			entry = e
 		}
+		username = ""
 	}
 
 	if entry == nil {
@@ -156,17 +201,32 @@ func (b *backend) fetchUserTokenConfiguration(ctx context.Context, storage logic
 	if err := entry.DecodeJSON(&config); err != nil {
 		return nil, err
 	}
+	config.username = username
 
 	return &config, nil
 }
 
-func (b *backend) storeUserTokenConfiguration(ctx context.Context, req *logical.Request, username string, userTokenConfig *userTokenConfiguration) error {
-	// If username is not empty, then append to the path to fetch username specific configuration
-	path := configUserTokenPath
-	if len(username) > 0 && !strings.HasSuffix(path, username) {
-		path = fmt.Sprintf("%s/%s", path, username)
+// fetchStoredUserTokenConfiguration returns the user token configuration stored for username, or the default one if
+// username is empty. Unlike fetchUserTokenConfiguration, it doesn't fall back to a default, and returns nil,nil if
+// there's no configuration.
+func (b *backend) fetchStoredUserTokenConfiguration(ctx context.Context, storage logical.Storage, username string) (*userTokenConfiguration, error) {
+	entry, err := storage.Get(ctx, userTokenConfigStoragePath(username))
+	if err != nil || entry == nil {
+		return nil, err
 	}
 
+	var config userTokenConfiguration
+	if err := entry.DecodeJSON(&config); err != nil {
+		return nil, err
+	}
+	config.username = username
+
+	return &config, nil
+}
+
+func (b *backend) storeUserTokenConfiguration(ctx context.Context, req *logical.Request, username string, userTokenConfig *userTokenConfiguration) error {
+	path := userTokenConfigStoragePath(username)
+
 	entry, err := logical.StorageEntryJSON(path, userTokenConfig)
 	if err != nil {
 		return err
@@ -182,9 +242,6 @@ func (b *backend) storeUserTokenConfiguration(ctx context.Context, req *logical.
 }
 
 func (b *backend) pathConfigUserTokenUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	b.configMutex.Lock()
-	defer b.configMutex.Unlock()
-
 	adminConfig, err := b.fetchAdminConfiguration(ctx, req.Storage)
 	if err != nil {
 		return nil, err
@@ -199,6 +256,14 @@ func (b *backend) pathConfigUserTokenUpdate(ctx context.Context, req *logical.Re
 		username = val.(string)
 	}
 
+	if username == userTokenRotateName {
+		return logical.ErrorResponse("username %q is reserved for %s/%s", username, configUserTokenPath, userTokenRotateName), nil
+	}
+
+	lock := b.lockForKey(userTokenConfigStoragePath(username))
+	lock.Lock()
+	defer lock.Unlock()
+
 	userTokenConfig, err := b.fetchUserTokenConfiguration(ctx, req.Storage, username)
 	if err != nil {
 		return nil, err
@@ -216,6 +281,9 @@ func (b *backend) pathConfigUserTokenUpdate(ctx context.Context, req *logical.Re
 
 	if val, ok := data.GetOk("refresh_token"); ok {
 		userTokenConfig.RefreshToken = val.(string)
+		// A new refresh token may fix the last background refresh
+		userTokenConfig.LastRefreshError = ""
+		userTokenConfig.LastRefreshErrorTime = time.Time{}
 	}
 
 	if val, ok := data.GetOk("audience"); ok {
@@ -255,10 +323,18 @@ func (b *backend) pathConfigUserTokenUpdate(ctx context.Context, req *logical.Re
 		userTokenConfig.DefaultDescription = val.(string)
 	}
 
+	if val, ok := data.GetOk("refresh_before_expiry"); ok {
+		userTokenConfig.RefreshBeforeExpiry = time.Duration(val.(int)) * time.Second
+	}
+
 	if userTokenConfig.AccessToken != "" {
-		go b.sendUsage(userTokenConfig.baseConfiguration, "pathConfigUserTokenUpdate")
+		// Connect the same way as the admin configuration, without storing its connection settings here
+		connConfig := adminConfig.baseConfiguration
+		connConfig.AccessToken = userTokenConfig.AccessToken
 
-		userTokenConfig.UseNewAccessAPI = b.useNewAccessAPI(userTokenConfig.baseConfiguration)
+		b.reportUsage(connConfig, "pathConfigUserTokenUpdate")
+
+		userTokenConfig.UseNewAccessAPI = b.useNewAccessAPI(ctx, connConfig)
 	}
 
 	err = b.storeUserTokenConfiguration(ctx, req, username, userTokenConfig)
@@ -270,9 +346,6 @@ func (b *backend) pathConfigUserTokenUpdate(ctx context.Context, req *logical.Re
 }
 
 func (b *backend) pathConfigUserTokenRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	b.configMutex.Lock()
-	defer b.configMutex.Unlock()
-
 	baseConfig := baseConfiguration{}
 
 	adminConfig, err := b.fetchAdminConfiguration(ctx, req.Storage)
@@ -304,12 +377,15 @@ func (b *backend) pathConfigUserTokenRead(ctx context.Context, req *logical.Requ
 		return logical.ErrorResponse("missing access token"), errors.New("missing access token")
 	}
 
-	err = b.refreshExpiredAccessToken(ctx, req, &baseConfig, userTokenConfig, username)
+	err = b.refreshExpiredAccessToken(ctx, req, &baseConfig, userTokenConfig)
+	if errors.Is(err, logical.ErrReadOnly) {
+		return nil, err
+	}
 	if err != nil {
 		return logical.ErrorResponse("failed to refresh access token"), err
 	}
 
-	go b.sendUsage(baseConfig, "pathConfigUserTokenRead")
+	b.reportUsage(baseConfig, "pathConfigUserTokenRead")
 
 	accessTokenHash := sha256.Sum256([]byte(userTokenConfig.AccessToken))
 	refreshTokenHash := sha256.Sum256([]byte(userTokenConfig.RefreshToken))
@@ -325,15 +401,29 @@ func (b *backend) pathConfigUserTokenRead(ctx context.Context, req *logical.Requ
 		"default_ttl":             userTokenConfig.DefaultTTL.Seconds(),
 		"max_ttl":                 userTokenConfig.MaxTTL.Seconds(),
 		"default_description":     userTokenConfig.DefaultDescription,
+		"refresh_before_expiry":   userTokenConfig.RefreshBeforeExpiry.Seconds(),
+	}
+
+	if !userTokenConfig.LastRefresh.IsZero() {
+		configMap["last_refresh"] = userTokenConfig.LastRefresh.Local()
+	}
+	if userTokenConfig.LastRefreshError != "" {
+		configMap["last_refresh_error"] = userTokenConfig.LastRefreshError
+		configMap["last_refresh_error_time"] = userTokenConfig.LastRefreshErrorTime.Local()
 	}
 
 	// Optionally include token info if it parses properly
-	token, err := b.getTokenInfo(baseConfig, userTokenConfig.AccessToken)
+	token, err := b.getTokenInfo(ctx, baseConfig, userTokenConfig.AccessToken)
 	if err != nil {
 		return logical.ErrorResponse("failed to get token info"), err
 	}
 
+	var warnings []string
+
 	if token != nil {
+		if token.Unverified {
+			warnings = append(warnings, unverifiedTokenWarning)
+		}
 		configMap["token_id"] = token.TokenID
 		configMap["username"] = token.Username
 		configMap["scope"] = token.Scope
@@ -344,6 +434,7 @@ func (b *backend) pathConfigUserTokenRead(ctx context.Context, req *logical.Requ
 	}
 
 	return &logical.Response{
-		Data: configMap,
+		Data:     configMap,
+		Warnings: warnings,
 	}, nil
 }
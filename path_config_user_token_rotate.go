@@ -0,0 +1,200 @@
+package artifactory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+)
+
+// userTokenRotateName ends the rotation path of the default user token configuration, config/user_token/rotate, so it
+// can't be the username of a user token configuration
+const userTokenRotateName = "rotate"
+
+func (b *backend) pathConfigUserTokenRotate() *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("%s(?:/%s)?/%s", configUserTokenPath, framework.GenericNameWithAtRegex("username"), userTokenRotateName),
+		Fields: map[string]*framework.FieldSchema{
+			"username": {
+				Type:        framework.TypeString,
+				Description: `Optional. The username of the user. If not specified, the default user token configuration is rotated.`,
+			},
+			"description": {
+				Type:        framework.TypeString,
+				Description: "Optional. Set Artifactory token description on new access token.",
+			},
+			"scope": {
+				Type:        framework.TypeString,
+				Description: "Optional. Scope of the new access token. Default to the scope of the current access token.",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional. Time the new access token is valid for. Only set if use_expiring_tokens is true and Artifactory supports it. Default to `rotated_token_ttl` of config/admin.",
+			},
+			"revoke_grace_period": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional. Time to wait before revoking the current access token. Default to `revoke_grace_period` of config/admin.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigUserTokenRotateWrite,
+				Summary:  "Rotate the user access token and refresh token.",
+			},
+		},
+		HelpSynopsis: `Rotate the user access token and refresh token.`,
+		HelpDescription: `
+This will rotate the "access_token" and "refresh_token" stored with config/user_token, or config/user_token/<user name>.
+A new refreshable access token is created with the current access token, checked against Artifactory and stored, then
+the old access token is revoked.
+
+The old access token is revoked after "revoke_grace_period", so requests still using it can complete. Its revocation is
+kept in storage until then, or if it fails.
+`,
+	}
+}
+
+func (b *backend) pathConfigUserTokenRotateWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	logger := b.Logger().With("func", "pathConfigUserTokenRotateWrite")
+
+	adminConfig, err := b.fetchAdminConfiguration(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if adminConfig == nil {
+		return logical.ErrorResponse("backend not configured"), nil
+	}
+
+	username := ""
+	if val, ok := data.GetOk("username"); ok {
+		username = val.(string)
+	}
+
+	// Unlike issuing tokens, rotating doesn't fall back to the default configuration
+	userTokenConfig, err := b.fetchStoredUserTokenConfiguration(ctx, req.Storage, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if userTokenConfig == nil {
+		return logical.ErrorResponse("user token configuration not found"), nil
+	}
+
+	if userTokenConfig.AccessToken == "" {
+		return logical.ErrorResponse("missing access token"), errors.New("missing access token")
+	}
+
+	baseConfig := adminConfig.baseConfiguration
+	baseConfig.AccessToken = userTokenConfig.AccessToken
+
+	// Refreshing stores the configuration, so do it before taking the lock
+	err = b.refreshExpiredAccessToken(ctx, req, &baseConfig, userTokenConfig)
+	if errors.Is(err, logical.ErrReadOnly) {
+		return nil, err
+	}
+	if err != nil {
+		return logical.ErrorResponse("failed to refresh access token"), err
+	}
+
+	// Hold the lock while rotating, so the tokens aren't rotated twice at once
+	lock := b.lockForKey(userTokenConfigStoragePath(username))
+	lock.Lock()
+	defer lock.Unlock()
+
+	stored, err := b.fetchStoredUserTokenConfiguration(ctx, req.Storage, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored == nil || stored.AccessToken != baseConfig.AccessToken {
+		return logical.ErrorResponse("user token configuration changed while rotating, try again"), nil
+	}
+	userTokenConfig = stored
+
+	b.reportUsage(baseConfig, "pathConfigUserTokenRotateWrite")
+
+	// Parse Current Token (to get tokenID/scope)
+	token, err := b.getTokenInfo(ctx, baseConfig, baseConfig.AccessToken)
+	if err != nil {
+		return logical.ErrorResponse("error parsing existing access token: " + err.Error()), err
+	}
+
+	role := artifactoryRole{
+		GrantType:   grantTypeClientCredentials,
+		Username:    token.Username,
+		Scope:       token.Scope,
+		Audience:    userTokenConfig.Audience,
+		Refreshable: true,
+		Description: "Rotated user access token for artifactory-secrets plugin in Vault",
+		ExpiresIn:   adminConfig.RotatedTokenTTL,
+	}
+
+	if val, ok := data.GetOk("scope"); ok {
+		role.Scope = val.(string)
+	}
+
+	if val, ok := data.GetOk("description"); ok {
+		role.Description = val.(string)
+	}
+
+	if val, ok := data.GetOk("ttl"); ok {
+		role.ExpiresIn = time.Duration(val.(int)) * time.Second
+	}
+
+	revokeGracePeriod := adminConfig.RevokeGracePeriod
+	if val, ok := data.GetOk("revoke_grace_period"); ok {
+		revokeGracePeriod = time.Duration(val.(int)) * time.Second
+	}
+
+	var warnings []string
+	if token.Unverified {
+		warnings = append(warnings, unverifiedTokenWarning)
+	}
+
+	if role.ExpiresIn > 0 {
+		supportForceRevocable, err := b.supportForceRevocable(ctx, baseConfig)
+		if err != nil {
+			return nil, err
+		}
+		if !baseConfig.UseExpiringTokens || !supportForceRevocable {
+			warnings = append(warnings, "ttl is ignored unless use_expiring_tokens is true and Artifactory is 7.50.3 or higher, the new access token does not expire")
+		}
+	}
+
+	resp, errResp, err := b.replaceStoredToken(ctx, req, baseConfig, walAccessToken{UserToken: true, Username: username}, role, func(resp *client.CreateTokenResponse) error {
+		if resp.RefreshToken == "" {
+			logger.Warn("Artifactory did not return a refresh token", "tokenId", resp.TokenId)
+		}
+
+		userTokenConfig.AccessToken = resp.AccessToken
+		userTokenConfig.RefreshToken = resp.RefreshToken
+
+		return b.storeUserTokenConfiguration(ctx, req, username, userTokenConfig)
+	})
+	if errResp != nil || err != nil {
+		return errResp, err
+	}
+
+	// Invalidate Old Token, with the new one
+	newConfig := baseConfig
+	newConfig.AccessToken = resp.AccessToken
+	oldToken := leaseSource{Source: leaseSourceUserToken, UserTokenConfig: username, TokenID: token.TokenID}
+	warning, err := b.revokeReplacedToken(ctx, req.Storage, newConfig, oldToken, revokeGracePeriod)
+	if err != nil {
+		return logical.ErrorResponse("error revoking existing access token %s", token.TokenID), err
+	}
+	if warning != "" {
+		warnings = append(warnings, warning)
+	}
+
+	if len(warnings) > 0 {
+		return &logical.Response{Warnings: warnings}, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,173 @@
+package artifactory
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_RotateUserToken(t *testing.T) {
+	var createRequest client.CreateTokenRequest
+	fake := &fakeArtifactory{
+		createToken: func(_ context.Context, _ client.Config, request client.CreateTokenRequest) (*client.CreateTokenResponse, error) {
+			createRequest = request
+			resp := createdToken()
+			resp.RefreshToken = "test-refresh-token"
+			return resp, nil
+		},
+	}
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-admin-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/user_token/admin",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"access_token":  signedAdminAccessToken,
+			"refresh_token": "old-refresh-token",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/user_token/admin/rotate",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"description": "rotated for compliance",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	assert.Equal(t, "admin", createRequest.Username)
+	assert.Equal(t, "applied-permissions/admin", createRequest.Scope)
+	assert.Equal(t, "rotated for compliance", createRequest.Description)
+	assert.True(t, createRequest.Refreshable)
+
+	assert.Equal(t, 1, fake.callCount("RevokeToken 1079485d-5a29-41cd-968e-e42fe924a521"))
+
+	userTokenConfig, err := b.fetchUserTokenConfiguration(context.Background(), config.StorageView, "admin")
+	assert.NoError(t, err)
+	assert.NotEqual(t, signedAdminAccessToken, userTokenConfig.AccessToken)
+	assert.Equal(t, "test-refresh-token", userTokenConfig.RefreshToken)
+
+	// The default configuration is not set, and the configuration of the user is not used in its place
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/user_token/rotate",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.IsError())
+	assert.Contains(t, resp.Error().Error(), "user token configuration not found")
+}
+
+func TestBackend_RotateUserTokenQueuesOldTokenIfRevocationFails(t *testing.T) {
+	fake := &fakeArtifactory{
+		revokeToken: func(_ context.Context, _ client.Config, _ string) error {
+			return &client.StatusError{StatusCode: http.StatusForbidden, Err: errors.New("forbidden")}
+		},
+	}
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-admin-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/user_token/admin",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"access_token":  signedAdminAccessToken,
+			"refresh_token": "old-refresh-token",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/user_token/admin/rotate",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.False(t, resp.IsError())
+	assert.Len(t, resp.Warnings, 1)
+
+	info := readPendingRevocations(t, b, config.StorageView)["1079485d-5a29-41cd-968e-e42fe924a521"].(map[string]interface{})
+	assert.Equal(t, "admin", info["username"])
+	assert.Equal(t, 1, info["attempts"])
+	assert.Contains(t, info["last_error"], "forbidden")
+
+	userTokenConfig, err := b.fetchUserTokenConfiguration(context.Background(), config.StorageView, "admin")
+	assert.NoError(t, err)
+	assert.NotEqual(t, signedAdminAccessToken, userTokenConfig.AccessToken)
+}
+
+func TestBackend_RotateUserTokenWithRevokeGracePeriod(t *testing.T) {
+	fake := &fakeArtifactory{}
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-admin-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/user_token",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"access_token": signedAdminAccessToken,
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/user_token/rotate",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"revoke_grace_period": "1h",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+	assert.Equal(t, 0, fake.callCount("RevokeToken 1079485d-5a29-41cd-968e-e42fe924a521"))
+
+	info := readPendingRevocations(t, b, config.StorageView)["1079485d-5a29-41cd-968e-e42fe924a521"].(map[string]interface{})
+	assert.Equal(t, "", info["username"])
+	assert.WithinDuration(t, time.Now().Add(time.Hour), info["revoke_after"].(time.Time), time.Minute)
+}
+
+func TestBackend_ConfigUserTokenRejectsRotateUsername(t *testing.T) {
+	b, config := fakeConfiguredBackend(t, &fakeArtifactory{}, map[string]interface{}{
+		"access_token": "test-admin-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp, err := b.pathConfigUserTokenUpdate(context.Background(), &logical.Request{Storage: config.StorageView}, &framework.FieldData{
+		Raw:    map[string]interface{}{"username": userTokenRotateName},
+		Schema: b.pathConfigUserToken().Fields,
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.IsError())
+
+	stored, err := b.fetchStoredUserTokenConfiguration(context.Background(), config.StorageView, userTokenRotateName)
+	assert.NoError(t, err)
+	assert.Nil(t, stored)
+}
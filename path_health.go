@@ -0,0 +1,231 @@
+package artifactory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+)
+
+const healthPath = "health"
+
+const (
+	defaultExpiryWindow  = 7 * 24 * time.Hour
+	maxClockSkewExpected = 1 * time.Minute
+)
+
+// Token status reported by the health path
+const (
+	tokenStatusValid      = "valid"
+	tokenStatusNearExpiry = "near_expiry"
+	tokenStatusExpired    = "expired"
+	tokenStatusInvalid    = "invalid"
+	tokenStatusNotSet     = "not_set"
+	// tokenStatusUnknown is reported when Artifactory could not be asked, e.g. it is unreachable
+	tokenStatusUnknown = "unknown"
+)
+
+// capabilityVersions are the Artifactory versions that introduced the features used by this backend
+var capabilityVersions = map[string]string{
+	"root_certificate": "7.12.0",
+	"new_access_api":   "7.21.1",
+	"reference_token":  "7.38.10",
+	"force_revocable":  "7.50.3",
+}
+
+func (b *backend) pathHealth() *framework.Path {
+	return &framework.Path{
+		Pattern: healthPath,
+		Fields: map[string]*framework.FieldSchema{
+			"connection": {
+				Type:        framework.TypeString,
+				Description: "Optional. Name of the connection to check. Defaults to the connection configured with config/admin.",
+			},
+			"expiry_window": {
+				Type:        framework.TypeDurationSecond,
+				Default:     int(defaultExpiryWindow.Seconds()),
+				Description: "Optional. Report the access token as near expiry when it expires within this duration. Default to `168h`.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathHealthRead,
+				Summary:  "Check the connection to Artifactory.",
+			},
+		},
+		HelpSynopsis: `Check the connection to Artifactory.`,
+		HelpDescription: `
+Reports whether Artifactory is reachable and how long it took to answer, its version and the capabilities derived from it,
+whether the access token is valid, expired or near expiry, and the clock skew between Vault and Artifactory.
+
+Failed checks are reported in the response rather than as errors, so this path can be used for monitoring. "healthy" is
+true when Artifactory is reachable and the access token is valid. Artifactory older than 7.21.1 can't check the access
+token, its status is unknown and "healthy" only depends on whether Artifactory is reachable.
+`,
+	}
+}
+
+func (b *backend) pathHealthRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	logger := b.Logger().With("func", "pathHealthRead")
+
+	name := data.Get("connection").(string)
+	expiryWindow := time.Duration(data.Get("expiry_window").(int)) * time.Second
+
+	health := map[string]interface{}{
+		"connection": name,
+		"configured": false,
+		"healthy":    false,
+	}
+
+	config, err := b.fetchConnectionConfiguration(ctx, req.Storage, name)
+	if err != nil {
+		logger.Warn("failed to fetch configuration", "err", err)
+		health["error"] = err.Error()
+		return &logical.Response{Data: health}, nil
+	}
+
+	if config == nil {
+		return &logical.Response{Data: health}, nil
+	}
+
+	health["configured"] = true
+	health["url"] = config.ArtifactoryURL
+
+	state := b.connection(name)
+	activeURL, breaker := state.endpoints.current(config.ArtifactoryURL)
+	health["active_url"] = activeURL
+	health["circuit_breaker_open"] = breaker.IsOpen()
+
+	if config.AccessToken == "" {
+		health["token_status"] = tokenStatusNotSet
+		return &logical.Response{Data: health}, nil
+	}
+
+	var warnings []string
+
+	// Always ask Artifactory, the cached version doesn't tell if it is reachable
+	var systemVersion *client.SystemVersion
+	start := time.Now()
+	err = b.withFailover(ctx, config.baseConfiguration, true, func(c client.Client) (err error) {
+		systemVersion, err = c.GetVersion(ctx)
+		return
+	})
+	health["latency_ms"] = time.Since(start).Milliseconds()
+
+	reachable := err == nil
+	health["reachable"] = reachable
+
+	if err != nil {
+		logger.Warn("Artifactory is not reachable", "err", err)
+		health["version_error"] = err.Error()
+	} else {
+		state.versionCache.put(config.ArtifactoryURL, systemVersion.Version)
+		health["version"] = systemVersion.Version
+		health["capabilities"] = versionCapabilities(systemVersion.Version)
+
+		if !systemVersion.Date.IsZero() {
+			skew := systemVersion.Date.Sub(time.Now()).Truncate(time.Second)
+			health["clock_skew_seconds"] = skew.Seconds()
+			if skew > maxClockSkewExpected || skew < -maxClockSkewExpected {
+				warnings = append(warnings, fmt.Sprintf("clock skew between Vault and Artifactory is %s", skew))
+			}
+		}
+	}
+
+	// Like the version, the active URL may have changed
+	health["active_url"], _ = state.endpoints.current(config.ArtifactoryURL)
+
+	// The version just fetched may be newer than the one the connection was configured with
+	newAccessAPI := config.UseNewAccessAPI
+	if capabilities, ok := health["capabilities"].(map[string]bool); ok {
+		if capable, ok := capabilities["new_access_api"]; ok {
+			newAccessAPI = capable
+		}
+	}
+
+	// Artifactory older than 7.21.1 has no API to get the access token, so it can't be checked
+	if !newAccessAPI {
+		health["token_status"] = tokenStatusUnknown
+		health["healthy"] = reachable
+		warnings = append(warnings, "Artifactory is older than 7.21.1, the access token was not checked")
+
+		return &logical.Response{
+			Data:     health,
+			Warnings: warnings,
+		}, nil
+	}
+
+	tokenStatus, details, err := b.adminTokenStatus(ctx, config.baseConfiguration, expiryWindow)
+	health["token_status"] = tokenStatus
+	if err != nil {
+		health["token_error"] = err.Error()
+	}
+	if details != nil {
+		health["token_id"] = details.TokenID
+		if details.Expiry > 0 {
+			health["token_expires"] = time.Unix(details.Expiry, 0).Local()
+		}
+	}
+
+	health["healthy"] = reachable && (tokenStatus == tokenStatusValid || tokenStatus == tokenStatusNearExpiry)
+
+	return &logical.Response{
+		Data:     health,
+		Warnings: warnings,
+	}, nil
+}
+
+// adminTokenStatus asks Artifactory about the access token of config, and reports whether it is valid, expired or
+// expires within expiryWindow. The token is only reported invalid when Artifactory rejects it.
+func (b *backend) adminTokenStatus(ctx context.Context, config baseConfiguration, expiryWindow time.Duration) (string, *client.TokenDetails, error) {
+	var details *client.TokenDetails
+	err := b.withFailover(ctx, config, true, func(c client.Client) (err error) {
+		// '/me' is special value to get info about token itself
+		details, err = c.GetTokenByID(ctx, "me")
+		return
+	})
+
+	var expiredErr *client.TokenExpiredError
+	var statusErr *client.StatusError
+	switch {
+	case errors.As(err, &expiredErr):
+		return tokenStatusExpired, nil, err
+	case client.IsUnavailable(err), err != nil && !errors.As(err, &statusErr):
+		return tokenStatusUnknown, nil, err
+	case err != nil:
+		return tokenStatusInvalid, nil, err
+	}
+
+	if details.Expiry > 0 {
+		expires := time.Unix(details.Expiry, 0)
+		if !time.Now().Before(expires) {
+			return tokenStatusExpired, details, nil
+		}
+		if time.Until(expires) < expiryWindow {
+			return tokenStatusNearExpiry, details, nil
+		}
+	}
+
+	return tokenStatusValid, details, nil
+}
+
+// versionCapabilities reports which features used by this backend the Artifactory version supports
+func versionCapabilities(artifactoryVersion string) map[string]bool {
+	capabilities := map[string]bool{}
+
+	v, err := version.NewVersion(artifactoryVersion)
+	if err != nil {
+		return capabilities
+	}
+
+	for capability, since := range capabilityVersions {
+		capabilities[capability] = v.GreaterThanOrEqual(version.Must(version.NewVersion(since)))
+	}
+
+	return capabilities
+}
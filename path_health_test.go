@@ -0,0 +1,141 @@
+package artifactory
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func readHealth(t *testing.T, b *backend, config *logical.BackendConfig) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      healthPath,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.False(t, resp.IsError())
+
+	return resp
+}
+
+func TestBackend_HealthUnconfigured(t *testing.T) {
+	b, config := makeBackend(t)
+
+	resp := readHealth(t, b, config)
+	assert.Equal(t, false, resp.Data["configured"])
+	assert.Equal(t, false, resp.Data["healthy"])
+}
+
+func TestBackend_Health(t *testing.T) {
+	expiry := time.Now().Add(24 * time.Hour).Unix()
+	fake := &fakeArtifactory{
+		getVersion: func(_ context.Context, _ client.Config) (*client.SystemVersion, error) {
+			return &client.SystemVersion{Version: "7.33.8", Date: time.Now().Add(5 * time.Minute)}, nil
+		},
+		getTokenByID: func(_ context.Context, _ client.Config, _ string) (*client.TokenDetails, error) {
+			return &client.TokenDetails{TokenID: "test-token-id", Expiry: expiry}, nil
+		},
+	}
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-access-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp := readHealth(t, b, config)
+	assert.Equal(t, true, resp.Data["configured"])
+	assert.Equal(t, true, resp.Data["reachable"])
+	assert.Equal(t, true, resp.Data["healthy"])
+	assert.Equal(t, "7.33.8", resp.Data["version"])
+	assert.Equal(t, map[string]bool{
+		"root_certificate": true,
+		"new_access_api":   true,
+		"reference_token":  false,
+		"force_revocable":  false,
+	}, resp.Data["capabilities"])
+	assert.Equal(t, tokenStatusNearExpiry, resp.Data["token_status"])
+	assert.Equal(t, "test-token-id", resp.Data["token_id"])
+	assert.InDelta(t, 300, resp.Data["clock_skew_seconds"], 2)
+	assert.Len(t, resp.Warnings, 1)
+	assert.Contains(t, resp.Warnings[0], "clock skew")
+}
+
+func TestBackend_HealthArtifactoryUnavailable(t *testing.T) {
+	fake := &fakeArtifactory{}
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-access-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	unavailable := &client.StatusError{StatusCode: http.StatusServiceUnavailable, Err: errors.New("service unavailable")}
+	fake.getVersion = func(_ context.Context, _ client.Config) (*client.SystemVersion, error) {
+		return nil, unavailable
+	}
+	fake.getTokenByID = func(_ context.Context, _ client.Config, _ string) (*client.TokenDetails, error) {
+		return nil, unavailable
+	}
+
+	resp := readHealth(t, b, config)
+	assert.Equal(t, true, resp.Data["configured"])
+	assert.Equal(t, false, resp.Data["reachable"])
+	assert.Equal(t, false, resp.Data["healthy"])
+	assert.Contains(t, resp.Data, "version_error")
+	assert.Equal(t, tokenStatusUnknown, resp.Data["token_status"], "not invalid while Artifactory can't tell")
+}
+
+func TestBackend_HealthInvalidToken(t *testing.T) {
+	fake := &fakeArtifactory{
+		getTokenByID: func(_ context.Context, _ client.Config, _ string) (*client.TokenDetails, error) {
+			return nil, &client.StatusError{StatusCode: http.StatusForbidden, Err: errors.New("forbidden")}
+		},
+	}
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-access-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp := readHealth(t, b, config)
+	assert.Equal(t, true, resp.Data["reachable"])
+	assert.Equal(t, false, resp.Data["healthy"])
+	assert.Equal(t, tokenStatusInvalid, resp.Data["token_status"])
+}
+
+func TestBackend_HealthExpiredToken(t *testing.T) {
+	fake := &fakeArtifactory{
+		getTokenByID: func(_ context.Context, _ client.Config, _ string) (*client.TokenDetails, error) {
+			return nil, &client.TokenExpiredError{}
+		},
+	}
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-access-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp := readHealth(t, b, config)
+	assert.Equal(t, true, resp.Data["reachable"])
+	assert.Equal(t, false, resp.Data["healthy"])
+	assert.Equal(t, tokenStatusExpired, resp.Data["token_status"])
+}
+
+// Test that the access token is not checked with Artifactory older than 7.21.1, which has no API for it.
+func TestBackend_HealthOldArtifactory(t *testing.T) {
+	fake := &fakeArtifactory{version: "7.19.10"}
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-access-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp := readHealth(t, b, config)
+	assert.Equal(t, true, resp.Data["reachable"])
+	assert.Equal(t, true, resp.Data["healthy"])
+	assert.Equal(t, tokenStatusUnknown, resp.Data["token_status"])
+	assert.Len(t, resp.Warnings, 1)
+	assert.Contains(t, resp.Warnings[0], "older than 7.21.1")
+	assert.Equal(t, 0, fake.callCount("GetTokenByID me"))
+}
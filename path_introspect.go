@@ -0,0 +1,211 @@
+package artifactory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+)
+
+const introspectPath = "introspect"
+
+func (b *backend) pathIntrospect() *framework.Path {
+	return &framework.Path{
+		Pattern: introspectPath,
+		Fields: map[string]*framework.FieldSchema{
+			"token": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: "Artifactory access token to introspect.",
+			},
+			"connection": {
+				Type:        framework.TypeString,
+				Description: "Optional. Name of the connection to the Artifactory that issued the token. Defaults to the connection configured with config/admin.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathIntrospectUpdate,
+				Summary:  "Introspect an Artifactory access token.",
+			},
+		},
+		HelpSynopsis: `Introspect an Artifactory access token.`,
+		HelpDescription: `
+Decodes an Artifactory access token and reports whether its signature is valid, checked against the root certificate of
+Artifactory, its issuer, subject, scope, audience, expiry and whether it is revocable. It also reports whether the token
+was issued by this mount, from the ledger of issued tokens, and whether Artifactory still considers it active, with the
+Get Token by ID API.
+
+Failed checks are reported as warnings rather than errors. Reference tokens are not JWTs and can't be introspected.
+`,
+	}
+}
+
+func (b *backend) pathIntrospectUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	logger := b.Logger().With("func", "pathIntrospectUpdate")
+
+	config, err := b.fetchConnectionConfiguration(ctx, req.Storage, data.Get("connection").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return logical.ErrorResponse("backend not configured"), nil
+	}
+
+	token := data.Get("token").(string)
+	if token == "" {
+		return logical.ErrorResponse("missing token"), nil
+	}
+
+	// Decode the claims first, so that they are reported even if the signature or the expiry is invalid
+	unverified, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return logical.ErrorResponse("token is not a JWT: %s", err), nil
+	}
+
+	claims, ok := unverified.Claims.(jwt.MapClaims)
+	if !ok {
+		return logical.ErrorResponse("error parsing claims in token"), nil
+	}
+
+	tokenID, _ := claims["jti"].(string)
+	subject, _ := claims["sub"].(string)
+	issuer, _ := claims["iss"].(string)
+	scope, _ := claims["scp"].(string)
+
+	introspection := map[string]interface{}{
+		"token_id":  tokenID,
+		"issuer":    issuer,
+		"subject":   subject,
+		"scope":     scope,
+		"audience":  audienceClaim(claims),
+		"revocable": revocableClaim(claims),
+	}
+
+	var warnings []string
+
+	signatureValid, err := b.verifyTokenSignature(ctx, config.baseConfiguration, token)
+	if err != nil {
+		logger.Warn("failed to verify token signature", "err", err)
+		warnings = append(warnings, fmt.Sprintf("the token signature could not be verified: %s", err))
+	}
+	introspection["signature_valid"] = signatureValid
+
+	if sub := strings.Split(subject, "/"); len(sub) > 2 && sub[1] == "users" {
+		introspection["username"] = strings.Join(sub[2:], "/") // 3rd+ elements (incase username has / in it)
+	}
+
+	if iat, err := unixClaim(claims, "iat"); err == nil && iat > 0 {
+		introspection["issued_at"] = time.Unix(iat, 0)
+	}
+
+	expired := false
+	if exp, err := unixClaim(claims, "exp"); err == nil && exp > 0 {
+		introspection["expires_at"] = time.Unix(exp, 0)
+		expired = time.Unix(exp, 0).Before(time.Now())
+	}
+	introspection["expired"] = expired
+
+	issued, err := fetchIssuedToken(ctx, req.Storage, tokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	introspection["issued_by_mount"] = issued != nil
+	if issued != nil {
+		introspection["issued_token"] = issued.responseData()
+	}
+
+	switch {
+	case tokenID == "":
+		warnings = append(warnings, "the token has no ID, whether it is active was not checked")
+	case !config.UseNewAccessAPI:
+		warnings = append(warnings, "Artifactory is older than 7.21.1, whether the token is active was not checked")
+	case !introspection["revocable"].(bool):
+		warnings = append(warnings, "the token is not revocable, Artifactory doesn't keep track of it")
+	default:
+		_, err := b.GetTokenByID(ctx, config.baseConfiguration, tokenID)
+		switch {
+		case err == nil:
+			introspection["active"] = true
+		case client.IsNotFound(err):
+			introspection["active"] = false
+		default:
+			logger.Warn("failed to get token by ID", "tokenId", tokenID, "err", err)
+			warnings = append(warnings, fmt.Sprintf("whether the token is active could not be checked: %s", err))
+		}
+	}
+
+	return &logical.Response{
+		Data:     introspection,
+		Warnings: warnings,
+	}, nil
+}
+
+// verifyTokenSignature returns whether token is signed by the root certificate of Artifactory, regardless of its expiry
+func (b *backend) verifyTokenSignature(ctx context.Context, config baseConfiguration, token string) (bool, error) {
+	_, verified, err := b.parseJWT(ctx, config, token)
+	if err == nil {
+		if !verified {
+			return false, errors.New(unverifiedTokenWarning)
+		}
+		return true, nil
+	}
+
+	var validationErr *jwt.ValidationError
+	if !errors.As(err, &validationErr) {
+		return false, err
+	}
+
+	// The claims are validated too, the signature is only invalid if it is reported
+	invalid := jwt.ValidationErrorMalformed | jwt.ValidationErrorUnverifiable | jwt.ValidationErrorSignatureInvalid
+	return validationErr.Errors&invalid == 0, nil
+}
+
+// audienceClaim returns the aud claim of a token, which is either a string or a list of strings
+func audienceClaim(claims jwt.MapClaims) []string {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		audience := make([]string, 0, len(aud))
+		for _, value := range aud {
+			if s, ok := value.(string); ok {
+				audience = append(audience, s)
+			}
+		}
+		return audience
+	}
+
+	return []string{}
+}
+
+// revocableClaim returns whether a token is revocable, from its ext claim, e.g. {"revocable":"true"}
+func revocableClaim(claims jwt.MapClaims) bool {
+	ext, ok := claims["ext"].(string)
+	if !ok {
+		return false
+	}
+
+	var extensions map[string]interface{}
+	if err := json.Unmarshal([]byte(ext), &extensions); err != nil {
+		return false
+	}
+
+	switch revocable := extensions["revocable"].(type) {
+	case string:
+		return revocable == "true"
+	case bool:
+		return revocable
+	}
+
+	return false
+}
@@ -0,0 +1,100 @@
+package artifactory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_Introspect(t *testing.T) {
+	tokenID := "59e39159-19eb-463d-953d-1d6baf567db6"
+	fake := &fakeArtifactory{}
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-admin-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	introspect := func(token string) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "introspect",
+			Storage:   config.StorageView,
+			Data:      map[string]interface{}{"token": token},
+		})
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+		return resp
+	}
+
+	// Signed by the root certificate, not revocable
+	resp := introspect(signedAdminAccessToken)
+	assert.False(t, resp.IsError())
+	assert.Equal(t, true, resp.Data["signature_valid"])
+	assert.Equal(t, "1079485d-5a29-41cd-968e-e42fe924a521", resp.Data["token_id"])
+	assert.Equal(t, "jfsupport@01k4kx07z3qa5fehdr86n2ckw9", resp.Data["issuer"])
+	assert.Equal(t, "admin", resp.Data["username"])
+	assert.Equal(t, "applied-permissions/admin", resp.Data["scope"])
+	assert.Equal(t, []string{"*@*"}, resp.Data["audience"])
+	assert.Equal(t, false, resp.Data["revocable"])
+	assert.Equal(t, false, resp.Data["expired"])
+	assert.Equal(t, false, resp.Data["issued_by_mount"])
+	assert.NotContains(t, resp.Data, "active")
+	assert.Len(t, resp.Warnings, 1)
+
+	// Issued by the mount, signed by another key and expired
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/test-role",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"username": "test-username",
+			"scope":    "test-scope",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "token/test-role",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+
+	var created map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(jwtAccessToken), &created))
+	accessToken := created["access_token"].(string)
+
+	fake.getTokenByID = func(_ context.Context, _ client.Config, id string) (*client.TokenDetails, error) {
+		return &client.TokenDetails{TokenID: id, Subject: "jfac@01g5hek6kb29520rbz71v91cw9/users/admin"}, nil
+	}
+
+	resp = introspect(accessToken)
+	assert.False(t, resp.IsError())
+	assert.Equal(t, false, resp.Data["signature_valid"])
+	assert.Equal(t, tokenID, resp.Data["token_id"])
+	assert.Equal(t, true, resp.Data["revocable"])
+	assert.Equal(t, true, resp.Data["expired"])
+	assert.Equal(t, true, resp.Data["issued_by_mount"])
+	assert.Equal(t, "test-role", resp.Data["issued_token"].(map[string]interface{})["role"])
+	assert.Equal(t, true, resp.Data["active"])
+	assert.Empty(t, resp.Warnings)
+
+	fake.getTokenByID = func(_ context.Context, _ client.Config, _ string) (*client.TokenDetails, error) {
+		return nil, &client.StatusError{StatusCode: http.StatusNotFound, Err: errors.New("token not found")}
+	}
+
+	resp = introspect(accessToken)
+	assert.Equal(t, false, resp.Data["active"])
+
+	resp = introspect("not-a-jwt")
+	assert.True(t, resp.IsError())
+}
@@ -0,0 +1,185 @@
+package artifactory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const revokePath = "revoke/"
+
+func (b *backend) pathRevokeRole() *framework.Path {
+	return &framework.Path{
+		Pattern: revokePath + "role/" + framework.GenericNameWithAtRegex("role"),
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: "Name of the role whose tokens are revoked.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRevokeRoleWrite,
+				Summary:  "Revoke all the tokens issued for a role.",
+			},
+		},
+		HelpSynopsis:    `Revoke all the tokens issued for a role.`,
+		HelpDescription: revokeHelp,
+	}
+}
+
+func (b *backend) pathRevokeUser() *framework.Path {
+	return &framework.Path{
+		Pattern: revokePath + "user/" + framework.GenericNameWithAtRegex("username"),
+		Fields: map[string]*framework.FieldSchema{
+			"username": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: "Artifactory username whose tokens are revoked.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRevokeUserWrite,
+				Summary:  "Revoke all the tokens issued for an Artifactory user.",
+			},
+		},
+		HelpSynopsis:    `Revoke all the tokens issued for an Artifactory user.`,
+		HelpDescription: revokeHelp,
+	}
+}
+
+func (b *backend) pathRevokeToken() *framework.Path {
+	return &framework.Path{
+		Pattern: revokePath + "token/" + framework.GenericNameRegex("token_id"),
+		Fields: map[string]*framework.FieldSchema{
+			"token_id": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: "ID of the token to revoke.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRevokeTokenWrite,
+				Summary:  "Revoke a token issued by the backend.",
+			},
+		},
+		HelpSynopsis:    `Revoke a token issued by the backend.`,
+		HelpDescription: revokeHelp,
+	}
+}
+
+func (b *backend) pathRevokeRoleWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	tokens, err := b.listIssuedTokensByRole(ctx, req.Storage, data.Get("role").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	return b.revokeIssuedTokens(ctx, req, tokens)
+}
+
+func (b *backend) pathRevokeUserWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	tokens, err := b.listIssuedTokensByUser(ctx, req.Storage, data.Get("username").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	return b.revokeIssuedTokens(ctx, req, tokens)
+}
+
+func (b *backend) pathRevokeTokenWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	tokenID := data.Get("token_id").(string)
+
+	token, err := fetchIssuedToken(ctx, req.Storage, tokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	if token == nil {
+		return logical.ErrorResponse("token %s was not issued by this backend", tokenID), nil
+	}
+
+	return b.revokeIssuedTokens(ctx, req, []*issuedToken{token})
+}
+
+// issuedForRole returns a filter of the tokens issued for the named role
+func issuedForRole(roleName string) func(*issuedToken) bool {
+	return func(token *issuedToken) bool {
+		return token.Source == leaseSourceRole && token.Role == roleName
+	}
+}
+
+// revokeIssuedTokens revokes the tokens of the ledger that are not revoked yet. Tokens that can't be revoked are
+// queued, like for leases, and reported in the response.
+func (b *backend) revokeIssuedTokens(ctx context.Context, req *logical.Request, tokens []*issuedToken) (*logical.Response, error) {
+	logger := b.Logger().With("func", "revokeIssuedTokens", "path", req.Path)
+
+	// The ledger and the pending revocations are in local storage
+	if !b.localStorageWritable() {
+		return nil, logical.ErrReadOnly
+	}
+
+	revoked := []string{}
+	failed := map[string]interface{}{}
+	for _, token := range tokens {
+		if !token.RevokedAt.IsZero() && !token.RevocationPending {
+			continue
+		}
+
+		if err := b.revokeIssuedToken(ctx, req.Storage, token); err != nil {
+			logger.Warn("failed to revoke token, queued to retry", "tokenId", token.TokenID, "err", err)
+			failed[token.TokenID] = err.Error()
+			continue
+		}
+
+		revoked = append(revoked, token.TokenID)
+	}
+
+	logger.Info("revoked tokens", "revoked", len(revoked), "failed", len(failed))
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"revoked": revoked,
+			"failed":  failed,
+		},
+	}
+	if len(failed) > 0 {
+		resp.AddWarning(fmt.Sprintf("%d tokens could not be revoked and are queued to be revoked again, see revocations/pending", len(failed)))
+	}
+
+	return resp, nil
+}
+
+// revokeIssuedToken revokes token with the credentials of its lease, or queues it if that fails
+func (b *backend) revokeIssuedToken(ctx context.Context, storage logical.Storage, token *issuedToken) error {
+	source := token.source()
+
+	credentials, err := b.revocationCredentials(ctx, storage, source)
+	if err == nil {
+		err = b.revokeTokenWithCredentials(ctx, credentials, source.Connection, source.TokenID)
+	}
+
+	if err != nil {
+		if queueErr := b.queueFailedRevocation(ctx, storage, source, err); queueErr != nil {
+			return fmt.Errorf("%w, and failed to queue it: %s", err, queueErr)
+		}
+		return err
+	}
+
+	b.markIssuedTokenRevoked(ctx, storage, token.TokenID, false)
+
+	// It may have been queued by a failed revocation of its lease
+	return storage.Delete(ctx, revocationsPath+token.TokenID)
+}
+
+const revokeHelp = `
+Revokes in Artifactory the tokens of the ledger of issued tokens, see tokens/, that belong to a role, to an Artifactory
+user, or a single token, whether their lease is still live or not. The leases are left in Vault: renewing them fails,
+and revoking them later, e.g. with "vault lease revoke -prefix", succeeds as the tokens are already revoked.
+
+Tokens that can't be revoked are queued to be revoked again, like the tokens of leases, and reported as failed.
+`
@@ -0,0 +1,202 @@
+package artifactory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_RevokeIssuedTokens(t *testing.T) {
+	issued := 0
+	var revokeErr error
+	fake := &fakeArtifactory{
+		createToken: func(_ context.Context, _ client.Config, _ client.CreateTokenRequest) (*client.CreateTokenResponse, error) {
+			issued++
+			return &client.CreateTokenResponse{
+				TokenId:     fmt.Sprintf("token-%d", issued),
+				AccessToken: "test-access-token",
+				ExpiresIn:   3600,
+				Scope:       "applied-permissions/user",
+				TokenType:   "Bearer",
+			}, nil
+		},
+		revokeToken: func(_ context.Context, _ client.Config, _ string) error {
+			return revokeErr
+		},
+	}
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-admin-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	for role, username := range map[string]string{"role-a": "user-a", "role-b": "user-b", "role-c": "user-c"} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + role,
+			Storage:   config.StorageView,
+			Data: map[string]interface{}{
+				"username": username,
+				"scope":    "test-scope",
+			},
+		})
+		assert.NoError(t, err)
+		assert.Nil(t, resp)
+	}
+
+	var leases []*logical.Secret
+	for _, role := range []string{"role-a", "role-a", "role-b", "role-c", "role-c"} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "token/" + role,
+			Storage:   config.StorageView,
+		})
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+		leases = append(leases, resp.Secret)
+	}
+
+	renew := func(secret *logical.Secret) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RenewOperation,
+			Path:      "token/test",
+			Secret:    secret,
+			Storage:   config.StorageView,
+		})
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+		return resp
+	}
+
+	revoke := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   config.StorageView,
+			Data:      data,
+		})
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+		return resp
+	}
+
+	// By role
+	resp := revoke("revoke/role/role-a", nil)
+	assert.ElementsMatch(t, []string{"token-1", "token-2"}, resp.Data["revoked"])
+	assert.Empty(t, resp.Data["failed"])
+	assert.Equal(t, 1, fake.callCount("RevokeToken token-1"))
+	assert.Equal(t, 1, fake.callCount("RevokeToken token-2"))
+	assert.Equal(t, 0, fake.callCount("RevokeToken token-3"))
+
+	token, err := fetchIssuedToken(context.Background(), config.StorageView, "token-1")
+	assert.NoError(t, err)
+	assert.False(t, token.RevokedAt.IsZero())
+
+	// Their leases can no longer be renewed
+	assert.True(t, renew(leases[0]).IsError())
+	assert.False(t, renew(leases[2]).IsError())
+
+	// Tokens already revoked are left alone
+	resp = revoke("revoke/role/role-a", nil)
+	assert.Empty(t, resp.Data["revoked"])
+	assert.Equal(t, 1, fake.callCount("RevokeToken token-1"))
+
+	// By username, queued if it fails
+	revokeErr = &client.StatusError{StatusCode: http.StatusInternalServerError, Err: errors.New("internal server error")}
+	resp = revoke("revoke/user/user-b", nil)
+	assert.Empty(t, resp.Data["revoked"])
+	assert.Contains(t, resp.Data["failed"], "token-3")
+	assert.Len(t, resp.Warnings, 1)
+
+	pending, err := config.StorageView.List(context.Background(), revocationsPath)
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+
+	token, err = fetchIssuedToken(context.Background(), config.StorageView, "token-3")
+	assert.NoError(t, err)
+	assert.True(t, token.RevocationPending)
+
+	// By token, which is no longer queued once revoked
+	revokeErr = nil
+	resp = revoke("revoke/token/token-3", nil)
+	assert.Equal(t, []string{"token-3"}, resp.Data["revoked"])
+
+	pending, err = config.StorageView.List(context.Background(), revocationsPath)
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+
+	resp = revoke("revoke/token/unknown-token", nil)
+	assert.True(t, resp.IsError())
+
+	// With the role
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "roles/role-c",
+		Storage:   config.StorageView,
+		Data:      map[string]interface{}{"revoke_tokens": true},
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.ElementsMatch(t, []string{"token-4", "token-5"}, resp.Data["revoked"])
+
+	role, err := b.Role(context.Background(), config.StorageView, "role-c")
+	assert.NoError(t, err)
+	assert.Nil(t, role)
+}
+
+func TestBackend_DeleteRoleWhileTokenIsIssued(t *testing.T) {
+	fake := &fakeArtifactory{}
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-admin-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/test-role",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"username": "test-username",
+			"scope":    "test-scope",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	// The role is deleted after the token is created, before it is recorded
+	fake.createToken = func(_ context.Context, _ client.Config, _ client.CreateTokenRequest) (*client.CreateTokenResponse, error) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "roles/test-role",
+			Storage:   config.StorageView,
+			Data:      map[string]interface{}{"revoke_tokens": true},
+		})
+		assert.NoError(t, err)
+		assert.Empty(t, resp.Data["revoked"])
+		return createdToken(), nil
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "token/test-role",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.IsError())
+	assert.Equal(t, 1, fake.callCount("RevokeToken 59e39159-19eb-463d-953d-1d6baf567db6"))
+
+	token, err := fetchIssuedToken(context.Background(), config.StorageView, "59e39159-19eb-463d-953d-1d6baf567db6")
+	assert.NoError(t, err)
+	assert.Nil(t, token)
+
+	wals, err := framework.ListWAL(context.Background(), config.StorageView)
+	assert.NoError(t, err)
+	assert.Empty(t, wals)
+}
@@ -58,6 +58,10 @@ func (b *backend) pathRoles() *framework.Path {
 				Default:     false,
 				Description: `Optional. Defaults to 'false'. Generate a Reference Token (alias to Access Token) in addition to the full token (available from Artifactory 7.38.10). A reference token is a shorter, 64-character string, which can be used as a bearer token, a password, or with the "X-JFrog-Art-Api" header. Note: Using the reference token might have performance implications over a full length token.`,
 			},
+			"connection": {
+				Type:        framework.TypeString,
+				Description: `Optional. Name of the connection (config/connections/<name>) used to issue access tokens. Defaults to the connection configured with config/admin.`,
+			},
 			"default_ttl": {
 				Type:        framework.TypeDurationSecond,
 				Description: `Default TTL for issued access tokens. If unset, uses the backend's default_ttl. Cannot exceed max_ttl.`,
@@ -66,6 +70,11 @@ func (b *backend) pathRoles() *framework.Path {
 				Type:        framework.TypeDurationSecond,
 				Description: `Maximum TTL that an access token can be renewed for. If unset, uses the backend's max_ttl. Cannot exceed backend's max_ttl.`,
 			},
+			"revoke_tokens": {
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: `Optional. Defaults to 'false'. On delete, also revoke the tokens issued for the role, like revoke/role/<role>.`,
+			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ReadOperation: &framework.PathOperation{
@@ -98,6 +107,7 @@ type artifactoryRole struct {
 	Audience              string        `json:"audience,omitempty"`
 	Description           string        `json:"description,omitempty"`
 	IncludeReferenceToken bool          `json:"include_reference_token"`
+	Connection            string        `json:"connection,omitempty"`
 	DefaultTTL            time.Duration `json:"default_ttl,omitempty"`
 	MaxTTL                time.Duration `json:"max_ttl,omitempty"`
 	RefreshToken          string        `json:"-"`
@@ -105,9 +115,6 @@ type artifactoryRole struct {
 }
 
 func (b *backend) pathRoleList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
-	b.rolesMutex.RLock()
-	defer b.rolesMutex.RUnlock()
-
 	entries, err := req.Storage.List(ctx, rolePath)
 	if err != nil {
 		return nil, err
@@ -117,11 +124,6 @@ func (b *backend) pathRoleList(ctx context.Context, req *logical.Request, _ *fra
 }
 
 func (b *backend) pathRoleWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	b.rolesMutex.Lock()
-	b.configMutex.RLock()
-	defer b.configMutex.RUnlock()
-	defer b.rolesMutex.Unlock()
-
 	config, err := b.fetchAdminConfiguration(ctx, req.Storage)
 	if err != nil {
 		return nil, err
@@ -131,13 +133,17 @@ func (b *backend) pathRoleWrite(ctx context.Context, req *logical.Request, data
 		return logical.ErrorResponse("backend not configured"), nil
 	}
 
-	go b.sendUsage(config.baseConfiguration, "pathRoleWrite")
+	b.reportUsage(config.baseConfiguration, "pathRoleWrite")
 
 	roleName := data.Get("role").(string)
 	if roleName == "" {
 		return logical.ErrorResponse("missing role"), nil
 	}
 
+	lock := b.lockForKey(rolePath + roleName)
+	lock.Lock()
+	defer lock.Unlock()
+
 	createOperation := (req.Operation == logical.CreateOperation)
 
 	role := &artifactoryRole{}
@@ -176,6 +182,26 @@ func (b *backend) pathRoleWrite(ctx context.Context, req *logical.Request, data
 		role.IncludeReferenceToken = value.(bool)
 	}
 
+	if value, ok := data.GetOk("connection"); ok {
+		role.Connection = value.(string)
+	}
+
+	if role.Connection != defaultConnection {
+		// Keep the connection from being deleted until the role is stored
+		if connLock := b.lockForKey(connectionStoragePath(role.Connection)); connLock != lock {
+			connLock.RLock()
+			defer connLock.RUnlock()
+		}
+
+		connConfig, err := b.fetchConnectionConfiguration(ctx, req.Storage, role.Connection)
+		if err != nil {
+			return nil, err
+		}
+		if connConfig == nil {
+			return logical.ErrorResponse("no such connection: %s", role.Connection), nil
+		}
+	}
+
 	// Looking at database/path_roles.go, it doesn't do any validation on these values during role creation.
 	if value, ok := data.GetOk("default_ttl"); ok {
 		role.DefaultTTL = time.Duration(value.(int)) * time.Second
@@ -202,11 +228,6 @@ func (b *backend) pathRoleWrite(ctx context.Context, req *logical.Request, data
 }
 
 func (b *backend) pathRoleRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	b.rolesMutex.RLock()
-	b.configMutex.RLock()
-	defer b.configMutex.RUnlock()
-	defer b.rolesMutex.RUnlock()
-
 	config, err := b.fetchAdminConfiguration(ctx, req.Storage)
 	if err != nil {
 		return nil, err
@@ -216,7 +237,7 @@ func (b *backend) pathRoleRead(ctx context.Context, req *logical.Request, data *
 		return logical.ErrorResponse("backend not configured"), nil
 	}
 
-	go b.sendUsage(config.baseConfiguration, "pathRoleRead")
+	b.reportUsage(config.baseConfiguration, "pathRoleRead")
 
 	roleName := data.Get("role").(string)
 
@@ -277,16 +298,14 @@ func (b *backend) roleToMap(roleName string, role artifactoryRole) (roleMap map[
 	if len(role.Audience) > 0 {
 		roleMap["audience"] = role.Audience
 	}
+	if len(role.Connection) > 0 {
+		roleMap["connection"] = role.Connection
+	}
 
 	return
 }
 
 func (b *backend) pathRoleDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	b.rolesMutex.Lock()
-	b.configMutex.RLock()
-	defer b.configMutex.RUnlock()
-	defer b.rolesMutex.Unlock()
-
 	config, err := b.fetchAdminConfiguration(ctx, req.Storage)
 	if err != nil {
 		return nil, err
@@ -296,14 +315,35 @@ func (b *backend) pathRoleDelete(ctx context.Context, req *logical.Request, data
 		return logical.ErrorResponse("backend not configured"), nil
 	}
 
-	go b.sendUsage(config.baseConfiguration, "pathRoleDelete")
+	b.reportUsage(config.baseConfiguration, "pathRoleDelete")
+
+	roleName := data.Get("role").(string)
+	revokeTokens := data.Get("revoke_tokens").(bool)
+
+	// The tokens are revoked from the ledger, in local storage
+	if revokeTokens && !b.localStorageWritable() {
+		return nil, logical.ErrReadOnly
+	}
+
+	lock := b.lockForKey(rolePath + roleName)
+	lock.Lock()
+	err = req.Storage.Delete(ctx, rolePath+roleName)
+	lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if !revokeTokens {
+		return nil, nil
+	}
 
-	err = req.Storage.Delete(ctx, rolePath+data.Get("role").(string))
+	// Tokens issued for the role from now on are revoked instead of being recorded, see storeIssuedRoleToken
+	tokens, err := b.listIssuedTokensByRole(ctx, req.Storage, roleName)
 	if err != nil {
 		return nil, err
 	}
 
-	return nil, nil
+	return b.revokeIssuedTokens(ctx, req, tokens)
 }
 
 func (b *backend) existenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
@@ -49,28 +49,23 @@ An optional 'max_ttl' parameter will override the role's 'max_ttl' parameter.
 	}
 }
 
-type systemVersionResponse struct {
-	Version  string `json:"version"`
-	Revision string `json:"revision"`
-}
+func (b *backend) pathTokenCreatePerform(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	// The role and configuration are read without holding their locks, so slow requests to Artifactory don't keep
+	// them from being issued or written
 
-type createTokenResponse struct {
-	TokenId        string `json:"token_id"`
-	AccessToken    string `json:"access_token"`
-	RefreshToken   string `json:"refresh_token"`
-	ExpiresIn      int    `json:"expires_in"`
-	Scope          string `json:"scope"`
-	TokenType      string `json:"token_type"`
-	ReferenceToken string `json:"reference_token"`
-}
+	// Read in the requested role
+	roleName := data.Get("role").(string)
 
-func (b *backend) pathTokenCreatePerform(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	b.rolesMutex.RLock()
-	b.configMutex.RLock()
-	defer b.configMutex.RUnlock()
-	defer b.rolesMutex.RUnlock()
+	role, err := b.Role(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
 
-	config, err := b.fetchAdminConfiguration(ctx, req.Storage)
+	if role == nil {
+		return logical.ErrorResponse("no such role: %s", roleName), nil
+	}
+
+	config, err := b.fetchConnectionConfiguration(ctx, req.Storage, role.Connection)
 	if err != nil {
 		return nil, err
 	}
@@ -83,23 +78,23 @@ func (b *backend) pathTokenCreatePerform(ctx context.Context, req *logical.Reque
 		return logical.ErrorResponse("missing access token"), errors.New("missing access token")
 	}
 
-	go b.sendUsage(config.baseConfiguration, "pathTokenCreatePerform")
-
-	// Read in the requested role
-	roleName := data.Get("role").(string)
-
-	role, err := b.Role(ctx, req.Storage, roleName)
-	if err != nil {
-		return nil, err
-	}
-
-	if role == nil {
-		return logical.ErrorResponse("no such role: %s", roleName), nil
-	}
+	b.reportUsage(config.baseConfiguration, "pathTokenCreatePerform")
 
 	// Define username for token by template if a static one is not set
 	if len(role.Username) == 0 {
-		role.Username, err = b.usernameProducer.Generate(UsernameMetadata{
+		adminLock := b.lockForKey(configAdminPath)
+		adminLock.RLock()
+		usernameProducer := b.usernameProducer
+		adminLock.RUnlock()
+
+		if role.Connection != defaultConnection && len(config.UsernameTemplate) != 0 {
+			usernameProducer, err = testUsernameTemplate(config.UsernameTemplate)
+			if err != nil {
+				return logical.ErrorResponse("error generating username from template"), err
+			}
+		}
+
+		role.Username, err = usernameProducer.Generate(UsernameMetadata{
 			RoleName:    roleName,
 			DisplayName: req.DisplayName,
 		})
@@ -161,7 +156,12 @@ func (b *backend) pathTokenCreatePerform(ctx context.Context, req *logical.Reque
 		}
 	}
 
-	resp, err := b.CreateToken(config.baseConfiguration, *role)
+	// The token is written to the WAL, local storage that a performance standby can't write
+	if !b.localStorageWritable() {
+		return nil, logical.ErrReadOnly
+	}
+
+	resp, err := b.createTaggedToken(ctx, req, config, config.baseConfiguration, *role)
 	if err != nil {
 		return nil, err
 	}
@@ -176,7 +176,10 @@ func (b *backend) pathTokenCreatePerform(ctx context.Context, req *logical.Reque
 		"username":        role.Username,
 		"reference_token": resp.ReferenceToken,
 	}, map[string]interface{}{
+		"version":         leaseInternalDataVersion,
+		"source":          leaseSourceRole,
 		"role":            roleName,
+		"connection":      role.Connection,
 		"access_token":    resp.AccessToken,
 		"refresh_token":   resp.RefreshToken,
 		"expires_in":      resp.ExpiresIn,
@@ -189,5 +192,19 @@ func (b *backend) pathTokenCreatePerform(ctx context.Context, req *logical.Reque
 	response.Secret.TTL = ttl
 	response.Secret.MaxTTL = maxLeaseTTL
 
+	source := leaseSource{
+		Source:     leaseSourceRole,
+		Role:       roleName,
+		Connection: role.Connection,
+		TokenID:    resp.TokenId,
+	}
+	err = b.trackIssuedToken(ctx, req, config.baseConfiguration, source, role.Username, resp, maxLeaseTTL)
+	if errors.Is(err, errRoleDeleted) {
+		return logical.ErrorResponse("role %s was deleted while the token was issued", roleName), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
 	return response, nil
 }
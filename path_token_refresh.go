@@ -0,0 +1,280 @@
+package artifactory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathTokenRefresh() *framework.Path {
+	return &framework.Path{
+		Pattern: "token/" + framework.GenericNameWithAtRegex("role") + "/refresh",
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: `Name of the role the token was issued for.`,
+			},
+			"refresh_token": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: `Refresh token returned with the access token.`,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathTokenRefreshWrite,
+				Summary:  "Refresh an access token issued for the specified role.",
+			},
+		},
+		HelpSynopsis:    `Refresh an access token issued for the specified role.`,
+		HelpDescription: tokenRefreshHelp,
+	}
+}
+
+func (b *backend) pathUserTokenRefresh() *framework.Path {
+	return &framework.Path{
+		Pattern: createUserTokenPath + framework.GenericNameWithAtRegex("username") + "/refresh",
+		Fields: map[string]*framework.FieldSchema{
+			"username": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: `The username of the user.`,
+			},
+			"refresh_token": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: `Refresh token returned with the access token.`,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathUserTokenRefreshWrite,
+				Summary:  "Refresh an access token issued for the specified user.",
+			},
+		},
+		HelpSynopsis:    `Refresh an access token issued for the specified user.`,
+		HelpDescription: tokenRefreshHelp,
+	}
+}
+
+func (b *backend) pathTokenRefreshWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role").(string)
+
+	role, err := b.Role(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil {
+		return logical.ErrorResponse("no such role: %s", roleName), nil
+	}
+
+	refreshToken := data.Get("refresh_token").(string)
+
+	issued, errResp, err := b.fetchRefreshedToken(ctx, req, refreshToken, "role "+roleName, issuedForRole(roleName))
+	if errResp != nil || err != nil {
+		return errResp, err
+	}
+
+	// Refresh with the connection the token was issued with, in case the role was changed since
+	config, err := b.fetchConnectionConfiguration(ctx, req.Storage, issued.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return logical.ErrorResponse("backend not configured"), nil
+	}
+
+	if config.AccessToken == "" {
+		return logical.ErrorResponse("missing access token"), errors.New("missing access token")
+	}
+
+	b.reportUsage(config.baseConfiguration, "pathTokenRefreshWrite")
+
+	return b.refreshIssuedToken(ctx, req, config.baseConfiguration, issued, refreshToken, role.DefaultTTL, role.MaxTTL)
+}
+
+func (b *backend) pathUserTokenRefreshWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	username := data.Get("username").(string)
+	refreshToken := data.Get("refresh_token").(string)
+
+	issued, errResp, err := b.fetchRefreshedToken(ctx, req, refreshToken, "user "+username, func(token *issuedToken) bool {
+		return token.Source == leaseSourceUserToken && token.Username == username
+	})
+	if errResp != nil || err != nil {
+		return errResp, err
+	}
+
+	adminConfig, err := b.fetchAdminConfiguration(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if adminConfig == nil {
+		return logical.ErrorResponse("backend not configured"), nil
+	}
+
+	baseConfig := adminConfig.baseConfiguration
+
+	userTokenConfig, err := b.fetchUserTokenConfiguration(ctx, req.Storage, issued.UserTokenConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if userTokenConfig.AccessToken != "" {
+		baseConfig.AccessToken = userTokenConfig.AccessToken
+	}
+
+	if baseConfig.AccessToken == "" {
+		return logical.ErrorResponse("missing access token"), errors.New("missing access token")
+	}
+
+	err = b.refreshExpiredAccessToken(ctx, req, &baseConfig, userTokenConfig)
+	if errors.Is(err, logical.ErrReadOnly) {
+		return nil, err
+	}
+	if err != nil {
+		return logical.ErrorResponse("failed to refresh access token"), err
+	}
+
+	b.reportUsage(baseConfig, "pathUserTokenRefreshWrite")
+
+	return b.refreshIssuedToken(ctx, req, baseConfig, issued, refreshToken, userTokenConfig.DefaultTTL, userTokenConfig.MaxTTL)
+}
+
+// fetchRefreshedToken returns the token of the ledger matching filter, issued for owner, that refreshToken was issued
+// with, or an error response if there is none or it can't be refreshed anymore
+func (b *backend) fetchRefreshedToken(ctx context.Context, req *logical.Request, refreshToken string, owner string, filter func(*issuedToken) bool) (*issuedToken, *logical.Response, error) {
+	if refreshToken == "" {
+		return nil, logical.ErrorResponse("missing refresh_token"), nil
+	}
+
+	// The ledger and the WAL are in local storage
+	if !b.localStorageWritable() {
+		return nil, nil, logical.ErrReadOnly
+	}
+
+	token, err := fetchIssuedTokenByRefreshToken(ctx, req.Storage, refreshToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case token == nil || !filter(token):
+		return nil, logical.ErrorResponse("refresh token was not issued for %s by this backend", owner), nil
+	case token.RefreshedTo != "":
+		return nil, logical.ErrorResponse("token %s was already refreshed", token.TokenID), nil
+	case !token.RevokedAt.IsZero():
+		return nil, logical.ErrorResponse("token %s was revoked", token.TokenID), nil
+	}
+
+	return token, nil, nil
+}
+
+// refreshIssuedToken exchanges refreshToken for a new token, returned with a new lease, and revokes the token it
+// replaces
+func (b *backend) refreshIssuedToken(ctx context.Context, req *logical.Request, config baseConfiguration, issued *issuedToken, refreshToken string, defaultTTL, maxTTL time.Duration) (*logical.Response, error) {
+	logger := b.Logger().With("func", "refreshIssuedToken", "tokenId", issued.TokenID)
+
+	if !config.UseNewAccessAPI {
+		return logical.ErrorResponse("refreshing tokens requires Artifactory 7.21.1 or later"), nil
+	}
+
+	maxLeaseTTL := b.Backend.System().MaxLeaseTTL()
+	if maxTTL > 0 && maxTTL < maxLeaseTTL {
+		maxLeaseTTL = maxTTL
+	}
+
+	ttl := b.Backend.System().DefaultLeaseTTL()
+	if defaultTTL > 0 {
+		ttl = defaultTTL
+	}
+
+	// cap ttl to maxLeaseTTL
+	if maxLeaseTTL > 0 && ttl > maxLeaseTTL {
+		ttl = maxLeaseTTL
+	}
+
+	resp, err := b.RefreshToken(ctx, config, refreshToken)
+	if err != nil {
+		return logical.ErrorResponse("failed to refresh token"), err
+	}
+
+	source := issued.source()
+	source.TokenID = resp.TokenId
+
+	secretData := map[string]interface{}{
+		"access_token":    resp.AccessToken,
+		"refresh_token":   resp.RefreshToken,
+		"expires_in":      resp.ExpiresIn,
+		"scope":           resp.Scope,
+		"token_id":        resp.TokenId,
+		"username":        issued.Username,
+		"reference_token": resp.ReferenceToken,
+	}
+	internalData := map[string]interface{}{
+		"version":         leaseInternalDataVersion,
+		"source":          source.Source,
+		"connection":      source.Connection,
+		"access_token":    resp.AccessToken,
+		"refresh_token":   resp.RefreshToken,
+		"expires_in":      resp.ExpiresIn,
+		"scope":           resp.Scope,
+		"token_id":        resp.TokenId,
+		"username":        issued.Username,
+		"reference_token": resp.ReferenceToken,
+	}
+
+	switch source.Source {
+	case leaseSourceRole:
+		secretData["role"] = source.Role
+		internalData["role"] = source.Role
+	case leaseSourceUserToken:
+		internalData["user_token_config"] = source.UserTokenConfig
+	}
+
+	response := b.Secret(SecretArtifactoryAccessTokenType).Response(secretData, internalData)
+	response.Secret.TTL = ttl
+	response.Secret.MaxTTL = maxLeaseTTL
+
+	err = b.trackIssuedToken(ctx, req, config, source, issued.Username, resp, maxLeaseTTL)
+	roleDeleted := errors.Is(err, errRoleDeleted)
+	if err != nil && !roleDeleted {
+		return nil, err
+	}
+
+	// The lease of the old token can't be revoked from here: it can no longer be renewed, and revoking it succeeds
+	err = b.updateIssuedToken(ctx, req.Storage, issued.TokenID, func(token *issuedToken) {
+		token.RefreshedTo = resp.TokenId
+	})
+	if err != nil {
+		logger.Warn("failed to update token ledger", "err", err)
+	}
+
+	// Artifactory may already have revoked it
+	if err := b.revokeIssuedToken(ctx, req.Storage, issued); err != nil {
+		logger.Warn("failed to revoke refreshed token, queued to retry", "err", err)
+		response.AddWarning(fmt.Sprintf("the refreshed token %s could not be revoked and is queued to be revoked again: %s", issued.TokenID, err))
+	}
+
+	if roleDeleted {
+		return logical.ErrorResponse("role %s was deleted while the token was refreshed", source.Role), nil
+	}
+
+	return response, nil
+}
+
+const tokenRefreshHelp = `
+Exchanges the refresh token of a refreshable access token issued by this backend for a new access token and refresh
+token, returned with a new lease. The refresh token must belong to a token in the ledger of issued tokens, see tokens/,
+issued for the same role or user, that was not revoked or refreshed already. Requires Artifactory 7.21.1 or later.
+
+The lease of the new token uses the default and maximum TTL of the role, or of the user token configuration. The
+refreshed token is revoked, and the renewal of its lease fails, so that it ends.
+`
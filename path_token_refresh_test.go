@@ -0,0 +1,139 @@
+package artifactory
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_RefreshToken(t *testing.T) {
+	issued := 0
+	issue := func() (*client.CreateTokenResponse, error) {
+		issued++
+		return &client.CreateTokenResponse{
+			TokenId:      fmt.Sprintf("token-%d", issued),
+			AccessToken:  fmt.Sprintf("test-access-token-%d", issued),
+			RefreshToken: fmt.Sprintf("test-refresh-token-%d", issued),
+			ExpiresIn:    3600,
+			Scope:        "applied-permissions/user",
+			TokenType:    "Bearer",
+		}, nil
+	}
+
+	var refreshed []string
+	fake := &fakeArtifactory{
+		createToken: func(_ context.Context, _ client.Config, _ client.CreateTokenRequest) (*client.CreateTokenResponse, error) {
+			return issue()
+		},
+		refreshToken: func(_ context.Context, _ client.Config, refreshToken string) (*client.CreateTokenResponse, error) {
+			refreshed = append(refreshed, refreshToken)
+			return issue()
+		},
+	}
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-admin-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/test-role",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"username":    "test-username",
+			"scope":       "test-scope",
+			"refreshable": true,
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	lease, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "token/test-role",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, lease)
+	assert.Equal(t, "test-refresh-token-1", lease.Data["refresh_token"])
+
+	refresh := func(path string, refreshToken string) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   config.StorageView,
+			Data:      map[string]interface{}{"refresh_token": refreshToken},
+		})
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+		return resp
+	}
+
+	newLease := refresh("token/test-role/refresh", "test-refresh-token-1")
+	assert.False(t, newLease.IsError())
+	assert.Equal(t, []string{"test-refresh-token-1"}, refreshed)
+	assert.Equal(t, "token-2", newLease.Data["token_id"])
+	assert.Equal(t, "test-refresh-token-2", newLease.Data["refresh_token"])
+	assert.Equal(t, "test-role", newLease.Data["role"])
+	assert.NotNil(t, newLease.Secret)
+	assert.Equal(t, leaseSourceRole, newLease.Secret.InternalData["source"])
+	assert.Equal(t, "token-2", newLease.Secret.InternalData["token_id"])
+
+	// The refreshed token is revoked and replaced in the ledger
+	assert.Equal(t, 1, fake.callCount("RevokeToken token-1"))
+
+	token, err := fetchIssuedToken(context.Background(), config.StorageView, "token-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "token-2", token.RefreshedTo)
+	assert.False(t, token.RevokedAt.IsZero())
+
+	token, err = fetchIssuedToken(context.Background(), config.StorageView, "token-2")
+	assert.NoError(t, err)
+	assert.Equal(t, "test-role", token.Role)
+	assert.Equal(t, "token/test-role/refresh", token.LeasePathPrefix)
+
+	// The old lease can't be renewed, the new one can
+	renew := func(secret *logical.Secret) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RenewOperation,
+			Path:      "token/test-role",
+			Secret:    secret,
+			Storage:   config.StorageView,
+		})
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+		return resp
+	}
+
+	assert.True(t, renew(lease.Secret).IsError())
+	assert.False(t, renew(newLease.Secret).IsError())
+
+	assert.True(t, refresh("token/test-role/refresh", "test-refresh-token-1").IsError(), "already refreshed")
+	assert.True(t, refresh("token/test-role/refresh", "unknown-refresh-token").IsError())
+	assert.True(t, refresh("user_token/test-username/refresh", "test-refresh-token-2").IsError(), "not issued for the user")
+	assert.Len(t, refreshed, 1)
+
+	// User tokens
+	lease, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "user_token/test-user",
+		Storage:   config.StorageView,
+		Data:      map[string]interface{}{"refreshable": true},
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, lease)
+
+	newLease = refresh("user_token/test-user/refresh", lease.Data["refresh_token"].(string))
+	assert.False(t, newLease.IsError())
+	assert.Equal(t, "token-4", newLease.Data["token_id"])
+	assert.Equal(t, leaseSourceUserToken, newLease.Secret.InternalData["source"])
+
+	token, err = fetchIssuedToken(context.Background(), config.StorageView, "token-3")
+	assert.NoError(t, err)
+	assert.Equal(t, "token-4", token.RefreshedTo)
+}
@@ -68,9 +68,6 @@ func (b *backend) pathUserTokenCreate() *framework.Path {
 }
 
 func (b *backend) pathUserTokenCreatePerform(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	b.configMutex.Lock()
-	defer b.configMutex.Unlock()
-
 	logger := b.Logger().With("func", "pathUserTokenCreatePerform")
 
 	baseConfig := baseConfiguration{}
@@ -101,12 +98,15 @@ func (b *backend) pathUserTokenCreatePerform(ctx context.Context, req *logical.R
 		return logical.ErrorResponse("missing access token"), errors.New("missing access token")
 	}
 
-	err = b.refreshExpiredAccessToken(ctx, req, &baseConfig, userTokenConfig, username)
+	err = b.refreshExpiredAccessToken(ctx, req, &baseConfig, userTokenConfig)
+	if errors.Is(err, logical.ErrReadOnly) {
+		return nil, err
+	}
 	if err != nil {
 		return logical.ErrorResponse("failed to refresh access token"), err
 	}
 
-	go b.sendUsage(baseConfig, "pathUserTokenCreatePerform")
+	b.reportUsage(baseConfig, "pathUserTokenCreatePerform")
 
 	baseConfig.UseExpiringTokens = userTokenConfig.UseExpiringTokens
 	if value, ok := data.GetOk("use_expiring_tokens"); ok {
@@ -193,7 +193,12 @@ func (b *backend) pathUserTokenCreatePerform(ctx context.Context, req *logical.R
 		role.Scope = scope
 	}
 
-	resp, err := b.CreateToken(baseConfig, role)
+	// The token is written to the WAL, local storage that a performance standby can't write
+	if !b.localStorageWritable() {
+		return nil, logical.ErrReadOnly
+	}
+
+	resp, err := b.createTaggedToken(ctx, req, adminConfig, baseConfig, role)
 	if err != nil {
 		return logical.ErrorResponse("failed to create new token"), err
 	}
@@ -208,17 +213,31 @@ func (b *backend) pathUserTokenCreatePerform(ctx context.Context, req *logical.R
 		"description":     role.Description,
 		"reference_token": resp.ReferenceToken,
 	}, map[string]interface{}{
-		"access_token":    resp.AccessToken,
-		"refresh_token":   resp.RefreshToken,
-		"expires_in":      resp.ExpiresIn,
-		"scope":           resp.Scope,
-		"token_id":        resp.TokenId,
-		"username":        role.Username,
-		"reference_token": resp.ReferenceToken,
+		"version":           leaseInternalDataVersion,
+		"source":            leaseSourceUserToken,
+		"user_token_config": userTokenConfig.username,
+		"connection":        defaultConnection,
+		"access_token":      resp.AccessToken,
+		"refresh_token":     resp.RefreshToken,
+		"expires_in":        resp.ExpiresIn,
+		"scope":             resp.Scope,
+		"token_id":          resp.TokenId,
+		"username":          role.Username,
+		"reference_token":   resp.ReferenceToken,
 	})
 
 	response.Secret.TTL = ttl
 	response.Secret.MaxTTL = maxLeaseTTL
 
+	source := leaseSource{
+		Source:          leaseSourceUserToken,
+		UserTokenConfig: userTokenConfig.username,
+		Connection:      defaultConnection,
+		TokenID:         resp.TokenId,
+	}
+	if err := b.trackIssuedToken(ctx, req, baseConfig, source, role.Username, resp, maxLeaseTTL); err != nil {
+		return nil, err
+	}
+
 	return response, nil
 }
@@ -0,0 +1,422 @@
+package artifactory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+)
+
+const (
+	reconcilePath = "reconcile/"
+	// tokenMarkerIDPath is the random ID of the mount on this cluster, part of the token marker. It is in local
+	// storage, so that a cluster doesn't take the tokens of another one for orphans.
+	tokenMarkerIDPath = reconcilePath + "marker_id"
+)
+
+// Reasons a token is reported as orphaned, from its entry in the ledger of issued tokens. The backend can't look up
+// leases, so the ledger stands for them.
+const (
+	orphanReasonNoLease      = "no lease recorded for the token"
+	orphanReasonLeaseRevoked = "lease was revoked"
+	orphanReasonLeaseExpired = "lease expired"
+)
+
+// reconcileReport is the result of the last reconciliation of the tokens of a connection
+type reconcileReport struct {
+	Connection string    `json:"connection"`
+	DryRun     bool      `json:"dry_run"`
+	StartedAt  time.Time `json:"started_at"`
+	// Checked is the number of tokens with the marker of the mount
+	Checked int           `json:"checked"`
+	Orphans []orphanToken `json:"orphans,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// orphanToken is a token with the marker of the mount that has no live lease according to the ledger
+type orphanToken struct {
+	TokenID  string    `json:"token_id"`
+	Subject  string    `json:"subject"`
+	IssuedAt time.Time `json:"issued_at"`
+	// LeaseID is the lease of the token according to the ledger, when it was recorded
+	LeaseID string `json:"lease_id,omitempty"`
+	Reason  string `json:"reason"`
+	Revoked bool   `json:"revoked,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (r *reconcileReport) responseData() map[string]interface{} {
+	orphans := make([]map[string]interface{}, 0, len(r.Orphans))
+	revoked := 0
+	for _, orphan := range r.Orphans {
+		info := map[string]interface{}{
+			"token_id":  orphan.TokenID,
+			"subject":   orphan.Subject,
+			"issued_at": orphan.IssuedAt,
+			"reason":    orphan.Reason,
+			"revoked":   orphan.Revoked,
+		}
+		if orphan.LeaseID != "" {
+			info["lease_id"] = orphan.LeaseID
+		}
+		if orphan.Error != "" {
+			info["error"] = orphan.Error
+		}
+		if orphan.Revoked {
+			revoked++
+		}
+
+		orphans = append(orphans, info)
+	}
+
+	data := map[string]interface{}{
+		"connection": r.Connection,
+		"dry_run":    r.DryRun,
+		"started_at": r.StartedAt,
+		"checked":    r.Checked,
+		"orphans":    orphans,
+		"revoked":    revoked,
+	}
+	if r.Error != "" {
+		data["error"] = r.Error
+	}
+
+	return data
+}
+
+func (b *backend) pathReconcile() *framework.Path {
+	return &framework.Path{
+		Pattern: "reconcile",
+		Fields: map[string]*framework.FieldSchema{
+			"connection": {
+				Type:        framework.TypeString,
+				Description: "Optional. Name of the connection whose tokens are reconciled. Defaults to the connection configured with config/admin.",
+			},
+			"dry_run": {
+				Type:        framework.TypeBool,
+				Default:     true,
+				Description: "Optional. Only report the tokens left without a lease. Set to false to revoke them. Default to `true`.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathReconcileUpdate,
+				Summary:  "Find the tokens issued by the backend that no longer have a lease.",
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathReconcileRead,
+				Summary:  "Read the result of the last reconciliation.",
+			},
+		},
+		HelpSynopsis: `Find the tokens issued by the backend that no longer have a lease.`,
+		HelpDescription: `
+Lists the tokens of Artifactory and finds the ones tagged with the marker of this mount, see token_marker, that have no
+live lease according to the ledger of issued tokens: tokens whose lease was revoked, or reached its max TTL, but are
+still in Artifactory, and tokens not recorded at all, e.g. when the request failed. They are revoked unless dry_run is
+true, which is the default. Tokens queued to be revoked, and tokens issued less than wal_rollback_min_age ago, are left
+alone.
+
+Leases are not looked up: Vault doesn't let the backend read them. The ledger follows them instead, and records a lease
+as revoked when Vault revokes it through the backend, including when it expires. A lease removed without calling the
+backend, e.g. with "vault lease revoke -force", is only taken for ended once its max TTL has passed. The orphans are
+reported with the lease_id recorded in the ledger, if the lease was renewed or revoked.
+
+Reading this path returns the result of the last reconciliation, on demand or scheduled with reconcile_interval.
+Requires Artifactory 7.21.1 or later.
+`,
+	}
+}
+
+func (b *backend) pathReconcileUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	// The report and the marker ID are in local storage
+	if !b.localStorageWritable() {
+		return nil, logical.ErrReadOnly
+	}
+
+	name := data.Get("connection").(string)
+
+	config, err := b.fetchConnectionConfiguration(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return logical.ErrorResponse("backend not configured"), nil
+	}
+
+	report, err := b.reconcileTokens(ctx, req, config, data.Get("dry_run").(bool))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	return &logical.Response{
+		Data: report.responseData(),
+	}, nil
+}
+
+func (b *backend) pathReconcileRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	report, err := b.fetchReconcileReport(ctx, req.Storage, data.Get("connection").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if report == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: report.responseData(),
+	}, nil
+}
+
+// reconcileReportStoragePath returns the storage path of the last reconciliation of the named connection
+func reconcileReportStoragePath(name string) string {
+	return reconcilePath + connectionStoragePath(name)
+}
+
+func (b *backend) fetchReconcileReport(ctx context.Context, storage logical.Storage, name string) (*reconcileReport, error) {
+	entry, err := storage.Get(ctx, reconcileReportStoragePath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var report reconcileReport
+	if err := entry.DecodeJSON(&report); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// tokenMarkerID returns the random ID of the mount on this cluster, generating it the first time
+func (b *backend) tokenMarkerID(ctx context.Context, storage logical.Storage) (string, error) {
+	lock := b.lockForKey(tokenMarkerIDPath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, err := storage.Get(ctx, tokenMarkerIDPath)
+	if err != nil {
+		return "", err
+	}
+
+	if entry != nil {
+		return string(entry.Value), nil
+	}
+
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return "", err
+	}
+
+	if err := storage.Put(ctx, &logical.StorageEntry{Key: tokenMarkerIDPath, Value: []byte(id)}); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// tokenMarker returns the marker added to the description of the tokens issued with config, which identifies the
+// mount and the Vault cluster that issued them
+func (b *backend) tokenMarker(ctx context.Context, req *logical.Request, config *adminConfiguration) (string, error) {
+	prefix := config.TokenMarker
+	if prefix == "" {
+		prefix = "vault"
+		if req.MountAccessor != "" {
+			prefix += ":" + req.MountAccessor
+		}
+	}
+
+	id, err := b.tokenMarkerID(ctx, req.Storage)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("[%s:%s]", prefix, id), nil
+}
+
+// withTokenMarker returns description tagged with marker
+func withTokenMarker(description string, marker string) string {
+	if description == "" {
+		return marker
+	}
+
+	return description + " " + marker
+}
+
+// createTaggedToken creates a token for role with config, tagged with the marker of adminConfig so that it is found by
+// reconcile if it outlives its lease
+func (b *backend) createTaggedToken(ctx context.Context, req *logical.Request, adminConfig *adminConfiguration, config baseConfiguration, role artifactoryRole) (*client.CreateTokenResponse, error) {
+	marker, err := b.tokenMarker(ctx, req, adminConfig)
+	if err != nil {
+		return nil, err
+	}
+	role.Description = withTokenMarker(role.Description, marker)
+
+	return b.CreateToken(ctx, config, role)
+}
+
+// reconcileTokens finds the tokens with the marker of the mount that have no live lease according to the ledger,
+// revokes them unless dryRun is set, and stores the report
+func (b *backend) reconcileTokens(ctx context.Context, req *logical.Request, config *adminConfiguration, dryRun bool) (*reconcileReport, error) {
+	logger := b.Logger().With("func", "reconcileTokens", "connection", config.connection, "dryRun", dryRun)
+
+	if !config.UseNewAccessAPI {
+		return nil, fmt.Errorf("reconciling tokens requires Artifactory 7.21.1 or later")
+	}
+
+	report := &reconcileReport{
+		Connection: config.connection,
+		DryRun:     dryRun,
+		StartedAt:  time.Now(),
+	}
+
+	err := b.findOrphanTokens(ctx, req, config, report)
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	entry, storeErr := logical.StorageEntryJSON(reconcileReportStoragePath(config.connection), report)
+	if storeErr == nil {
+		storeErr = req.Storage.Put(ctx, entry)
+	}
+	if storeErr != nil {
+		return nil, errors.Join(err, storeErr)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("reconciled tokens", "checked", report.Checked, "orphans", len(report.Orphans))
+
+	return report, nil
+}
+
+func (b *backend) findOrphanTokens(ctx context.Context, req *logical.Request, config *adminConfiguration, report *reconcileReport) error {
+	logger := b.Logger().With("func", "findOrphanTokens", "connection", config.connection)
+
+	marker, err := b.tokenMarker(ctx, req, config)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := b.ListTokens(ctx, config.baseConfiguration)
+	if err != nil {
+		return fmt.Errorf("error listing tokens: %w", err)
+	}
+
+	for _, token := range tokens {
+		if !strings.Contains(token.Description, marker) {
+			continue
+		}
+		report.Checked++
+
+		// The token may still be in the WAL, before its lease is returned
+		issuedAt := time.Unix(token.IssuedAt, 0)
+		if report.StartedAt.Sub(issuedAt) < b.WALRollbackMinAge {
+			continue
+		}
+
+		issued, err := fetchIssuedToken(ctx, req.Storage, token.TokenID)
+		if err != nil {
+			return err
+		}
+
+		orphan := orphanToken{
+			TokenID:  token.TokenID,
+			Subject:  token.Subject,
+			IssuedAt: issuedAt,
+		}
+
+		source := leaseSource{Source: leaseSourceRole, Connection: config.connection, TokenID: token.TokenID}
+
+		switch {
+		case issued == nil:
+			orphan.Reason = orphanReasonNoLease
+		case issued.RevocationPending:
+			continue
+		case !issued.RevokedAt.IsZero():
+			orphan.Reason = orphanReasonLeaseRevoked
+		case !issued.LeaseExpiresAt.IsZero() && issued.LeaseExpiresAt.Before(report.StartedAt):
+			orphan.Reason = orphanReasonLeaseExpired
+		default:
+			continue
+		}
+
+		if issued != nil {
+			source = issued.source()
+			orphan.LeaseID = issued.LeaseID
+		}
+
+		if !report.DryRun {
+			credentials, err := b.revocationCredentials(ctx, req.Storage, source)
+			if err == nil {
+				err = b.revokeTokenWithCredentials(ctx, credentials, source.Connection, token.TokenID)
+			}
+
+			if err != nil {
+				logger.Warn("failed to revoke orphaned token", "tokenId", token.TokenID, "err", err)
+				orphan.Error = err.Error()
+			} else {
+				logger.Info("revoked orphaned token", "tokenId", token.TokenID, "reason", orphan.Reason)
+				orphan.Revoked = true
+				b.markIssuedTokenRevoked(ctx, req.Storage, token.TokenID, false)
+			}
+		}
+
+		report.Orphans = append(report.Orphans, orphan)
+	}
+
+	return nil
+}
+
+// reconcileConnections reconciles the tokens of every connection whose reconcile_interval has passed since the last
+// reconciliation
+func (b *backend) reconcileConnections(ctx context.Context, req *logical.Request) error {
+	names, err := req.Storage.List(ctx, connectionsPath)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range append([]string{defaultConnection}, names...) {
+		if err := b.reconcileConnectionIfDue(ctx, req, name); err != nil {
+			errs = append(errs, fmt.Errorf("connection %q: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (b *backend) reconcileConnectionIfDue(ctx context.Context, req *logical.Request, name string) error {
+	config, err := b.fetchConnectionConfiguration(ctx, req.Storage, name)
+	if err != nil {
+		return err
+	}
+
+	if config == nil || config.AccessToken == "" || config.ReconcileInterval <= 0 {
+		return nil
+	}
+
+	last, err := b.fetchReconcileReport(ctx, req.Storage, name)
+	if err != nil {
+		return err
+	}
+
+	if last != nil && time.Since(last.StartedAt) < config.ReconcileInterval {
+		return nil
+	}
+
+	_, err = b.reconcileTokens(ctx, req, config, !config.ReconcileRevoke)
+	return err
+}
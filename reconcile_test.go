@@ -0,0 +1,149 @@
+package artifactory
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func reconcile(t *testing.T, b *backend, storage logical.Storage, dryRun bool) map[string]interface{} {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation:     logical.UpdateOperation,
+		Path:          "reconcile",
+		MountAccessor: "artifactory_1234",
+		Storage:       storage,
+		Data:          map[string]interface{}{"dry_run": dryRun},
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.False(t, resp.IsError())
+
+	return resp.Data
+}
+
+func orphanReasons(data map[string]interface{}) map[string]string {
+	reasons := map[string]string{}
+	for _, orphan := range data["orphans"].([]map[string]interface{}) {
+		reasons[orphan["token_id"].(string)] = orphan["reason"].(string)
+	}
+	return reasons
+}
+
+func TestBackend_ReconcileOrphanTokens(t *testing.T) {
+	tokenID := "59e39159-19eb-463d-953d-1d6baf567db6"
+
+	var description string
+	var tokens []client.TokenDetails
+	fake := &fakeArtifactory{
+		createToken: func(_ context.Context, _ client.Config, request client.CreateTokenRequest) (*client.CreateTokenResponse, error) {
+			description = request.Description
+			return createdToken(), nil
+		},
+		listTokens: func(_ context.Context, _ client.Config) ([]client.TokenDetails, error) {
+			return tokens, nil
+		},
+	}
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token":       "test-admin-token",
+		"url":                "http://myserver.com:80",
+		"reconcile_interval": "1h",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/test-role",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"username": "test-username",
+			"scope":    "test-scope",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	lease, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation:     logical.ReadOperation,
+		Path:          "token/test-role",
+		MountAccessor: "artifactory_1234",
+		Storage:       config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, lease)
+
+	markerID, err := b.tokenMarkerID(context.Background(), config.StorageView)
+	assert.NoError(t, err)
+	marker := fmt.Sprintf("[vault:artifactory_1234:%s]", markerID)
+	assert.Equal(t, marker, description)
+
+	hourAgo := time.Now().Add(-time.Hour).Unix()
+	tokens = []client.TokenDetails{
+		{TokenID: tokenID, Subject: "test-username", IssuedAt: hourAgo, Description: description},
+		{TokenID: "orphan-token-id", Subject: "test-username", IssuedAt: hourAgo, Description: marker},
+		{TokenID: "recent-token-id", Subject: "test-username", IssuedAt: time.Now().Unix(), Description: marker},
+		{TokenID: "other-cluster-token-id", Subject: "test-username", IssuedAt: hourAgo, Description: "[vault:artifactory_1234:other]"},
+		{TokenID: "unrelated-token-id", Subject: "admin", IssuedAt: hourAgo},
+	}
+
+	// Scheduled with reconcile_interval, dry run by default
+	assert.NoError(t, b.reconcileConnections(context.Background(), &logical.Request{MountAccessor: "artifactory_1234", Storage: config.StorageView}))
+	assert.NoError(t, b.reconcileConnections(context.Background(), &logical.Request{MountAccessor: "artifactory_1234", Storage: config.StorageView}))
+	assert.Equal(t, 1, fake.callCount("ListTokens"), "not due again before reconcile_interval")
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "reconcile",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.Equal(t, true, resp.Data["dry_run"])
+	assert.Equal(t, 3, resp.Data["checked"])
+	assert.Equal(t, map[string]string{"orphan-token-id": orphanReasonNoLease}, orphanReasons(resp.Data))
+
+	// A lease removed without revoking it through the backend, e.g. forced, is taken for ended past its max TTL
+	assert.NoError(t, b.updateIssuedToken(context.Background(), config.StorageView, tokenID, func(token *issuedToken) {
+		token.LeaseExpiresAt = time.Now().Add(-time.Minute)
+	}))
+
+	data := reconcile(t, b, config.StorageView, true)
+	assert.Equal(t, map[string]string{"orphan-token-id": orphanReasonNoLease, tokenID: orphanReasonLeaseExpired}, orphanReasons(data))
+
+	// Once its lease is revoked, a token still in Artifactory is an orphan, reported with the lease recorded then
+	lease.Secret.LeaseID = "artifactory/token/test-role/test-lease-id"
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Path:      "token/test-role",
+		Secret:    lease.Secret,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+
+	data = reconcile(t, b, config.StorageView, true)
+	assert.Equal(t, map[string]string{"orphan-token-id": orphanReasonNoLease, tokenID: orphanReasonLeaseRevoked}, orphanReasons(data))
+	for _, orphan := range data["orphans"].([]map[string]interface{}) {
+		if orphan["token_id"] == tokenID {
+			assert.Equal(t, "artifactory/token/test-role/test-lease-id", orphan["lease_id"])
+		} else {
+			assert.NotContains(t, orphan, "lease_id")
+		}
+	}
+	assert.Equal(t, 0, fake.callCount("RevokeToken orphan-token-id"))
+
+	data = reconcile(t, b, config.StorageView, false)
+	assert.Equal(t, 2, data["revoked"])
+	assert.Equal(t, 1, fake.callCount("RevokeToken orphan-token-id"))
+	assert.Equal(t, 0, fake.callCount("RevokeToken recent-token-id"))
+	assert.Equal(t, 0, fake.callCount("RevokeToken other-cluster-token-id"))
+	assert.Equal(t, 0, fake.callCount("RevokeToken unrelated-token-id"))
+}
+
+func TestWithTokenMarker(t *testing.T) {
+	assert.Equal(t, "[vault:test]", withTokenMarker("", "[vault:test]"))
+	assert.Equal(t, "test-description [vault:test]", withTokenMarker("test-description", "[vault:test]"))
+}
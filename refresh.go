@@ -0,0 +1,148 @@
+package artifactory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// refreshUserTokens refreshes the access token of every stored user token configuration that expires within its
+// refresh_before_expiry, so it doesn't expire, along with its refresh token, while the configuration is not used
+func (b *backend) refreshUserTokens(ctx context.Context, req *logical.Request) error {
+	usernames, err := req.Storage.List(ctx, configUserTokenPath+"/")
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, username := range append([]string{""}, usernames...) {
+		// Usernames can't contain "/", these are not user token configurations
+		if strings.HasSuffix(username, "/") {
+			continue
+		}
+
+		if err := b.refreshUserTokenIfDue(ctx, req, username); err != nil {
+			errs = append(errs, fmt.Errorf("user token configuration %q: %w", username, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (b *backend) refreshUserTokenIfDue(ctx context.Context, req *logical.Request, username string) error {
+	logger := b.Logger().With("func", "refreshUserTokenIfDue", "username", username)
+
+	// Only refresh stored configurations, not the default used in their place
+	userTokenConfig, err := b.fetchStoredUserTokenConfiguration(ctx, req.Storage, username)
+	if err != nil || userTokenConfig == nil {
+		return err
+	}
+
+	if userTokenConfig.RefreshBeforeExpiry <= 0 || userTokenConfig.AccessToken == "" || userTokenConfig.RefreshToken == "" {
+		return nil
+	}
+
+	adminConfig, err := b.fetchAdminConfiguration(ctx, req.Storage)
+	if err != nil || adminConfig == nil {
+		return err
+	}
+
+	baseConfig := adminConfig.baseConfiguration
+	baseConfig.AccessToken = userTokenConfig.AccessToken
+
+	token, err := b.getTokenInfo(ctx, baseConfig, userTokenConfig.AccessToken)
+	if errors.Is(err, ErrInvalidTokenClaims) {
+		logger.Error("unable to check access token expiry", "err", err)
+		return b.recordUserTokenRefreshError(ctx, req, username, err)
+	}
+	if err != nil {
+		// The token may not be a JWT, there is no expiry to check
+		logger.Warn("unable to check access token expiry", "err", err)
+		return nil
+	}
+
+	if token.Expires == 0 || time.Until(time.Unix(token.Expires, 0)) >= userTokenConfig.RefreshBeforeExpiry {
+		return nil
+	}
+
+	logger.Info("refreshing access token before it expires", "tokenId", token.TokenID)
+
+	err = b.refreshUserToken(ctx, req, userTokenConfig, baseConfig)
+	if err != nil {
+		logger.Error("failed to refresh access token", "err", err)
+		return b.recordUserTokenRefreshError(ctx, req, username, err)
+	}
+
+	return nil
+}
+
+// recordUserTokenRefreshError records err as the last refresh error of the user token configuration of username, and
+// returns it
+func (b *backend) recordUserTokenRefreshError(ctx context.Context, req *logical.Request, username string, err error) error {
+	lock := b.lockForKey(userTokenConfigStoragePath(username))
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Record the error on the stored configuration, in case the tokens changed in the meantime
+	stored, storeErr := b.fetchStoredUserTokenConfiguration(ctx, req.Storage, username)
+	if storeErr == nil && stored != nil {
+		stored.LastRefreshError = err.Error()
+		stored.LastRefreshErrorTime = time.Now()
+		storeErr = b.storeUserTokenConfiguration(ctx, req, username, stored)
+	}
+	if storeErr != nil {
+		return errors.Join(err, storeErr)
+	}
+
+	return err
+}
+
+// userTokenRefreshTimeout bounds a refresh shared by concurrent requests, which doesn't end with any of them
+const userTokenRefreshTimeout = time.Minute
+
+// refreshUserToken refreshes the access token of userTokenConfig with its refresh token, and stores them. Artifactory
+// refresh tokens can only be used once, so concurrent refreshes of the same configuration are made once and share the
+// refreshed tokens.
+func (b *backend) refreshUserToken(ctx context.Context, req *logical.Request, userTokenConfig *userTokenConfiguration, config baseConfiguration) error {
+	expiredAccessToken := userTokenConfig.AccessToken
+
+	refreshed, err, shared := b.userTokenRefreshes.Do(userTokenConfigStoragePath(userTokenConfig.username), func() (interface{}, error) {
+		// The refresh is shared, it must not fail when the request that started it is cancelled, once the refresh token
+		// may be spent
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), userTokenRefreshTimeout)
+		defer cancel()
+
+		// Another request may have refreshed it since the configuration was read
+		stored, err := b.fetchStoredUserTokenConfiguration(ctx, req.Storage, userTokenConfig.username)
+		if err != nil {
+			return nil, err
+		}
+
+		if stored != nil && stored.AccessToken != expiredAccessToken && stored.AccessToken != "" {
+			return stored, nil
+		}
+
+		refreshed := *userTokenConfig
+		if err := refreshed.RefreshAccessToken(ctx, req, refreshed.username, b, config); err != nil {
+			return nil, err
+		}
+
+		return &refreshed, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if shared {
+		b.Logger().With("func", "refreshUserToken").Debug("reusing access token refreshed by a concurrent request")
+	}
+
+	userTokenConfig.AccessToken = refreshed.(*userTokenConfiguration).AccessToken
+	userTokenConfig.RefreshToken = refreshed.(*userTokenConfiguration).RefreshToken
+
+	return nil
+}
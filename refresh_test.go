@@ -0,0 +1,243 @@
+package artifactory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_RefreshUserTokens(t *testing.T) {
+	refreshFails := true
+	fake := &fakeArtifactory{
+		// Older Artifactory doesn't provide its root certificate, so the expired test token is parsed without verification
+		version: "7.11.0",
+		refreshToken: func(_ context.Context, _ client.Config, _ string) (*client.CreateTokenResponse, error) {
+			if refreshFails {
+				return nil, &client.StatusError{StatusCode: http.StatusUnauthorized, Err: errors.New("Invalid refresh token")}
+			}
+			return &client.CreateTokenResponse{AccessToken: "refreshed-access-token", RefreshToken: "refreshed-refresh-token"}, nil
+		},
+	}
+
+	var created struct {
+		AccessToken string `json:"access_token"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(jwtAccessToken), &created))
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-admin-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	for _, path := range []string{"config/user_token/disabled", "config/user_token/enabled"} {
+		data := map[string]interface{}{
+			"access_token":  created.AccessToken,
+			"refresh_token": "test-refresh-token",
+		}
+		if path == "config/user_token/enabled" {
+			data["refresh_before_expiry"] = "24h"
+		}
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   config.StorageView,
+			Data:      data,
+		})
+		assert.NoError(t, err)
+		assert.Nil(t, resp)
+	}
+
+	err := b.periodicFunc(context.Background(), &logical.Request{Storage: config.StorageView})
+	assert.ErrorContains(t, err, `user token configuration "enabled"`)
+	assert.Equal(t, 1, fake.callCount("RefreshToken"))
+
+	userTokenConfig, err := b.fetchUserTokenConfiguration(context.Background(), config.StorageView, "enabled")
+	assert.NoError(t, err)
+	assert.Contains(t, userTokenConfig.LastRefreshError, "Invalid refresh token")
+	assert.WithinDuration(t, time.Now(), userTokenConfig.LastRefreshErrorTime, time.Minute)
+
+	refreshFails = false
+
+	assert.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: config.StorageView}))
+	assert.Equal(t, 2, fake.callCount("RefreshToken"))
+
+	userTokenConfig, err = b.fetchUserTokenConfiguration(context.Background(), config.StorageView, "enabled")
+	assert.NoError(t, err)
+	assert.Equal(t, "refreshed-access-token", userTokenConfig.AccessToken)
+	assert.Equal(t, "refreshed-refresh-token", userTokenConfig.RefreshToken)
+	assert.Empty(t, userTokenConfig.LastRefreshError)
+	assert.WithinDuration(t, time.Now(), userTokenConfig.LastRefresh, time.Minute)
+
+	userTokenConfig, err = b.fetchUserTokenConfiguration(context.Background(), config.StorageView, "disabled")
+	assert.NoError(t, err)
+	assert.Equal(t, created.AccessToken, userTokenConfig.AccessToken)
+}
+
+// Test that a stored access token with unexpected claims fails its refresh, rather than the plugin.
+func TestBackend_RefreshUserTokensInvalidClaims(t *testing.T) {
+	fake := &fakeArtifactory{version: "7.11.0"}
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-admin-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/user_token/test",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"access_token": unsignedToken(t, jwt.MapClaims{
+				"jti": "test-token-id",
+				"sub": "test-user",
+				"exp": time.Now().Add(time.Hour).Unix(),
+			}),
+			"refresh_token":         "test-refresh-token",
+			"refresh_before_expiry": "24h",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	err = b.periodicFunc(context.Background(), &logical.Request{Storage: config.StorageView})
+	assert.ErrorIs(t, err, ErrInvalidTokenClaims)
+	assert.Equal(t, 0, fake.callCount("RefreshToken"))
+
+	userTokenConfig, err := b.fetchUserTokenConfiguration(context.Background(), config.StorageView, "test")
+	assert.NoError(t, err)
+	assert.Contains(t, userTokenConfig.LastRefreshError, ErrInvalidTokenClaims.Error())
+}
+
+func TestBackend_RefreshUserTokenSingleFlight(t *testing.T) {
+	var refreshes atomic.Int32
+	fake := &fakeArtifactory{
+		getTokenByID: func(_ context.Context, _ client.Config, _ string) (*client.TokenDetails, error) {
+			return nil, &client.TokenExpiredError{}
+		},
+		refreshToken: func(_ context.Context, _ client.Config, _ string) (*client.CreateTokenResponse, error) {
+			refreshes.Add(1)
+			time.Sleep(100 * time.Millisecond)
+			return &client.CreateTokenResponse{AccessToken: "refreshed-access-token", RefreshToken: "refreshed-refresh-token"}, nil
+		},
+	}
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-admin-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/user_token/test",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"access_token":  "expired-access-token",
+			"refresh_token": "test-refresh-token",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	adminConfig, err := b.fetchAdminConfiguration(context.Background(), config.StorageView)
+	assert.NoError(t, err)
+
+	// The request that starts the refresh is cancelled while it runs, the others still share it
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	var wg sync.WaitGroup
+	accessTokens := make([]string, 5)
+	for i := range accessTokens {
+		ctx := context.Background()
+		if i == 0 {
+			ctx = cancelledCtx
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			userTokenConfig, err := b.fetchUserTokenConfiguration(ctx, config.StorageView, "test")
+			assert.NoError(t, err)
+
+			baseConfig := adminConfig.baseConfiguration
+			baseConfig.AccessToken = userTokenConfig.AccessToken
+
+			err = b.refreshExpiredAccessToken(ctx, &logical.Request{Storage: config.StorageView}, &baseConfig, userTokenConfig)
+			if i > 0 {
+				assert.NoError(t, err)
+			}
+
+			accessTokens[i] = baseConfig.AccessToken
+		}()
+
+		if i == 0 {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), refreshes.Load())
+	for _, accessToken := range accessTokens[1:] {
+		assert.Equal(t, "refreshed-access-token", accessToken)
+	}
+
+	userTokenConfig, err := b.fetchUserTokenConfiguration(context.Background(), config.StorageView, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, "refreshed-refresh-token", userTokenConfig.RefreshToken)
+}
+
+func TestBackend_RefreshUserTokenDoesNotOverwriteNewerTokens(t *testing.T) {
+	fake := &fakeArtifactory{}
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-admin-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	writeUserTokenConfig := func(accessToken, refreshToken string) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/user_token/test",
+			Storage:   config.StorageView,
+			Data: map[string]interface{}{
+				"access_token":  accessToken,
+				"refresh_token": refreshToken,
+			},
+		})
+		assert.NoError(t, err)
+		assert.Nil(t, resp)
+	}
+
+	writeUserTokenConfig("expired-access-token", "test-refresh-token")
+
+	// The configuration is written again while the access token is refreshed
+	fake.refreshToken = func(_ context.Context, _ client.Config, _ string) (*client.CreateTokenResponse, error) {
+		writeUserTokenConfig("new-access-token", "new-refresh-token")
+		return &client.CreateTokenResponse{AccessToken: "refreshed-access-token", RefreshToken: "refreshed-refresh-token"}, nil
+	}
+
+	userTokenConfig, err := b.fetchUserTokenConfiguration(context.Background(), config.StorageView, "test")
+	assert.NoError(t, err)
+
+	adminConfig, err := b.fetchAdminConfiguration(context.Background(), config.StorageView)
+	assert.NoError(t, err)
+
+	err = userTokenConfig.RefreshAccessToken(context.Background(), &logical.Request{Storage: config.StorageView}, "test", b, adminConfig.baseConfiguration)
+	assert.NoError(t, err)
+	assert.Equal(t, "refreshed-access-token", userTokenConfig.AccessToken)
+
+	userTokenConfig, err = b.fetchUserTokenConfiguration(context.Background(), config.StorageView, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, "new-access-token", userTokenConfig.AccessToken)
+	assert.Equal(t, "new-refresh-token", userTokenConfig.RefreshToken)
+}
@@ -0,0 +1,359 @@
+package artifactory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	revocationsPath = "revocations/"
+
+	revocationBackoffMin = time.Minute
+	revocationBackoffMax = time.Hour
+)
+
+// pendingRevocation is a token to revoke once RevokeAfter has passed, e.g. the previous access token of a
+// connection after a rotation, or the token of a lease that could not be revoked. It is kept in storage so the token
+// is still revoked if Vault restarts.
+type pendingRevocation struct {
+	TokenID    string `json:"token_id"`
+	Connection string `json:"connection,omitempty"`
+	// UserToken is set for tokens created with the user token configuration of Username, which is tried first to
+	// revoke them
+	UserToken   bool      `json:"user_token,omitempty"`
+	Username    string    `json:"username,omitempty"`
+	RevokeAfter time.Time `json:"revoke_after"`
+	Attempts    int       `json:"attempts,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastAttempt time.Time `json:"last_attempt,omitempty"`
+	// Parked is set when the connection was deleted: the token is no longer retried until it is configured again
+	Parked bool `json:"parked,omitempty"`
+}
+
+func (b *backend) pathRevocationsPending() *framework.Path {
+	return &framework.Path{
+		Pattern: "revocations/pending/?$",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathRevocationsPendingRead,
+				Summary:  "List the tokens waiting to be revoked.",
+			},
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathRevocationsPendingRead,
+				Summary:  "List the tokens waiting to be revoked.",
+			},
+		},
+		HelpSynopsis: `List the tokens waiting to be revoked.`,
+		HelpDescription: `
+Lists the IDs of the tokens waiting to be revoked, with the connection they were issued with, when they are revoked
+next, how many attempts were made and the last error. Tokens are queued when the revocation of their lease fails, or
+after a rotation with a revoke_grace_period. Failed attempts are retried with an exponential backoff, from 1 minute up
+to 1 hour.
+
+Tokens of a connection that was deleted are parked: they are not retried, and are reported as parked, until the
+connection is configured again. They must otherwise be revoked in Artifactory.
+`,
+	}
+}
+
+func (b *backend) pathRevocationsPendingRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	tokenIDs, err := req.Storage.List(ctx, revocationsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	keyInfo := map[string]interface{}{}
+	for _, tokenID := range tokenIDs {
+		pending, err := fetchPendingRevocation(ctx, req.Storage, tokenID)
+		if err != nil {
+			return nil, err
+		}
+
+		if pending == nil {
+			continue
+		}
+
+		info := map[string]interface{}{
+			"connection":   pending.Connection,
+			"revoke_after": pending.RevokeAfter,
+			"attempts":     pending.Attempts,
+		}
+		if pending.UserToken {
+			info["username"] = pending.Username
+		}
+		if pending.Attempts > 0 || pending.Parked {
+			info["last_error"] = pending.LastError
+			info["last_attempt"] = pending.LastAttempt
+		}
+		if pending.Parked {
+			info["parked"] = true
+		}
+
+		keys = append(keys, tokenID)
+		keyInfo[tokenID] = info
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+func fetchPendingRevocation(ctx context.Context, storage logical.Storage, tokenID string) (*pendingRevocation, error) {
+	entry, err := storage.Get(ctx, revocationsPath+tokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var pending pendingRevocation
+	if err := entry.DecodeJSON(&pending); err != nil {
+		return nil, err
+	}
+
+	return &pending, nil
+}
+
+// queueRevocation stores that pending.TokenID must be revoked after pending.RevokeAfter
+func (b *backend) queueRevocation(ctx context.Context, storage logical.Storage, pending pendingRevocation) error {
+	entry, err := logical.StorageEntryJSON(revocationsPath+pending.TokenID, pending)
+	if err != nil {
+		return err
+	}
+
+	return storage.Put(ctx, entry)
+}
+
+// queueFailedRevocation queues the token of source to be revoked again after a backoff, once a first attempt failed
+// with err, and records it in the ledger
+func (b *backend) queueFailedRevocation(ctx context.Context, storage logical.Storage, source leaseSource, err error) error {
+	now := time.Now()
+
+	if err := b.queueRevocation(ctx, storage, pendingRevocation{
+		TokenID:     source.TokenID,
+		Connection:  source.Connection,
+		UserToken:   source.Source == leaseSourceUserToken,
+		Username:    source.UserTokenConfig,
+		RevokeAfter: now.Add(revocationBackoff(1)),
+		Attempts:    1,
+		LastError:   err.Error(),
+		LastAttempt: now,
+	}); err != nil {
+		return err
+	}
+
+	b.markIssuedTokenRevoked(ctx, storage, source.TokenID, true)
+
+	return nil
+}
+
+// deferRevocation stores that the token of source must be revoked after the given time
+func (b *backend) deferRevocation(ctx context.Context, storage logical.Storage, source leaseSource, after time.Time) error {
+	return b.queueRevocation(ctx, storage, pendingRevocation{
+		TokenID:     source.TokenID,
+		Connection:  source.Connection,
+		UserToken:   source.Source == leaseSourceUserToken,
+		Username:    source.UserTokenConfig,
+		RevokeAfter: after,
+	})
+}
+
+// revocationBackoff returns how long to wait before the next attempt to revoke a token, or to rotate an access token,
+// after the given number of failed attempts
+func revocationBackoff(attempts int) time.Duration {
+	backoff := revocationBackoffMin
+	for i := 1; i < attempts && backoff < revocationBackoffMax; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, revocationBackoffMax)
+}
+
+// revocationCredential is an access token to revoke tokens with
+type revocationCredential struct {
+	// name describes where the access token comes from, for errors and logs
+	name   string
+	config baseConfiguration
+}
+
+// revocationCredentials returns the access tokens to try, in order, to revoke a token issued from source: the
+// credential it was issued with, the admin token of its connection, then the default user token configuration. It
+// returns none if the connection is not configured.
+func (b *backend) revocationCredentials(ctx context.Context, storage logical.Storage, source leaseSource) ([]revocationCredential, error) {
+	config, err := b.fetchConnectionConfiguration(ctx, storage, source.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, nil
+	}
+
+	var credentials []revocationCredential
+	add := func(name string, accessToken string) {
+		if accessToken == "" {
+			return
+		}
+
+		for _, credential := range credentials {
+			if credential.config.AccessToken == accessToken {
+				return
+			}
+		}
+
+		baseConfig := config.baseConfiguration
+		baseConfig.AccessToken = accessToken
+		credentials = append(credentials, revocationCredential{name: name, config: baseConfig})
+	}
+
+	if source.Source == leaseSourceUserToken {
+		userTokenConfig, err := b.fetchUserTokenConfiguration(ctx, storage, source.UserTokenConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		add("user token configuration", userTokenConfig.AccessToken)
+	}
+
+	add("admin access token", config.AccessToken)
+
+	// The user token configuration applies to the default connection only
+	if source.Connection == defaultConnection {
+		userTokenConfig, err := b.fetchStoredUserTokenConfiguration(ctx, storage, "")
+		if err != nil {
+			return nil, err
+		}
+
+		if userTokenConfig != nil {
+			add("default user token configuration", userTokenConfig.AccessToken)
+		}
+	}
+
+	return credentials, nil
+}
+
+// revokeTokenWithCredentials revokes tokenID with the first of credentials that succeeds. It returns the errors of
+// all of them otherwise.
+func (b *backend) revokeTokenWithCredentials(ctx context.Context, credentials []revocationCredential, name string, tokenID string) error {
+	logger := b.Logger().With("func", "revokeTokenWithCredentials", "tokenId", tokenID)
+
+	if len(credentials) == 0 {
+		// The connection may be configured again
+		return fmt.Errorf("no access token is configured for connection %q", name)
+	}
+
+	var errs []error
+	for _, credential := range credentials {
+		err := b.RevokeToken(ctx, credential.config, tokenID)
+		if err == nil {
+			if len(errs) > 0 {
+				logger.Info("revoked token with fallback credential", "credential", credential.name)
+			}
+			return nil
+		}
+
+		logger.Debug("failed to revoke token", "credential", credential.name, "err", err)
+		errs = append(errs, fmt.Errorf("%s: %w", credential.name, err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// source returns where the pending token comes from, to revoke it with the same credentials as its lease
+func (p pendingRevocation) source() leaseSource {
+	source := leaseSource{
+		Source:     leaseSourceRole,
+		Connection: p.Connection,
+		TokenID:    p.TokenID,
+	}
+
+	if p.UserToken {
+		source.Source = leaseSourceUserToken
+		source.UserTokenConfig = p.Username
+	}
+
+	return source
+}
+
+// revokePendingTokens revokes the tokens whose revocation is due. Tokens that could not be revoked are tried again
+// after a backoff, and tokens of a deleted connection are parked until it is configured again.
+func (b *backend) revokePendingTokens(ctx context.Context, req *logical.Request) error {
+	tokenIDs, err := req.Storage.List(ctx, revocationsPath)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, tokenID := range tokenIDs {
+		if err := b.revokePendingToken(ctx, req, tokenID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (b *backend) revokePendingToken(ctx context.Context, req *logical.Request, tokenID string) error {
+	logger := b.Logger().With("func", "revokePendingToken", "tokenId", tokenID)
+
+	pending, err := fetchPendingRevocation(ctx, req.Storage, tokenID)
+	if err != nil || pending == nil {
+		return err
+	}
+
+	now := time.Now()
+	if !pending.Parked && now.Before(pending.RevokeAfter) {
+		return nil
+	}
+
+	config, err := b.fetchConnectionConfiguration(ctx, req.Storage, pending.Connection)
+	if err != nil {
+		return err
+	}
+
+	// Retrying can't succeed until the connection is configured again
+	if config == nil {
+		if pending.Parked {
+			return nil
+		}
+
+		logger.Warn("connection of token no longer exists, parked until it is configured again", "connection", pending.Connection)
+
+		pending.Parked = true
+		pending.LastError = fmt.Sprintf("connection %q no longer exists", pending.Connection)
+		pending.LastAttempt = now
+
+		return b.queueRevocation(ctx, req.Storage, *pending)
+	}
+	pending.Parked = false
+
+	credentials, err := b.revocationCredentials(ctx, req.Storage, pending.source())
+	if err == nil {
+		err = b.revokeTokenWithCredentials(ctx, credentials, pending.Connection, pending.TokenID)
+	}
+
+	if err == nil {
+		logger.Info("revoked token", "connection", pending.Connection, "attempts", pending.Attempts+1)
+		b.markIssuedTokenRevoked(ctx, req.Storage, tokenID, false)
+		return req.Storage.Delete(ctx, revocationsPath+tokenID)
+	}
+
+	pending.Attempts++
+	pending.LastError = err.Error()
+	pending.LastAttempt = now
+	pending.RevokeAfter = now.Add(revocationBackoff(pending.Attempts))
+
+	logger.Warn("failed to revoke token", "connection", pending.Connection, "attempts", pending.Attempts, "nextAttempt", pending.RevokeAfter, "err", err)
+
+	if storeErr := b.queueRevocation(ctx, req.Storage, *pending); storeErr != nil {
+		return errors.Join(err, storeErr)
+	}
+
+	return fmt.Errorf("error revoking token %s: %w", pending.TokenID, err)
+}
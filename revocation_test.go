@@ -0,0 +1,220 @@
+package artifactory
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func readPendingRevocations(t *testing.T, b *backend, storage logical.Storage) map[string]interface{} {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "revocations/pending",
+		Storage:   storage,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+
+	keyInfo, _ := resp.Data["key_info"].(map[string]interface{})
+	return keyInfo
+}
+
+func makeRevocationDue(t *testing.T, b *backend, storage logical.Storage, tokenID string) {
+	pending, err := fetchPendingRevocation(context.Background(), storage, tokenID)
+	assert.NoError(t, err)
+
+	pending.RevokeAfter = time.Now().Add(-time.Second)
+	assert.NoError(t, b.queueRevocation(context.Background(), storage, *pending))
+}
+
+func TestBackend_RevokeLeaseRetriesFailedRevocation(t *testing.T) {
+	tokenID := "59e39159-19eb-463d-953d-1d6baf567db6"
+	revokeErr := &client.StatusError{StatusCode: http.StatusForbidden, Err: errors.New("forbidden")}
+	fake := &fakeArtifactory{
+		revokeToken: func(_ context.Context, _ client.Config, _ string) error {
+			return revokeErr
+		},
+	}
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-admin-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/test-role",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"username": "test-username",
+			"scope":    "test-scope",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "token/test-role",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+
+	// The lease is revoked, the token is queued
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Path:      "token/test-role",
+		Secret:    resp.Secret,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+	assert.Equal(t, 1, fake.callCount("RevokeToken "+tokenID))
+
+	pending := readPendingRevocations(t, b, config.StorageView)
+	assert.Len(t, pending, 1)
+	info := pending[tokenID].(map[string]interface{})
+	assert.Equal(t, 1, info["attempts"])
+	assert.Contains(t, info["last_error"], "forbidden")
+	assert.WithinDuration(t, time.Now().Add(time.Minute), info["revoke_after"].(time.Time), 5*time.Second)
+
+	// Not retried before the backoff
+	assert.NoError(t, b.revokePendingTokens(context.Background(), &logical.Request{Storage: config.StorageView}))
+	assert.Equal(t, 1, fake.callCount("RevokeToken "+tokenID))
+
+	makeRevocationDue(t, b, config.StorageView, tokenID)
+	assert.Error(t, b.revokePendingTokens(context.Background(), &logical.Request{Storage: config.StorageView}))
+	assert.Equal(t, 2, fake.callCount("RevokeToken "+tokenID))
+
+	info = readPendingRevocations(t, b, config.StorageView)[tokenID].(map[string]interface{})
+	assert.Equal(t, 2, info["attempts"])
+	assert.WithinDuration(t, time.Now().Add(2*time.Minute), info["revoke_after"].(time.Time), 5*time.Second)
+
+	// A token that Artifactory doesn't know is already revoked
+	revokeErr = &client.StatusError{StatusCode: http.StatusNotFound, Err: errors.New("token not found")}
+
+	makeRevocationDue(t, b, config.StorageView, tokenID)
+	assert.NoError(t, b.revokePendingTokens(context.Background(), &logical.Request{Storage: config.StorageView}))
+	assert.Equal(t, 3, fake.callCount("RevokeToken "+tokenID))
+	assert.Empty(t, readPendingRevocations(t, b, config.StorageView))
+}
+
+func TestBackend_RevokeLeaseWithoutConfiguration(t *testing.T) {
+	fake := &fakeArtifactory{}
+
+	b, config := makeBackend(t)
+	useFakeArtifactory(b, fake)
+
+	secret := &logical.Secret{
+		InternalData: map[string]interface{}{
+			"secret_type": SecretArtifactoryAccessTokenType,
+			"role":        "test-role",
+			"token_id":    "test-token-id",
+			"username":    "test-username",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Path:      "token/test-role",
+		Secret:    secret,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	info := readPendingRevocations(t, b, config.StorageView)["test-token-id"].(map[string]interface{})
+	assert.Contains(t, info["last_error"], "no access token is configured")
+
+	// Revoked once the backend is configured again
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"access_token": "test-admin-token",
+			"url":          "http://myserver.com:80",
+		},
+	})
+	assert.NoError(t, err)
+
+	makeRevocationDue(t, b, config.StorageView, "test-token-id")
+	assert.NoError(t, b.revokePendingTokens(context.Background(), &logical.Request{Storage: config.StorageView}))
+	assert.Equal(t, 1, fake.callCount("RevokeToken test-token-id"))
+	assert.Empty(t, readPendingRevocations(t, b, config.StorageView))
+}
+
+func TestBackend_RevokePendingTokenOfDeletedConnection(t *testing.T) {
+	// Counts the revocations with the credentials of the other connection
+	var revoked int
+	fake := &fakeArtifactory{
+		revokeToken: func(_ context.Context, config client.Config, _ string) error {
+			if config.AccessToken == "other-access-token" {
+				revoked++
+			}
+			return nil
+		},
+	}
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-access-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	writeConnection := func() {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/connections/other",
+			Storage:   config.StorageView,
+			Data: map[string]interface{}{
+				"access_token": "other-access-token",
+				"url":          "http://other.example.com",
+			},
+		})
+		assert.NoError(t, err)
+		assert.Nil(t, resp)
+	}
+	writeConnection()
+
+	assert.NoError(t, b.deferRevocation(context.Background(), config.StorageView, leaseSource{Connection: "other", TokenID: "test-token-id"}, time.Now().Add(-time.Second)))
+
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "config/connections/other",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+
+	// Parked rather than retried forever
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, b.revokePendingTokens(context.Background(), &logical.Request{Storage: config.StorageView}))
+	}
+	assert.Equal(t, 0, fake.callCount("RevokeToken test-token-id"))
+
+	info := readPendingRevocations(t, b, config.StorageView)["test-token-id"].(map[string]interface{})
+	assert.Equal(t, true, info["parked"])
+	assert.Equal(t, 0, info["attempts"])
+	assert.Contains(t, info["last_error"], `connection "other" no longer exists`)
+
+	// Revoked once the connection is configured again
+	writeConnection()
+
+	assert.NoError(t, b.revokePendingTokens(context.Background(), &logical.Request{Storage: config.StorageView}))
+	assert.Equal(t, 1, revoked)
+	assert.Empty(t, readPendingRevocations(t, b, config.StorageView))
+}
+
+func TestRevocationBackoff(t *testing.T) {
+	assert.Equal(t, time.Minute, revocationBackoff(1))
+	assert.Equal(t, 2*time.Minute, revocationBackoff(2))
+	assert.Equal(t, 4*time.Minute, revocationBackoff(3))
+	assert.Equal(t, time.Hour, revocationBackoff(7))
+	assert.Equal(t, time.Hour, revocationBackoff(100))
+}
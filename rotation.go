@@ -0,0 +1,202 @@
+package artifactory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/hashicorp/vault/sdk/rotation"
+)
+
+const rotationStatusPath = "rotation/"
+
+// rotationStatus is the history of the access token rotations of a connection
+type rotationStatus struct {
+	LastRotation  time.Time `json:"last_rotation,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitempty"`
+	NextRotation  time.Time `json:"next_rotation,omitempty"`
+	// ConsecutiveErrors is the number of failed rotations since the last one that succeeded, to back off retries
+	ConsecutiveErrors int `json:"consecutive_errors,omitempty"`
+}
+
+// rotationStatusStoragePath returns the storage path of the rotation status of the named connection
+func rotationStatusStoragePath(name string) string {
+	return rotationStatusPath + connectionStoragePath(name)
+}
+
+// nextRotation returns when the access token is next due for rotation after from, or the zero time
+// if neither rotation_schedule nor rotation_period is set
+func (c *adminConfiguration) nextRotation(from time.Time) (time.Time, error) {
+	switch {
+	case c.RotationSchedule != "":
+		schedule, err := rotation.DefaultScheduler.Parse(c.RotationSchedule)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to parse rotation_schedule: %w", err)
+		}
+		return schedule.Next(from), nil
+	case c.RotationPeriod > 0:
+		return from.Add(c.RotationPeriod), nil
+	}
+
+	return time.Time{}, nil
+}
+
+func (b *backend) fetchRotationStatus(ctx context.Context, storage logical.Storage, name string) (*rotationStatus, error) {
+	entry, err := storage.Get(ctx, rotationStatusStoragePath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	var status rotationStatus
+	if entry == nil {
+		return &status, nil
+	}
+
+	if err := entry.DecodeJSON(&status); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+func (b *backend) storeRotationStatus(ctx context.Context, storage logical.Storage, name string, status *rotationStatus) error {
+	entry, err := logical.StorageEntryJSON(rotationStatusStoragePath(name), status)
+	if err != nil {
+		return err
+	}
+
+	return storage.Put(ctx, entry)
+}
+
+// scheduleRotation stores when the access token of config is next due for rotation, starting from now
+func (b *backend) scheduleRotation(ctx context.Context, storage logical.Storage, config *adminConfiguration, rotated bool) error {
+	status, err := b.fetchRotationStatus(ctx, storage, config.connection)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	if rotated {
+		status.LastRotation = now
+		status.ConsecutiveErrors = 0
+	}
+
+	status.NextRotation, err = config.nextRotation(now)
+	if err != nil {
+		return err
+	}
+
+	return b.storeRotationStatus(ctx, storage, config.connection, status)
+}
+
+// rotateAdminTokens rotates the access token of every connection that is due for rotation, either on its schedule
+// or because it expires within rotate_before_expiry
+func (b *backend) rotateAdminTokens(ctx context.Context, req *logical.Request) error {
+	names, err := req.Storage.List(ctx, connectionsPath)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range append([]string{defaultConnection}, names...) {
+		if err := b.rotateAdminTokenIfDue(ctx, req, name); err != nil {
+			errs = append(errs, fmt.Errorf("connection %q: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (b *backend) rotateAdminTokenIfDue(ctx context.Context, req *logical.Request, name string) error {
+	logger := b.Logger().With("func", "rotateAdminTokenIfDue", "connection", name)
+
+	config, err := b.fetchConnectionConfiguration(ctx, req.Storage, name)
+	if err != nil {
+		return err
+	}
+
+	if config == nil || config.AccessToken == "" {
+		return nil
+	}
+
+	status, err := b.fetchRotationStatus(ctx, req.Storage, name)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	reason := ""
+
+	// After a failed rotation, NextRotation is when it is retried
+	if status.ConsecutiveErrors > 0 && now.Before(status.NextRotation) {
+		return nil
+	}
+
+	if !status.NextRotation.IsZero() && !now.Before(status.NextRotation) {
+		reason = "scheduled"
+	} else if config.RotateBeforeExpiry > 0 {
+		token, err := b.getTokenInfo(ctx, config.baseConfiguration, config.AccessToken)
+		if errors.Is(err, ErrInvalidTokenClaims) {
+			logger.Error("unable to check access token expiry", "err", err)
+			return b.recordRotationError(ctx, req, name, err)
+		}
+		if err != nil {
+			logger.Warn("unable to check access token expiry", "err", err)
+		} else if token.Expires > 0 && time.Unix(token.Expires, 0).Sub(now) < config.RotateBeforeExpiry {
+			reason = "near expiry"
+		}
+	}
+
+	if reason == "" {
+		return nil
+	}
+
+	logger.Info("rotating access token", "reason", reason)
+
+	data := &framework.FieldData{
+		Raw:    map[string]interface{}{"name": name},
+		Schema: b.pathConfigConnectionRotate().Fields,
+	}
+
+	resp, err := b.pathConfigRotateWrite(ctx, req, data)
+	if err == nil && resp != nil && resp.IsError() {
+		err = resp.Error()
+	}
+
+	if err != nil {
+		logger.Error("failed to rotate access token", "err", err)
+		return b.recordRotationError(ctx, req, name, err)
+	}
+
+	return nil
+}
+
+// recordRotationError records err as the last rotation error of the named connection, retried after a backoff, and
+// returns it
+func (b *backend) recordRotationError(ctx context.Context, req *logical.Request, name string, err error) error {
+	lock := b.lockForKey(connectionStoragePath(name))
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Read it again, it may have changed while rotating
+	current, storeErr := b.fetchRotationStatus(ctx, req.Storage, name)
+	if storeErr != nil {
+		return errors.Join(err, storeErr)
+	}
+
+	now := time.Now()
+	current.LastError = err.Error()
+	current.LastErrorTime = now
+	current.ConsecutiveErrors++
+	current.NextRotation = now.Add(revocationBackoff(current.ConsecutiveErrors))
+	if storeErr := b.storeRotationStatus(ctx, req.Storage, name, current); storeErr != nil {
+		return errors.Join(err, storeErr)
+	}
+
+	return err
+}
@@ -0,0 +1,301 @@
+package artifactory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func readConfigAdmin(t *testing.T, b *backend, config *logical.BackendConfig) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+
+	return resp
+}
+
+// makeRotationDue moves the next scheduled rotation of the default connection to the past
+func makeRotationDue(t *testing.T, b *backend, config *logical.BackendConfig) {
+	status, err := b.fetchRotationStatus(context.Background(), config.StorageView, defaultConnection)
+	assert.NoError(t, err)
+
+	status.NextRotation = time.Now().Add(-time.Minute)
+	assert.NoError(t, b.storeRotationStatus(context.Background(), config.StorageView, defaultConnection, status))
+}
+
+func TestBackend_ScheduledRotation(t *testing.T) {
+	fake := &fakeArtifactory{}
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token":    signedAdminAccessToken,
+		"url":             "http://myserver.com:80",
+		"rotation_period": "1h",
+	})
+
+	resp := readConfigAdmin(t, b, config)
+	assert.Equal(t, float64(3600), resp.Data["rotation_period"])
+	assert.WithinDuration(t, time.Now().Add(time.Hour), resp.Data["next_rotation"].(time.Time), time.Minute)
+	assert.NotContains(t, resp.Data, "last_rotation")
+
+	// Not due yet
+	assert.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: config.StorageView}))
+	assert.Equal(t, 0, fake.callCount("CreateToken"))
+
+	makeRotationDue(t, b, config)
+
+	assert.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: config.StorageView}))
+	assert.Equal(t, 1, fake.callCount("CreateToken"))
+	assert.Equal(t, 1, fake.callCount("RevokeToken 1079485d-5a29-41cd-968e-e42fe924a521"))
+
+	adminConfig, err := b.fetchAdminConfiguration(context.Background(), config.StorageView)
+	assert.NoError(t, err)
+	assert.NotEqual(t, signedAdminAccessToken, adminConfig.AccessToken)
+
+	status, err := b.fetchRotationStatus(context.Background(), config.StorageView, defaultConnection)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), status.LastRotation, time.Minute)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), status.NextRotation, time.Minute)
+	assert.Empty(t, status.LastError)
+}
+
+func TestBackend_ScheduledRotationFailure(t *testing.T) {
+	fake := &fakeArtifactory{
+		createToken: func(_ context.Context, _ client.Config, _ client.CreateTokenRequest) (*client.CreateTokenResponse, error) {
+			return nil, &client.StatusError{StatusCode: http.StatusInternalServerError, Err: errors.New("internal server error")}
+		},
+	}
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token":      signedAdminAccessToken,
+		"url":               "http://myserver.com:80",
+		"rotation_schedule": "0 0 * * SAT",
+	})
+
+	makeRotationDue(t, b, config)
+
+	err := b.periodicFunc(context.Background(), &logical.Request{Storage: config.StorageView})
+	assert.Error(t, err)
+
+	resp := readConfigAdmin(t, b, config)
+	assert.Equal(t, "0 0 * * SAT", resp.Data["rotation_schedule"])
+	assert.Contains(t, resp.Data, "last_rotation_error")
+	assert.Contains(t, resp.Data, "last_rotation_error_time")
+	assert.NotContains(t, resp.Data, "last_rotation")
+	assert.WithinDuration(t, time.Now().Add(revocationBackoff(1)), resp.Data["next_rotation"].(time.Time), time.Minute)
+
+	// Backing off until next_rotation
+	assert.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: config.StorageView}))
+	assert.Equal(t, 1, fake.callCount("CreateToken"))
+
+	status, err := b.fetchRotationStatus(context.Background(), config.StorageView, defaultConnection)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, status.ConsecutiveErrors)
+
+	adminConfig, err := b.fetchAdminConfiguration(context.Background(), config.StorageView)
+	assert.NoError(t, err)
+	assert.Equal(t, signedAdminAccessToken, adminConfig.AccessToken)
+}
+
+func TestBackend_RotateBeforeExpiry(t *testing.T) {
+	// Older Artifactory doesn't provide its root certificate, so the expired test token is parsed without verification
+	fake := &fakeArtifactory{version: "7.11.0"}
+
+	var created struct {
+		AccessToken string `json:"access_token"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(jwtAccessToken), &created))
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": created.AccessToken,
+		"url":          "http://myserver.com:80",
+	})
+
+	// Not configured
+	assert.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: config.StorageView}))
+	assert.Equal(t, 0, fake.callCount("CreateToken"))
+
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configAdminPath,
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"rotate_before_expiry": "168h",
+		},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: config.StorageView}))
+	assert.Equal(t, 1, fake.callCount("CreateToken"))
+
+	resp := readConfigAdmin(t, b, config)
+	assert.Equal(t, float64(604800), resp.Data["rotate_before_expiry"])
+	assert.Contains(t, resp.Data, "last_rotation")
+	assert.NotContains(t, resp.Data, "next_rotation")
+}
+
+// Test that an access token with unexpected claims fails the scheduled rotation, rather than the plugin.
+func TestBackend_RotateBeforeExpiryInvalidClaims(t *testing.T) {
+	// Older Artifactory doesn't provide its root certificate, so the test token is parsed without verification
+	fake := &fakeArtifactory{version: "7.11.0"}
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": unsignedToken(t, jwt.MapClaims{
+			"jti": "test-token-id",
+			"scp": "applied-permissions/admin",
+			"sub": "admin",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}),
+		"url":                  "http://myserver.com:80",
+		"rotate_before_expiry": "168h",
+	})
+
+	err := b.periodicFunc(context.Background(), &logical.Request{Storage: config.StorageView})
+	assert.ErrorIs(t, err, ErrInvalidTokenClaims)
+	assert.Equal(t, 0, fake.callCount("CreateToken"))
+
+	resp := readConfigAdmin(t, b, config)
+	assert.Contains(t, resp.Data["last_rotation_error"], ErrInvalidTokenClaims.Error())
+	assert.WithinDuration(t, time.Now().Add(revocationBackoff(1)), resp.Data["next_rotation"].(time.Time), time.Minute)
+}
+
+func TestBackend_RotationSettingsValidation(t *testing.T) {
+	b, config := makeBackend(t)
+	useFakeArtifactory(b, &fakeArtifactory{})
+
+	for expected, data := range map[string]map[string]interface{}{
+		"mutually exclusive": {
+			"url":               "http://myserver.com:80",
+			"rotation_period":   "24h",
+			"rotation_schedule": "0 0 * * *",
+		},
+		"invalid rotation_schedule": {
+			"url":               "http://myserver.com:80",
+			"rotation_schedule": "every day",
+		},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      configAdminPath,
+			Storage:   config.StorageView,
+			Data:      data,
+		})
+		assert.NoError(t, err)
+		assert.True(t, resp.IsError())
+		assert.Contains(t, resp.Error().Error(), expected)
+	}
+}
+
+func TestBackend_RotateWithRevokeGracePeriod(t *testing.T) {
+	fake := &fakeArtifactory{}
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token":        signedAdminAccessToken,
+		"url":                 "http://myserver.com:80",
+		"revoke_grace_period": "1h",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/rotate",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	oldTokenRevocation := "RevokeToken 1079485d-5a29-41cd-968e-e42fe924a521"
+	assert.Equal(t, 1, fake.callCount("GetTokenByID me"))
+	assert.Equal(t, 0, fake.callCount(oldTokenRevocation))
+
+	pending, err := config.StorageView.List(context.Background(), revocationsPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1079485d-5a29-41cd-968e-e42fe924a521"}, pending)
+
+	// Not due yet
+	assert.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: config.StorageView}))
+	assert.Equal(t, 0, fake.callCount(oldTokenRevocation))
+
+	assert.NoError(t, b.deferRevocation(context.Background(), config.StorageView,
+		leaseSource{TokenID: "1079485d-5a29-41cd-968e-e42fe924a521"}, time.Now().Add(-time.Minute)))
+
+	assert.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: config.StorageView}))
+	assert.Equal(t, 1, fake.callCount(oldTokenRevocation))
+
+	pending, err = config.StorageView.List(context.Background(), revocationsPath)
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestBackend_RotateQueuesOldTokenIfRevocationFails(t *testing.T) {
+	fake := &fakeArtifactory{
+		revokeToken: func(_ context.Context, _ client.Config, _ string) error {
+			return &client.StatusError{StatusCode: http.StatusForbidden, Err: errors.New("forbidden")}
+		},
+	}
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": signedAdminAccessToken,
+		"url":          "http://myserver.com:80",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/rotate",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.False(t, resp.IsError())
+	assert.Len(t, resp.Warnings, 1)
+
+	pending, err := config.StorageView.List(context.Background(), revocationsPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1079485d-5a29-41cd-968e-e42fe924a521"}, pending)
+
+	stored, err := b.fetchAdminConfiguration(context.Background(), config.StorageView)
+	assert.NoError(t, err)
+	assert.NotEqual(t, signedAdminAccessToken, stored.AccessToken)
+}
+
+func TestBackend_RotateUnusableToken(t *testing.T) {
+	fake := &fakeArtifactory{
+		getTokenByID: func(_ context.Context, _ client.Config, _ string) (*client.TokenDetails, error) {
+			return nil, &client.StatusError{StatusCode: http.StatusUnauthorized, Err: errors.New("bad credentials")}
+		},
+	}
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": signedAdminAccessToken,
+		"url":          "http://myserver.com:80",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/rotate",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"scope": "applied-permissions/groups:vault",
+			"ttl":   "720h",
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.IsError())
+	assert.Contains(t, resp.Error().Error(), "new access token is not usable")
+
+	assert.Equal(t, 0, fake.callCount("RevokeToken 1079485d-5a29-41cd-968e-e42fe924a521"))
+	assert.Equal(t, 1, fake.callCount("RevokeToken 59e39159-19eb-463d-953d-1d6baf567db6"))
+
+	adminConfig, err := b.fetchAdminConfiguration(context.Background(), config.StorageView)
+	assert.NoError(t, err)
+	assert.Equal(t, signedAdminAccessToken, adminConfig.AccessToken)
+	assert.False(t, adminConfig.RevokeOnDelete)
+}
@@ -3,14 +3,23 @@ package artifactory
 import (
 	"context"
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
 )
 
-const SecretArtifactoryAccessTokenType = "artifactory_access_token"
+const (
+	SecretArtifactoryAccessTokenType = "artifactory_access_token"
+
+	// leaseInternalDataVersion is the version of the internal data of the leases, which records where their token
+	// comes from since version 2
+	leaseInternalDataVersion = 2
+
+	leaseSourceRole      = "role"
+	leaseSourceUserToken = "user_token"
+)
 
 func (b *backend) secretAccessToken() *framework.Secret {
 	return &framework.Secret{
@@ -44,7 +53,7 @@ func (b *backend) secretAccessToken() *framework.Secret {
 }
 
 func (b *backend) secretAccessTokenRenew(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
-	config, err := b.fetchAdminConfiguration(ctx, req.Storage)
+	config, err := b.fetchConnectionConfiguration(ctx, req.Storage, secretConnection(req.Secret))
 	if err != nil {
 		return nil, err
 	}
@@ -60,29 +69,49 @@ func (b *backend) secretAccessTokenRenew(ctx context.Context, req *logical.Reque
 	var defaultTTL time.Duration
 	var maxTTL time.Duration
 
-	if rawRole, ok := req.Secret.InternalData["role"]; ok {
+	source := leaseSourceFromSecret(req.Secret)
+
+	switch source.Source {
+	case leaseSourceRole:
 		// Role backed token
-		role, err := b.Role(ctx, req.Storage, rawRole.(string))
+		role, err := b.Role(ctx, req.Storage, source.Role)
 		if err != nil {
-			return nil, fmt.Errorf("error during renew: could not get role: %q", rawRole)
+			return nil, fmt.Errorf("error during renew: could not get role: %q", source.Role)
 		}
 		if role == nil {
-			return nil, fmt.Errorf("error during renew: could not find role with name: %q", rawRole)
+			return nil, fmt.Errorf("error during renew: could not find role with name: %q", source.Role)
 		}
 		defaultTTL = role.DefaultTTL
 		maxTTL = role.MaxTTL
-	} else if rawUsername, ok := req.Secret.InternalData["username"]; ok {
+	case leaseSourceUserToken:
 		// User backed token
-		userTokenConfig, err := b.fetchUserTokenConfiguration(ctx, req.Storage, rawUsername.(string))
+		userTokenConfig, err := b.fetchUserTokenConfiguration(ctx, req.Storage, source.UserTokenConfig)
 		if err != nil {
 			return nil, err
 		}
 		defaultTTL = userTokenConfig.DefaultTTL
 		maxTTL = userTokenConfig.MaxTTL
-	} else {
+	default:
 		return nil, fmt.Errorf("error during renew: token has got no role nor username")
 	}
 
+	// The token of a refreshed lease is replaced by the one of a new lease, and the token of a lease may be revoked
+	// with revoke/, these leases are left to expire
+	issued, err := fetchIssuedToken(ctx, req.Storage, source.TokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case issued == nil:
+	case issued.RefreshedTo != "":
+		return logical.ErrorResponse("token was refreshed, renew the lease of token %s instead", issued.RefreshedTo), nil
+	case !issued.RevokedAt.IsZero():
+		return logical.ErrorResponse("token %s was revoked", issued.TokenID), nil
+	case issued.LeaseID == "":
+		b.recordIssuedTokenLease(ctx, req.Storage, issued.TokenID, req.Secret.LeaseID)
+	}
+
 	ttl, warnings, err :=
 		framework.CalculateTTL(b.System(), req.Secret.Increment, defaultTTL, 0, maxTTL, req.Secret.MaxTTL, req.Secret.IssueTime)
 	if err != nil {
@@ -105,47 +134,88 @@ func (b *backend) secretAccessTokenRenew(ctx context.Context, req *logical.Reque
 func (b *backend) secretAccessTokenRevoke(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
 	logger := b.Logger().With("func", "secretAccessTokenRevoke")
 
-	config, err := b.fetchAdminConfiguration(ctx, req.Storage)
+	source := leaseSourceFromSecret(req.Secret)
+
+	b.recordIssuedTokenLease(ctx, req.Storage, source.TokenID, req.Secret.LeaseID)
+
+	credentials, err := b.revocationCredentials(ctx, req.Storage, source)
 	if err != nil {
-		logger.Debug("failed to fetch admin config", "err", err)
+		logger.Debug("failed to fetch config", "err", err)
 		return nil, err
 	}
 
-	if config == nil {
-		return logical.ErrorResponse("backend not configured"), nil
+	err = b.revokeTokenWithCredentials(ctx, credentials, source.Connection, source.TokenID)
+	if err == nil {
+		b.markIssuedTokenRevoked(ctx, req.Storage, source.TokenID, false)
+		return nil, nil
 	}
 
-	// logger.Debug("req", "Path", req.Path, "Secret.InternalData", req.Secret.InternalData)
-
-	if config.AccessToken == "" {
-		// check if this is admin token
-		if strings.HasPrefix(req.Path, "token/") {
-			return logical.ErrorResponse("admin access_token is not configured"), nil
-		}
-
-		// try to use user token
-		if strings.HasPrefix(req.Path, "user_token/") {
-			logger.Debug("admin access token is empty and request path is user_token")
-			username := req.Secret.InternalData["username"].(string)
-			userTokenConfig, err := b.fetchUserTokenConfiguration(ctx, req.Storage, username)
-			if err != nil {
-				logger.Debug("failed to fetch user config", "err", err)
-				return nil, err
-			}
-
-			if userTokenConfig.AccessToken == "" {
-				return logical.ErrorResponse("user access_token is not configured"), nil
-			}
-
-			config.AccessToken = userTokenConfig.AccessToken
-		}
+	if source.TokenID == "" {
+		return logical.ErrorResponse("failed to revoke access token"), err
 	}
 
-	tokenId := req.Secret.InternalData["token_id"].(string)
+	// Retry in the background, rather than have Vault give up on the lease after a few attempts
+	logger.Warn("failed to revoke access token, queued to retry", "tokenId", source.TokenID, "err", err)
 
-	if err := b.RevokeToken(config.baseConfiguration, tokenId); err != nil {
+	if err := b.queueFailedRevocation(ctx, req.Storage, source, err); err != nil {
 		return logical.ErrorResponse("failed to revoke access token"), err
 	}
 
 	return nil, nil
 }
+
+// secretConnection returns the name of the connection that issued the token. Tokens issued before
+// named connections were introduced were issued with config/admin.
+func secretConnection(secret *logical.Secret) string {
+	name, _ := secret.InternalData["connection"].(string)
+	return name
+}
+
+// leaseSource describes the credential the token of a lease was issued with, as recorded in the internal data of the
+// lease
+type leaseSource struct {
+	// Version is the version of the internal data, leaseInternalDataVersion for the leases issued by this version of
+	// the plugin
+	Version int
+	// Source is leaseSourceRole for tokens created for a role, or leaseSourceUserToken for user tokens
+	Source string
+	Role   string
+	// UserTokenConfig is the username of the user token configuration a user token was created with, empty for the
+	// default configuration
+	UserTokenConfig string
+	Connection      string
+	TokenID         string
+}
+
+// leaseSourceFromSecret returns where the token of the lease comes from. The source of leases issued before it was
+// recorded is inferred from their role, or the username of user tokens.
+func leaseSourceFromSecret(secret *logical.Secret) leaseSource {
+	source := leaseSource{
+		Version:    1,
+		Connection: secretConnection(secret),
+	}
+	source.TokenID, _ = secret.InternalData["token_id"].(string)
+	source.Role, _ = secret.InternalData["role"].(string)
+
+	// The version is a number of any type once the lease is read back from storage
+	var version int
+	if err := mapstructure.WeakDecode(secret.InternalData["version"], &version); err == nil && version > 0 {
+		source.Version = version
+	}
+
+	if source.Version >= leaseInternalDataVersion {
+		source.Source, _ = secret.InternalData["source"].(string)
+		source.UserTokenConfig, _ = secret.InternalData["user_token_config"].(string)
+		return source
+	}
+
+	if _, ok := secret.InternalData["role"]; ok {
+		source.Source = leaseSourceRole
+	} else if username, ok := secret.InternalData["username"].(string); ok {
+		// User tokens were created with the configuration of their username, or the default one
+		source.Source = leaseSourceUserToken
+		source.UserTokenConfig = username
+	}
+
+	return source
+}
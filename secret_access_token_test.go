@@ -0,0 +1,115 @@
+package artifactory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/vault-plugin-secrets-artifactory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseSourceFromSecret(t *testing.T) {
+	for name, tc := range map[string]struct {
+		internalData map[string]interface{}
+		source       leaseSource
+	}{
+		"legacy role": {
+			internalData: map[string]interface{}{"role": "test-role", "username": "test-username", "token_id": "test-token-id"},
+			source:       leaseSource{Version: 1, Source: leaseSourceRole, Role: "test-role", TokenID: "test-token-id"},
+		},
+		"legacy user token": {
+			internalData: map[string]interface{}{"username": "test-username", "token_id": "test-token-id"},
+			source:       leaseSource{Version: 1, Source: leaseSourceUserToken, UserTokenConfig: "test-username", TokenID: "test-token-id"},
+		},
+		"role": {
+			internalData: map[string]interface{}{
+				"version":    json.Number("2"),
+				"source":     leaseSourceRole,
+				"role":       "test-role",
+				"connection": "other",
+				"token_id":   "test-token-id",
+			},
+			source: leaseSource{Version: 2, Source: leaseSourceRole, Role: "test-role", Connection: "other", TokenID: "test-token-id"},
+		},
+		"user token with the default configuration": {
+			internalData: map[string]interface{}{
+				"version":           float64(2),
+				"source":            leaseSourceUserToken,
+				"user_token_config": "",
+				"username":          "test-username",
+				"token_id":          "test-token-id",
+			},
+			source: leaseSource{Version: 2, Source: leaseSourceUserToken, TokenID: "test-token-id"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.source, leaseSourceFromSecret(&logical.Secret{InternalData: tc.internalData}))
+		})
+	}
+}
+
+// Test that a lease is revoked with the next credential when the one it was issued with can't revoke it anymore.
+func TestBackend_RevokeLeaseFallsBackToOtherCredentials(t *testing.T) {
+	fake := &fakeArtifactory{
+		revokeToken: func(_ context.Context, config client.Config, _ string) error {
+			if config.AccessToken != "test-user-token" {
+				return &client.StatusError{StatusCode: http.StatusUnauthorized, Err: errors.New("bad credentials")}
+			}
+			return nil
+		},
+	}
+
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-admin-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/user_token",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"access_token": "test-user-token",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	for name, internalData := range map[string]map[string]interface{}{
+		// Issued before the source was recorded
+		"legacy role": {
+			"role":     "test-role",
+			"token_id": "legacy-role-token-id",
+		},
+		"legacy user token": {
+			"username": "test-username",
+			"token_id": "legacy-user-token-id",
+		},
+		"role": {
+			"version":  leaseInternalDataVersion,
+			"source":   leaseSourceRole,
+			"role":     "test-role",
+			"token_id": "role-token-id",
+		},
+	} {
+		internalData["secret_type"] = SecretArtifactoryAccessTokenType
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Path:      "reorganized/path",
+			Secret:    &logical.Secret{InternalData: internalData},
+			Storage:   config.StorageView,
+		})
+		assert.NoError(t, err, name)
+		assert.Nil(t, resp, name)
+	}
+
+	assert.Equal(t, 2, fake.callCount("RevokeToken legacy-role-token-id"))
+	assert.Equal(t, 1, fake.callCount("RevokeToken legacy-user-token-id"), "revoked with the user token configuration first")
+	assert.Equal(t, 2, fake.callCount("RevokeToken role-token-id"))
+	assert.Empty(t, readPendingRevocations(t, b, config.StorageView))
+}
@@ -50,7 +50,7 @@ func (e *accTestEnv) createNewTestToken(t *testing.T) (string, string) {
 
 	e.Backend.(*backend).InitializeHttpClient(&config)
 
-	resp, err := e.Backend.(*backend).CreateToken(config.baseConfiguration, role)
+	resp, err := e.Backend.(*backend).CreateToken(e.Context, config.baseConfiguration, role)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -77,7 +77,7 @@ func (e *accTestEnv) createNewNonAdminTestToken(t *testing.T) (string, string) {
 
 	e.Backend.(*backend).InitializeHttpClient(&config)
 
-	resp, err := e.Backend.(*backend).CreateToken(config.baseConfiguration, role)
+	resp, err := e.Backend.(*backend).CreateToken(e.Context, config.baseConfiguration, role)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -91,7 +91,7 @@ func (e *accTestEnv) revokeTestToken(t *testing.T, tokenID string) {
 		ArtifactoryURL: e.URL,
 	}
 
-	err := e.Backend.(*backend).RevokeToken(config, tokenID)
+	err := e.Backend.(*backend).RevokeToken(e.Context, config, tokenID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -599,6 +599,17 @@ const artVersion = `{
     "license": "05179b957028fa9aa1ceb88da6519a245e55b9fc5"
 }`
 
+// Literally https://jfrog.com/help/r/jfrog-rest-apis/get-token-by-id
+const tokenDetails = `{
+   "token_id":    "59e39159-19eb-463d-953d-1d6baf567db6",
+   "subject":     "jfac@01g5hek6kb29520rbz71v91cw9/users/admin",
+   "expiry":      1686780828,
+   "issued_at":   1655244828,
+   "issuer":      "jfac@01g5hek6kb29520rbz71v91cw9",
+   "description": "token description",
+   "refreshable": false
+}`
+
 func makeBackend(t *testing.T) (*backend, *logical.BackendConfig) {
 	config := logical.TestBackendConfig()
 	config.StorageView = &logical.InmemStorage{}
@@ -608,6 +619,9 @@ func makeBackend(t *testing.T) (*backend, *logical.BackendConfig) {
 		t.Fatal(err)
 	}
 
+	// Report usage before the request returns, while the mocks of the test are active
+	b.reportUsage = b.sendUsage
+
 	if err := b.Setup(context.Background(), config); err != nil {
 		t.Fatal(err)
 	}
@@ -651,5 +665,16 @@ func mockArtifactoryTokenRequest() {
 	httpmock.RegisterResponder(
 		http.MethodGet,
 		"http://myserver.com:80/access/api/v1/tokens/me",
-		httpmock.NewStringResponder(200, ""))
-}
+		httpmock.NewStringResponder(200, tokenDetails))
+}
+
+// Valid jwt Access Token, signed by rootCert
+// TokenID: 1079485d-5a29-41cd-968e-e42fe924a521
+const signedAdminAccessToken = "eyJ2ZXIiOiIyIiwidHlwIjoiSldUIiwiYWxnIjoiUlMyNTYiLCJraWQiOiJRbVVvVnRxVXhfMVRIS1hCVllQWlZnaUI5bj" +
+	"B2b3JkVWl4bkZ0MWVJcFFVIn0.eyJpc3MiOiJqZnN1cHBvcnRAMDFrNGt4MDd6M3FhNWZlaGRyODZuMmNrdzkiLCJzdWIiOiJqZmFjQDAx" +
+	"aDQyNGh2d3B5dHprMWF6eGg2azgwN2U1L3VzZXJzL2FkbWluIiwic2NwIjoiYXBwbGllZC1wZXJtaXNzaW9ucy9hZG1pbiIsImF1ZCI6Ii" +
+	"pAKiIsImlhdCI6MTc1NzMxMTk5OSwianRpIjoiMTA3OTQ4NWQtNWEyOS00MWNkLTk2OGUtZTQyZmU5MjRhNTIxIn0.QxdKshgKzvjZo-ZE" +
+	"2Qekj6brp8us5_uUpKxniXwssnXpE8N5VLOsyk3zEXVGdsI7jDne4W8a0pj0f_0AgstWSRQoNGsG3njh-kKj3G89Aq4OkPKG6gaMOUJnlO" +
+	"dJiuMpDu6kCAvtY_rvJ6nUHn9RgEhO1OeiGknrJ5L9iJiY_X7Gplyr8ivFzxsaWhIRTlvGdALWTca1l-Eczp3AuSxW65Q6uA357pLUzA2a" +
+	"DL01R9CQ9dLZ_TxCVE7QP3mqbayc_uIj288OeR7RdEGvHsNLoF-HA8JlQhE-ZE3o9xN2Q4wxJc7GGSybXYdbP2jPvnP2nVwMGvXKZMbSyM" +
+	"2gBVIODw"
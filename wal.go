@@ -0,0 +1,169 @@
+package artifactory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	walTypeAccessToken = "accessToken"
+
+	// walRollbackMinAgeOption is the mount option setting how old a WAL entry must be before it is rolled back
+	walRollbackMinAgeOption  = "wal_rollback_min_age"
+	defaultWALRollbackMinAge = 10 * time.Minute
+)
+
+// walAccessToken is a token created in Artifactory, written to the WAL until it is returned in a lease or stored
+// in a configuration. If the request fails before that, the token is revoked by walRollback.
+type walAccessToken struct {
+	TokenID    string `json:"token_id" mapstructure:"token_id"`
+	Connection string `json:"connection,omitempty" mapstructure:"connection"`
+	// UserToken is set for tokens created with the user token configuration of Username
+	UserToken bool   `json:"user_token,omitempty" mapstructure:"user_token"`
+	Username  string `json:"username,omitempty" mapstructure:"username"`
+	// Rotated is set for tokens replacing the access token of a configuration. They are not revoked once stored.
+	Rotated bool `json:"rotated,omitempty" mapstructure:"rotated"`
+}
+
+// source returns where the token comes from, to revoke it with the same credentials as a lease
+func (t walAccessToken) source() leaseSource {
+	source := leaseSource{
+		Source:     leaseSourceRole,
+		Connection: t.Connection,
+		TokenID:    t.TokenID,
+	}
+
+	if t.UserToken {
+		source.Source = leaseSourceUserToken
+		source.UserTokenConfig = t.Username
+	}
+
+	return source
+}
+
+// walRollbackMinAge returns the wal_rollback_min_age mount option, or its default
+func walRollbackMinAge(conf *logical.BackendConfig) (time.Duration, error) {
+	value, ok := conf.Config[walRollbackMinAgeOption]
+	if !ok || value == "" {
+		return defaultWALRollbackMinAge, nil
+	}
+
+	minAge, err := parseutil.ParseDurationSecond(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", walRollbackMinAgeOption, err)
+	}
+
+	return minAge, nil
+}
+
+// putAccessTokenWAL writes token, created with config, to the WAL and returns the ID of the entry. If the entry
+// can't be written, the token is revoked right away. Tokens without an ID can't be revoked, they are not written and
+// the returned ID is empty.
+func (b *backend) putAccessTokenWAL(ctx context.Context, storage logical.Storage, config baseConfiguration, token walAccessToken) (string, error) {
+	if token.TokenID == "" {
+		return "", nil
+	}
+
+	walID, err := framework.PutWAL(ctx, storage, walTypeAccessToken, &token)
+	if err != nil {
+		if revokeErr := b.RevokeToken(ctx, config, token.TokenID); revokeErr != nil {
+			b.Logger().With("func", "putAccessTokenWAL").Error("unable to revoke token", "tokenId", token.TokenID, "err", revokeErr)
+		}
+		return "", err
+	}
+
+	return walID, nil
+}
+
+// deleteAccessTokenWAL deletes the WAL entry written by putAccessTokenWAL, once the token is returned or stored
+func (b *backend) deleteAccessTokenWAL(ctx context.Context, storage logical.Storage, walID string) error {
+	if walID == "" {
+		return nil
+	}
+
+	return framework.DeleteWAL(ctx, storage, walID)
+}
+
+// walRollback is called by Vault for the WAL entries older than WALRollbackMinAge, left by requests that failed
+// after creating a token. The entry is deleted unless an error is returned.
+func (b *backend) walRollback(ctx context.Context, req *logical.Request, kind string, data interface{}) error {
+	switch kind {
+	case walTypeAccessToken:
+		var token walAccessToken
+		if err := mapstructure.Decode(data, &token); err != nil {
+			return err
+		}
+
+		return b.rollbackAccessToken(ctx, req, token)
+	default:
+		return fmt.Errorf("unknown WAL entry type %q", kind)
+	}
+}
+
+// rollbackAccessToken revokes a token that was not returned in a lease, or not stored in a configuration
+func (b *backend) rollbackAccessToken(ctx context.Context, req *logical.Request, token walAccessToken) error {
+	logger := b.Logger().With("func", "rollbackAccessToken", "connection", token.Connection, "tokenId", token.TokenID)
+
+	config, err := b.fetchConnectionConfiguration(ctx, req.Storage, token.Connection)
+	if err != nil {
+		return err
+	}
+
+	if config == nil {
+		logger.Warn("connection is no longer configured, unable to revoke token")
+		return nil
+	}
+
+	// The request may have failed after storing the rotated token, which must not be revoked then
+	if token.Rotated {
+		baseConfig := config.baseConfiguration
+		configured := config.AccessToken
+		if token.UserToken {
+			userTokenConfig, err := b.fetchUserTokenConfiguration(ctx, req.Storage, token.Username)
+			if err != nil {
+				return err
+			}
+
+			configured = userTokenConfig.AccessToken
+			if baseConfig.AccessToken == "" {
+				baseConfig.AccessToken = configured
+			}
+		}
+
+		if configured != "" {
+			info, err := b.getTokenInfo(ctx, baseConfig, configured)
+			if err != nil {
+				return err
+			}
+
+			if info.TokenID == token.TokenID {
+				logger.Debug("rotated token was stored, nothing to roll back")
+				return nil
+			}
+		}
+	}
+
+	credentials, err := b.revocationCredentials(ctx, req.Storage, token.source())
+	if err != nil {
+		return err
+	}
+
+	if len(credentials) == 0 {
+		logger.Warn("no access token is configured, unable to revoke token")
+		return nil
+	}
+
+	if err := b.revokeTokenWithCredentials(ctx, credentials, token.Connection, token.TokenID); err != nil {
+		return fmt.Errorf("error revoking token %s: %w", token.TokenID, err)
+	}
+
+	logger.Info("revoked token left by a failed request")
+
+	return nil
+}
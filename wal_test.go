@@ -0,0 +1,132 @@
+package artifactory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+func rollbackImmediately(t *testing.T, b *backend, storage logical.Storage) {
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RollbackOperation,
+		Storage:   storage,
+		Data:      map[string]interface{}{"immediate": true},
+	})
+	assert.NoError(t, err)
+}
+
+func TestBackend_CreateTokenWAL(t *testing.T) {
+	fake := &fakeArtifactory{}
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": "test-admin-token",
+		"url":          "http://myserver.com:80",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/test-role",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"username": "test-username",
+			"scope":    "test-scope",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	// The WAL entry is deleted once the token is returned
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "token/test-role",
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+
+	walIDs, err := framework.ListWAL(context.Background(), config.StorageView)
+	assert.NoError(t, err)
+	assert.Empty(t, walIDs)
+
+	// The token is revoked right away if it can't be written to the WAL
+	storage := config.StorageView.(*logical.InmemStorage)
+	storage.FailPut(true)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "token/test-role",
+		Storage:   storage,
+	})
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+	assert.Equal(t, 1, fake.callCount("RevokeToken 59e39159-19eb-463d-953d-1d6baf567db6"))
+
+	storage.FailPut(false)
+}
+
+func TestBackend_WALRollbackRevokesToken(t *testing.T) {
+	fake := &fakeArtifactory{}
+	b, config := fakeConfiguredBackend(t, fake, map[string]interface{}{
+		"access_token": signedAdminAccessToken,
+		"url":          "http://myserver.com:80",
+	})
+
+	adminConfig, err := b.fetchAdminConfiguration(context.Background(), config.StorageView)
+	assert.NoError(t, err)
+
+	for _, token := range []walAccessToken{
+		{TokenID: "orphan-token-id"},
+		{TokenID: "orphan-rotated-token-id", Rotated: true},
+		// Stored before the request failed, it is in use
+		{TokenID: "1079485d-5a29-41cd-968e-e42fe924a521", Rotated: true},
+	} {
+		_, err := b.putAccessTokenWAL(context.Background(), config.StorageView, adminConfig.baseConfiguration, token)
+		assert.NoError(t, err)
+	}
+
+	// Not old enough to be rolled back yet
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RollbackOperation,
+		Storage:   config.StorageView,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fake.callCount("RevokeToken orphan-token-id"))
+
+	rollbackImmediately(t, b, config.StorageView)
+
+	assert.Equal(t, 1, fake.callCount("RevokeToken orphan-token-id"))
+	assert.Equal(t, 1, fake.callCount("RevokeToken orphan-rotated-token-id"))
+	assert.Equal(t, 0, fake.callCount("RevokeToken 1079485d-5a29-41cd-968e-e42fe924a521"))
+
+	walIDs, err := framework.ListWAL(context.Background(), config.StorageView)
+	assert.NoError(t, err)
+	assert.Empty(t, walIDs)
+}
+
+func TestBackend_WALRollbackMinAge(t *testing.T) {
+	for _, tc := range []struct {
+		option string
+		minAge time.Duration
+	}{
+		{"", defaultWALRollbackMinAge},
+		{"5m", 5 * time.Minute},
+		{"120", 2 * time.Minute},
+	} {
+		config := logical.TestBackendConfig()
+		config.StorageView = &logical.InmemStorage{}
+		config.Config[walRollbackMinAgeOption] = tc.option
+
+		b, err := Factory(context.Background(), config)
+		assert.NoError(t, err, tc.option)
+		assert.Equal(t, tc.minAge, b.(*backend).WALRollbackMinAge, tc.option)
+	}
+
+	config := logical.TestBackendConfig()
+	config.Config[walRollbackMinAgeOption] = "soon"
+
+	_, err := Factory(context.Background(), config)
+	assert.ErrorContains(t, err, "invalid wal_rollback_min_age")
+}